@@ -3,17 +3,52 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/audit"
 	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
 	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
 	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
 )
 
-func assertUacWebhookExists() (*uac.Webhook, error) {
+const (
+	// webhookRetryMinDelay is how long after startup the webhook registration on an unreachable UAC
+	// controller is retried, the delay doubles with every failed attempt up to webhookRetryMaxDelay.
+	webhookRetryMinDelay = 5 * time.Second
+	webhookRetryMaxDelay = 5 * time.Minute
+)
+
+// uacWebhookPath returns the route of the webhook of a UAC controller: /webhook/uac for the
+// default controller and /webhook/uac/<name> for the others.
+func uacWebhookPath(controller string) string {
+	if controller == config.DefaultUACController {
+		return "/webhook/uac"
+	}
+	return "/webhook/uac/" + controller
+}
+
+// hubitatWebhookPath returns the route of the webhook of a Hubitat hub: /webhook/hubitat for
+// the default hub and /webhook/hubitat/<name> for the others.
+func hubitatWebhookPath(hub string) string {
+	if hub == config.DefaultHubitatHub {
+		return "/webhook/hubitat"
+	}
+	return "/webhook/hubitat/" + hub
+}
+
+// assertUacWebhookExists creates or updates the webhook of the middleware on a UAC controller.
+func assertUacWebhookExists(controller string, uacClient *uac.Client) (*uac.Webhook, error) {
+	appConfig := getAppConfig()
+
 	// Check if the webhook exists
 	webhooks, err := uacClient.FetchWebhookEndpoints()
 	if err != nil {
@@ -22,10 +57,10 @@ func assertUacWebhookExists() (*uac.Webhook, error) {
 
 	newWebhook := uac.Webhook{
 		Name:     "unifi-access-hubitat-middleware",
-		Endpoint: fmt.Sprintf("%s/webhook/uac", appConfig.Server.BaseURL),
-		Events:   []string{"access.device.dps_status", "access.door.unlock"}, // todo "access.temporary_unlock.start", "access.temporary_unlock.end"},
+		Endpoint: appConfig.Server.BaseURL + uacWebhookPath(controller),
+		Events:   []string{"access.device.dps_status", "access.door.unlock", "access.device.emergency_status"}, // todo "access.temporary_unlock.start", "access.temporary_unlock.end"},
 		Headers: map[string]string{
-			"Authorization": appConfig.Server.AuthToken,
+			"Authorization": appConfig.Server.UACTokens()[0],
 		},
 	}
 
@@ -34,21 +69,23 @@ func assertUacWebhookExists() (*uac.Webhook, error) {
 			// Check if fields match
 			if webhook.Endpoint != newWebhook.Endpoint || !utils.StringSlicesEqual(webhook.Events, newWebhook.Events) ||
 				!utils.StringMapsEqual(webhook.Headers, newWebhook.Headers) {
-				logger.Info("UAC webhook exists but fields differ, updating", slog.String("webhook_id", *webhook.ID))
+				logger.Info("UAC webhook exists but fields differ, updating", slog.String("controller", controller), slog.String("webhook_id", *webhook.ID))
 				updated, err := uacClient.UpdateWebhookEndpoint(*webhook.ID, &newWebhook)
+				recordAudit(audit.Record{Action: "webhook_update", Source: "app", Trigger: "startup " + uacUpstreamName(controller)}, err)
 				if err != nil {
 					return nil, fmt.Errorf("failed to update UAC webhook endpoint: %w", err)
 				}
 				return updated, nil
 			}
-			logger.Info("UAC Webhook already exists and matches configuration", slog.String("webhook_id", *webhook.ID))
+			logger.Info("UAC Webhook already exists and matches configuration", slog.String("controller", controller), slog.String("webhook_id", *webhook.ID))
 			return &webhook, nil // Webhook already exists and matches
 		}
 	}
 
 	// Create the webhook if it doesn't exist
-	logger.Info("UAC webhook does not exist, creating new webhook")
+	logger.Info("UAC webhook does not exist, creating new webhook", slog.String("controller", controller))
 	createdWebhook, err := uacClient.AddWebhookEndpoint(&newWebhook)
+	recordAudit(audit.Record{Action: "webhook_create", Source: "app", Trigger: "startup " + uacUpstreamName(controller)}, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create UAC webhook endpoint: %w", err)
 	}
@@ -56,8 +93,76 @@ func assertUacWebhookExists() (*uac.Webhook, error) {
 	return createdWebhook, nil
 }
 
-func handleUacEvent(evt uac.WebhookEvent) {
-	logger.Info("Received UAC Event", slog.Any("event", evt))
+// uacWebhookRoute serves the webhook of a UAC controller. The events are signed with the secret
+// of the registration, so until the controller could be reached they are answered with 503.
+type uacWebhookRoute struct {
+	controller string
+	handler    atomic.Pointer[http.Handler]
+}
+
+func (r *uacWebhookRoute) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h := r.handler.Load()
+	if h == nil {
+		writeError(w, http.StatusServiceUnavailable, "the webhook of "+uacUpstreamName(r.controller)+" is not registered yet")
+		return
+	}
+	(*h).ServeHTTP(w, req)
+}
+
+// registered reports whether the webhook is registered on the controller.
+func (r *uacWebhookRoute) registered() bool {
+	return r.handler.Load() != nil
+}
+
+// register creates or updates the webhook on the controller and starts accepting its events.
+func (r *uacWebhookRoute) register(wg *sync.WaitGroup) error {
+	webhook, err := assertUacWebhookExists(r.controller, uacClients[r.controller])
+	if err != nil {
+		return err
+	}
+	onEvent := func(evt uac.WebhookEvent) { handleUacEvent(r.controller, evt) }
+	var h http.Handler = uac.NewWebhookHandler(*webhook.Secret, authorizer((*config.Server).UACTokens), onEvent, wg)
+	r.handler.Store(&h)
+	return nil
+}
+
+// retryUacWebhook registers the webhook of a controller that was unreachable at startup, with a
+// growing delay between attempts, then reloads the config so its doors are verified and its door
+// groups resolved.
+func retryUacWebhook(ctx context.Context, wg *sync.WaitGroup, r *uacWebhookRoute, configPath string) {
+	defer wg.Done()
+	delay := webhookRetryMinDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		err := r.register(wg)
+		if err == nil {
+			logger.Info("UAC controller is reachable again, webhook registered", slog.String("controller", r.controller))
+			_ = reloadConfig(configPath, "controller "+r.controller)
+			return
+		}
+		delay = min(2*delay, webhookRetryMaxDelay)
+		logger.Warn("UAC controller is still unreachable, retrying the webhook registration",
+			slog.String("controller", r.controller), slog.Duration("retry_in", delay), slog.String("err", err.Error()))
+	}
+}
+
+// handleUacEvent handles an event sent by a UAC controller. Only the doors of that controller are affected.
+func handleUacEvent(controller string, evt uac.WebhookEvent) {
+	logger.Info("Received UAC Event", slog.String("controller", controller), slog.Any("event", evt))
+	controllerDoor := func(uacID string) (*config.Door, bool) {
+		door, found := getDoorByUacID(uacID)
+		if !found || door.Controller() != controller {
+			return nil, false
+		}
+		return door, true
+	}
+	if !evt.SignedAt.IsZero() {
+		webhookDelay.Observe(time.Since(evt.SignedAt).Seconds())
+	}
 
 	switch evt.Event {
 	case "access.door.unlock":
@@ -81,27 +186,52 @@ func handleUacEvent(evt uac.WebhookEvent) {
 			logger.Info("Door unlock event triggered by API, ignoring", slog.Any("event", evt))
 			return
 		}
+		door, found := controllerDoor(payload.Location.ID)
 		if payload.Object.Result != "Access Granted" {
 			logger.Info("Door unlock event not granted, ignoring", slog.Any("event", evt))
+			if found {
+				publishAccessEvent(door, "access_denied", payload.Actor.Name, payload.Object.Result)
+			}
 			return
 		}
-
-		door, found := getDoorByUacID(payload.Location.ID)
 		if !found {
 			logger.Warn("Door not found for UAC ID", slog.Any("event", evt))
 			return
 		}
+		recordDoorEvent(door, evt.Event)
+		publishAccessEvent(door, "unlock", payload.Actor.Name, payload.Object.Result)
+
+		backend, err := backendOf(door)
+		if err != nil {
+			logger.Error("No backend for door", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+			recordDoorError(door, err)
+			return
+		}
 
-		// sleep for 200 milliseconds to allow the door lock to actually unlock
-		time.Sleep(200 * time.Millisecond)
-		err := hubitatClient.AssertDoorSwitchOn(door.HubitatSwitchID)
+		// allow the door lock to actually unlock
+		time.Sleep(door.Options.SettleDelay())
+		switchID := door.SwitchID()
+		err = backend.AssertSwitch(switchID, true)
 		if err != nil {
-			logger.Error("Failed to assert door switch on in Hubitat",
+			logger.Error("Failed to assert door switch on",
 				slog.Any("event", evt),
 				slog.String("err", err.Error()),
-				slog.String("hubitat_switch_id", door.HubitatSwitchID))
+				slog.String("backend", door.BackendName()),
+				slog.String("switch_id", switchID))
+			recordDoorError(door, err)
 			return
 		}
+		if pulse := door.Options.SwitchPulse; pulse > 0 {
+			time.AfterFunc(pulse, func() {
+				if err := backend.AssertSwitch(switchID, false); err != nil {
+					logger.Error("Failed to assert door switch off",
+						slog.String("err", err.Error()),
+						slog.String("backend", door.BackendName()),
+						slog.String("switch_id", switchID))
+					recordDoorError(door, err)
+				}
+			})
+		}
 	case "access.device.dps_status":
 		var payload struct {
 			Location struct {
@@ -121,29 +251,62 @@ func handleUacEvent(evt uac.WebhookEvent) {
 			return
 		}
 
-		door, found := getDoorByUacID(payload.Location.ID)
+		door, found := controllerDoor(payload.Location.ID)
 		if !found {
 			logger.Warn("Door not found for UAC ID", slog.Any("event", evt))
 			return
 		}
+		recordDoorEvent(door, evt.Event)
 
-		var err error
+		var open bool
 		if payload.Object.Status == "open" {
-			err = hubitatClient.AssertDoorContactOpened(door.HubitatContactID)
-		} else if payload.Object.Status == "close" {
-			err = hubitatClient.AssertDoorContactClosed(door.HubitatContactID)
-		} else {
+			open = true
+		} else if payload.Object.Status != "close" {
 			logger.Error("Unknown door status", slog.Any("event", evt))
 			return
 		}
+		trackDoorPosition(door, open)
+		setDoorGauge(doorOpen, door, open)
+		err := assertContact(door, open)
+		publishDoorEvent(door, "uac", "position", payload.Object.Status, "")
+		if open {
+			checkForcedOpen(door)
+		}
 
 		if err != nil {
-			logger.Error("Failed to assert door status in hubitat",
+			logger.Error("Failed to assert door status",
 				slog.Any("event", evt),
 				slog.String("err", err.Error()),
-				slog.String("hubitat_contact_id", door.HubitatContactID))
+				slog.String("backend", door.BackendName()),
+				slog.String("contact_id", door.ContactID()))
+			recordDoorError(door, err)
 			return
 		}
+	case "access.device.emergency_status":
+		var payload struct {
+			Location struct {
+				ID string `json:"id"`
+			} `json:"location"`
+			Object struct {
+				EventType string `json:"event_type"`
+				Status    string `json:"status"`
+			} `json:"object"`
+		}
+		if err := json.Unmarshal(evt.Data, &payload); err != nil {
+			logger.Error("Failed to unmarshal event data", slog.String("err", err.Error()))
+			return
+		}
+		status := payload.Object.Status
+		if status == "" {
+			status = payload.Object.EventType
+		}
+		logger.Warn("UAC emergency status changed", slog.String("status", status), slog.String("location_id", payload.Location.ID))
+		// emergencies usually apply to a whole hub or site rather than a configured door
+		if door, found := controllerDoor(payload.Location.ID); found {
+			publishDoorEvent(door, "uac", "emergency", status, "")
+		} else {
+			publishAppEvent("uac", "emergency", status, "")
+		}
 	// todo implement temporary unlock events
 	//case "access.temporary_unlock.start":
 	//case "access.temporary_unlock.end":
@@ -153,75 +316,89 @@ func handleUacEvent(evt uac.WebhookEvent) {
 	}
 }
 
-func handleHubitatEvent(evt hubitat.WebhookEvent) {
-	logger.Info("Received Hubitat Event", slog.Any("event", evt))
+// handleHubitatEvent handles an event posted by the Maker API of a Hubitat hub.
+func handleHubitatEvent(hub string, evt hubitat.WebhookEvent) {
+	logger.Info("Received Hubitat Event", slog.String("hub", hub), slog.Any("event", evt))
 
-	door, deviceType, found := getDoorByHubitatID(evt.Content.DeviceID)
+	door, deviceType, found := getDoorByDevice(config.HubitatInstance(hub), evt.Content.DeviceID)
 	if !found {
-		logger.Error("Door not found for Hubitat ID", slog.Any("event", evt))
+		if group, deviceType, found := getGroupByDevice(config.HubitatInstance(hub), evt.Content.DeviceID); found {
+			handleGroupEvent(group, deviceType, evt.Content.DeviceID, evt.Content.Value)
+			return
+		}
+		logger.Error("Door not found for Hubitat ID", slog.String("hub", hub), slog.Any("event", evt))
 		return
 	}
+	handleDeviceEvent(door, config.BackendHubitat, deviceType, evt.Content.DeviceID, evt.Content.Value)
+}
+
+// handleDeviceEvent carries out the UAC command for a state change of a device mapped to a door:
+// turning the switch on unlocks the door, and the lock keeps it unlocked or locks it.
+func handleDeviceEvent(door *config.Door, backend, deviceType, deviceID, value string) {
+	recordDoorEvent(door, backend+"."+deviceType+"."+value)
+	publishDoorEvent(door, backend, deviceType, value, "")
 
 	var err error
+	var command string // the command issued to UAC, if any
 
 	switch deviceType {
 	case "switch":
-		if evt.Content.Value == "on" {
-			err = uacClient.AssertToggleDoorUnlock(door.UacID)
+		if value == "on" {
+			command = "unlock"
+			err = uacFor(door).AssertToggleDoorUnlock(door.UacID)
 		}
 	case "lock":
-		if evt.Content.Value == "unlocked" {
-			err = uacClient.AssertUnlockDoor(door.UacID)
-		} else if evt.Content.Value == "locked" {
-			err = uacClient.AssertLockDoor(door.UacID)
+		if value == "unlocked" {
+			command = "keep_unlock"
+			err = uacFor(door).AssertUnlockDoor(door.UacID)
+		} else if value == "locked" {
+			command = "lock"
+			err = uacFor(door).AssertLockDoor(door.UacID)
 		} else {
-			logger.Error("Unknown lock value", slog.Any("event", evt))
+			logger.Error("Unknown lock value", slog.String("backend", backend),
+				slog.String("device_id", deviceID), slog.String("value", value))
 			return
 		}
 	case "contact":
 		// no action needed for contact sensor events
 	default:
-		logger.Warn("Unknown Hubitat event", slog.Any("event", evt))
+		logger.Warn("Unknown device event", slog.String("backend", backend), slog.String("device_type", deviceType))
 	}
 
+	if command != "" {
+		trigger := fmt.Sprintf("%s %s %s (device %s)", backend, deviceType, value, deviceID)
+		recordAudit(doorAudit(door, command, backend, "", trigger), err)
+	}
 	if err != nil {
-		logger.Error("Failed to execute Hubitat event action",
-			slog.Any("event", evt),
+		logger.Error("Failed to execute device event action",
+			slog.String("backend", backend),
+			slog.String("device_id", deviceID),
+			slog.String("value", value),
 			slog.String("err", err.Error()),
 			slog.Any("door", door))
+		recordDoorError(door, err)
+		return
+	}
+	if command != "" {
+		publishDoorEvent(door, "app", "command", command, backend)
+	}
+}
+
+// assertContact sets the contact sensor of a door in its backend.
+func assertContact(door *config.Door, open bool) error {
+	backend, err := backendOf(door)
+	if err != nil {
+		return err
 	}
+	return backend.AssertContact(door.ContactID(), open)
 }
 
-func pollUacStates(ctx context.Context, wg *sync.WaitGroup) {
+// pollUacStates keeps the doors of a UAC controller in sync until ctx is cancelled.
+func pollUacStates(ctx context.Context, wg *sync.WaitGroup, controller string) {
 	defer wg.Done()
 
 	// set door contact position at startup
-	doors, err := uacClient.FetchAllDoors()
-	if err != nil {
-		logger.Error("Failed to fetch all doors", slog.String("err", err.Error()))
-	} else {
-		for _, d := range doors {
-
-			door, found := getDoorByUacID(d.ID)
-			if !found {
-				logger.Warn("Door not found for UAC ID", slog.String("door_id", d.ID))
-				return
-			}
-
-			switch d.DoorPositionStatus {
-			case "open":
-				if err := hubitatClient.AssertDoorContactOpened(door.HubitatContactID); err != nil {
-					logger.Error("Failed to assert door contact opened",
-						slog.String("door_id", d.ID), slog.String("err", err.Error()))
-				}
-			case "close":
-				if err := hubitatClient.AssertDoorContactClosed(door.HubitatContactID); err != nil {
-					logger.Error("Failed to assert door contact closed",
-						slog.String("door_id", d.ID), slog.String("err", err.Error()))
-				}
-			}
-		}
-	}
+	_ = syncDoorPositions(controller)
 
 	// poll door rule every 5 seconds and update hubitat lock when status changes.
 	// This is temporary until below data is included in the webhook
@@ -236,43 +413,134 @@ func pollUacStates(ctx context.Context, wg *sync.WaitGroup) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			for _, door := range appConfig.Doors {
-				if door.HubitatLockID == nil {
-					// no lock associated with this door
-					continue
-				}
-				rule, err := uacClient.GetDoorLockRule(door.UacID)
-				if err != nil {
-					logger.Error("Failed to get door lock rule", slog.String("door_id", door.UacID),
-						slog.String("err", err.Error()))
-					continue
-				}
+			_ = syncLockRules(controller, doorLockRuleStates)
+		}
+	}
+}
 
-				var currDoorLockRuleState string
-				if rule.Type == "keep_unlock" {
-					currDoorLockRuleState = "unlocked"
-				} else if rule.Type == "" {
-					currDoorLockRuleState = "locked"
-				} else {
-					logger.Warn("Unknown door lock rule type", slog.String("door_id", door.UacID),
-						slog.String("rule_type", rule.Type))
-					continue
-				}
+// reconcile brings the Hubitat contacts and locks of every door and the locks of the door groups
+// in line with UAC, asserting every lock instead of only those whose rule changed since the last poll.
+func reconcile() error {
+	var errs []error
+	for _, controller := range slices.Sorted(maps.Keys(uacClients)) {
+		errs = append(errs, syncDoorPositions(controller), syncLockRules(controller, make(map[string]string)))
+	}
+	errs = append(errs, syncGroupLocks(make(map[string]string)))
+	return errors.Join(errs...)
+}
 
-				prevState := doorLockRuleStates[door.UacID]
-				if currDoorLockRuleState != prevState {
-					if currDoorLockRuleState == "locked" {
-						if err := hubitatClient.AssertDoorLockLocked(*door.HubitatLockID); err != nil {
-							logger.Error("Failed to assert door lock locked", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
-						}
-					} else if currDoorLockRuleState == "unlocked" {
-						if err := hubitatClient.AssertDoorLockUnlocked(*door.HubitatLockID); err != nil {
-							logger.Error("Failed to assert door lock unlocked", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
-						}
-					}
-					doorLockRuleStates[door.UacID] = currDoorLockRuleState
-				}
-			}
+// syncLockRules mirrors the UAC lock rule of every door of a controller with a lock to Hubitat.
+// states holds the state last mirrored for each door, only doors whose state differs are asserted.
+func syncLockRules(controller string, states map[string]string) error {
+	var errs []error
+	fail := func(door *config.Door, msg string, err error) {
+		logger.Error(msg, slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+		pollErrors.Inc()
+		recordDoorError(door, err)
+		errs = append(errs, fmt.Errorf("%s: %w", door.Name(), err))
+	}
+
+	for _, door := range getAppConfig().Doors {
+		if door.Controller() != controller {
+			continue
+		}
+		lockID, hasLock := door.LockID()
+		if !hasLock || !door.Options.MirrorsLockRule() {
+			// no lock associated with this door, or its lock rule is not mirrored
+			continue
+		}
+		rule, err := uacFor(&door).GetDoorLockRule(door.UacID)
+		if err != nil {
+			fail(&door, "Failed to get door lock rule", err)
+			continue
+		}
+
+		currDoorLockRuleState, known := lockRuleState(rule.Type)
+		if !known {
+			logger.Warn("Unknown door lock rule type", slog.String("door_id", door.UacID),
+				slog.String("rule_type", rule.Type))
+			continue
+		}
+
+		setDoorGauge(doorLocked, &door, currDoorLockRuleState == "locked")
+
+		prevState := states[door.UacID]
+		if currDoorLockRuleState == prevState {
+			continue
+		}
+		backend, err := backendOf(&door)
+		if err != nil {
+			fail(&door, "No backend for door", err)
+			continue
+		}
+		if err := backend.AssertLock(lockID, currDoorLockRuleState == "locked"); err != nil {
+			fail(&door, "Failed to assert door lock "+currDoorLockRuleState, err)
+		}
+		if prevState != "" {
+			publishDoorEvent(&door, "uac", "lock_rule", currDoorLockRuleState, "")
+		}
+		states[door.UacID] = currDoorLockRuleState
+	}
+	return errors.Join(errs...)
+}
+
+// lockRuleState returns the lock state a UAC lock rule leaves a door in, which its lock mirrors:
+// keep_unlock and custom keep it unlocked, while without a rule, with keep_lock and with
+// lock_early it is locked. It returns false for rules it doesn't know.
+func lockRuleState(ruleType string) (string, bool) {
+	switch ruleType {
+	case "keep_unlock", "custom":
+		return "unlocked", true
+	case "", "keep_lock", "lock_early":
+		return "locked", true
+	default:
+		return "", false
+	}
+}
+
+// contactState returns the contact state mirroring a UAC door position, and false for unknown positions.
+func contactState(position string) (string, bool) {
+	switch position {
+	case "open":
+		return "open", true
+	case "close":
+		return "closed", true
+	default:
+		return "", false
+	}
+}
+
+// syncDoorPositions sets the contact sensors of the doors of a controller to their current UAC positions.
+func syncDoorPositions(controller string) error {
+	doors, err := uacClients[controller].FetchAllDoors()
+	if err != nil {
+		logger.Error("Failed to fetch all doors", slog.String("controller", controller), slog.String("err", err.Error()))
+		pollErrors.Inc()
+		return fmt.Errorf("failed to fetch UAC doors of %s: %w", uacUpstreamName(controller), err)
+	}
+
+	var errs []error
+	for _, d := range doors {
+		door, found := getDoorByUacID(d.ID)
+		if !found || door.Controller() != controller {
+			logger.Warn("Door not found for UAC ID", slog.String("door_id", d.ID))
+			continue
+		}
+
+		state, known := contactState(d.DoorPositionStatus)
+		if !known {
+			continue
+		}
+		open := state == "open"
+		trackDoorPosition(door, open)
+		setDoorGauge(doorOpen, door, open)
+		if err := assertContact(door, open); err != nil {
+			logger.Error("Failed to assert door contact "+state,
+				slog.String("door_id", d.ID), slog.String("err", err.Error()))
+			pollErrors.Inc()
+			recordDoorError(door, err)
+			errs = append(errs, fmt.Errorf("%s: %w", door.Name(), err))
 		}
 	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+// recordingHub records the states asserted on its locks.
+type recordingHub struct {
+	deviceBackend
+	locks map[string]bool
+}
+
+func (h *recordingHub) AssertLock(id string, locked bool) error {
+	h.locks[id] = locked
+	return nil
+}
+
+func TestSyncLockRules(t *testing.T) {
+	lock := func(id string) *string { return &id }
+	doors := []config.Door{
+		{UacID: "d1", HubitatLockID: lock("11")},
+		{UacID: "d2", HubitatLockID: lock("21")},
+		{UacID: "d3", HubitatLockID: lock("31")},
+		{UacID: "d4", HubitatLockID: lock("41")},
+		{UacID: "d5", HubitatLockID: lock("51")},
+	}
+	// the rules a schedule can set are all mirrored
+	setTestGroup(t, map[string]string{"d1": "", "d2": "keep_lock", "d3": "lock_early", "d4": "keep_unlock", "d5": "custom"},
+		config.DoorGroup{Name: "all"}, doors...)
+	hub := &recordingHub{locks: make(map[string]bool)}
+	backends = map[string]deviceBackend{config.HubitatInstance(config.DefaultHubitatHub): hub}
+
+	states := make(map[string]string)
+	if err := syncLockRules(config.DefaultUACController, states); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"11": true, "21": true, "31": true, "41": false, "51": false}
+	for id, locked := range want {
+		if got, ok := hub.locks[id]; !ok || got != locked {
+			t.Errorf("lock %s = %t (asserted %t), want locked %t", id, got, ok, locked)
+		}
+	}
+	if len(states) != len(doors) {
+		t.Errorf("mirrored states = %v, want one for every door", states)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/audit"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+// defaultEventsLimit is the number of events returned by the events API unless a limit is given.
+const defaultEventsLimit = 100
+
+// newAdminAPI creates the handler of the admin REST API.
+func newAdminAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/doors", handleListDoors)
+	mux.HandleFunc("GET /api/v1/doors/{door}", handleGetDoor)
+	mux.HandleFunc("POST /api/v1/doors/{door}/unlock", doorAction("unlock", func(door *config.Door, _ *http.Request) error {
+		return uacFor(door).AssertToggleDoorUnlock(door.UacID)
+	}))
+	mux.HandleFunc("POST /api/v1/doors/{door}/lock", doorAction("lock", func(door *config.Door, _ *http.Request) error {
+		return uacFor(door).AssertLockDoor(door.UacID)
+	}))
+	mux.HandleFunc("POST /api/v1/doors/{door}/keep-unlock", doorAction("keep_unlock", func(door *config.Door, _ *http.Request) error {
+		return uacFor(door).AssertUnlockDoor(door.UacID)
+	}))
+	mux.HandleFunc("POST /api/v1/doors/{door}/timed-unlock", doorAction("timed_unlock", timedUnlock))
+	mux.HandleFunc("POST /api/v1/reconcile", handleReconcile)
+	mux.HandleFunc("GET /api/v1/events", handleListEvents)
+	mux.HandleFunc("POST /api/v1/events/stream-ticket", handleStreamTicket)
+	mux.HandleFunc("GET /api/v1/journal", handleQueryJournal)
+	mux.HandleFunc("GET /api/v1/schedules", handleListTransitions)
+	return requireAdminToken(mux, bearerToken)
+}
+
+// bearerToken returns the token in the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// requireAdminToken only lets requests with an admin token through, tokenOf returns the token of a request.
+func requireAdminToken(next http.Handler, tokenOf func(r *http.Request) (string, bool)) http.Handler {
+	return requireToken(next, "admin", (*config.Server).AdminTokens, tokenOf)
+}
+
+// requireToken only lets requests with one of the tokens of a scope through. The routes of a
+// scope without tokens are disabled.
+func requireToken(next http.Handler, scope string, tokens func(*config.Server) []string, tokenOf func(r *http.Request) (string, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted := tokens(getAppConfig().Server)
+		if len(accepted) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s is disabled, set server.tokens.%s to enable it", r.URL.Path, scope))
+			return
+		}
+		token, ok := tokenOf(r)
+		if !ok || !utils.TokenMatches(token, accepted) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Sprintf("invalid or missing %s token", scope))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// findDoor returns the door in effect with the given UAC ID or, ignoring case, name.
+func findDoor(ref string) (*config.Door, bool) {
+	if door, found := getDoorByUacID(ref); found {
+		return door, true
+	}
+	return lookupDoor(getAppConfig().Doors, ref)
+}
+
+// lookupDoor returns the door of doors with the given UAC ID or, ignoring case, name.
+func lookupDoor(doors []config.Door, ref string) (*config.Door, bool) {
+	for i := range doors {
+		if doors[i].UacID == ref || strings.EqualFold(doors[i].Name(), ref) {
+			return &doors[i], true
+		}
+	}
+	return nil, false
+}
+
+func handleListDoors(w http.ResponseWriter, r *http.Request) {
+	handleStatus(w, r)
+}
+
+func handleGetDoor(w http.ResponseWriter, r *http.Request) {
+	door, found := findDoor(r.PathValue("door"))
+	if !found {
+		writeError(w, http.StatusNotFound, "door not found")
+		return
+	}
+	status, err := currentStatus()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	i := slices.IndexFunc(status.Doors, func(d doorStatus) bool { return d.UacID == door.UacID })
+	if i < 0 {
+		// added by a reload since the last collection
+		writeError(w, http.StatusServiceUnavailable, "the status of the door has not been collected yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, status.Doors[i])
+}
+
+// doorAction creates the handler of an action on a single door. Every action is audit logged
+// and published as an event.
+func doorAction(action string, fn func(door *config.Door, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		door, found := findDoor(r.PathValue("door"))
+		if !found {
+			writeError(w, http.StatusNotFound, "door not found")
+			return
+		}
+
+		ip := requestClientIP(r)
+		err := fn(door, r)
+		logger.Info("Admin action",
+			slog.String("audit", "admin_action"),
+			slog.String("action", action),
+			slog.String("door_id", door.UacID),
+			slog.String("remote_ip", ip),
+			slog.Bool("ok", err == nil))
+
+		var badRequest *badRequestError
+		if !errors.As(err, &badRequest) {
+			recordAudit(doorAudit(door, action, "api", ip, r.Method+" "+r.URL.Path), err)
+		}
+		switch {
+		case errors.As(err, &badRequest):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case err != nil:
+			recordDoorError(door, err)
+			writeError(w, http.StatusBadGateway, err.Error())
+		default:
+			publishDoorEvent(door, "api", action, "", ip)
+			requestStatusRefresh()
+			writeJSON(w, http.StatusOK, struct {
+				OK bool `json:"ok"`
+			}{true})
+		}
+	}
+}
+
+// badRequestError is an error in the request rather than in carrying it out.
+type badRequestError struct{ msg string }
+
+func (e *badRequestError) Error() string { return e.msg }
+
+// timedUnlock unlocks a door for the number of minutes in the request body.
+func timedUnlock(door *config.Door, r *http.Request) error {
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return &badRequestError{fmt.Sprintf("invalid request body: %s", err)}
+	}
+	if body.Minutes <= 0 {
+		return &badRequestError{"minutes must be at least 1"}
+	}
+	return uacFor(door).UnlockDoorFor(door.UacID, body.Minutes)
+}
+
+func handleReconcile(w http.ResponseWriter, r *http.Request) {
+	ip := requestClientIP(r)
+	logger.Info("Admin action",
+		slog.String("audit", "admin_action"),
+		slog.String("action", "reconcile"),
+		slog.String("remote_ip", ip))
+
+	err := reconcile()
+	requestStatusRefresh()
+	recordAudit(audit.Record{Action: "reconcile", Source: "api", Actor: ip, Trigger: r.Method + " " + r.URL.Path}, err)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, struct {
+			OK     bool     `json:"ok"`
+			Errors []string `json:"errors"`
+		}{false, strings.Split(err.Error(), "\n")})
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		OK bool `json:"ok"`
+	}{true})
+}
+
+// handleListEvents returns the recent events, optionally limited to a door with ?door= and in number with ?limit=.
+func handleListEvents(w http.ResponseWriter, r *http.Request) {
+	limit := defaultEventsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative number")
+			return
+		}
+		limit = n
+	}
+
+	recent := eventBus.Recent(0)
+	if ref := r.URL.Query().Get("door"); ref != "" {
+		door, found := findDoor(ref)
+		if !found {
+			writeError(w, http.StatusNotFound, "door not found")
+			return
+		}
+		filtered := recent[:0]
+		for _, e := range recent {
+			if e.DoorID == door.UacID {
+				filtered = append(filtered, e)
+			}
+		}
+		recent = filtered
+	}
+	if limit > 0 && limit < len(recent) {
+		recent = recent[len(recent)-limit:]
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Events []events.Event `json:"events"`
+	}{recent})
+}
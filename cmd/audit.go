@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/audit"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+const (
+	// auditFileName is the name of the audit log in the state directory.
+	auditFileName = "audit.log"
+	// auditHeadKey is the state key of the head of the audit log, which reveals records
+	// removed from its end.
+	auditHeadKey = "audit_head"
+	// auditTamperKey is the state key of the mismatches found between the audit log and its
+	// recorded head, which verify keeps reporting after the head moved on.
+	auditTamperKey = "audit_tamper"
+)
+
+// auditTamper is a mismatch between the end of the audit log and its recorded head.
+type auditTamper struct {
+	Detected time.Time  `json:"detected"`
+	Head     audit.Head `json:"head"`
+	// Recorded is the head recorded before, nil when none was.
+	Recorded *audit.Head `json:"recorded"`
+}
+
+func (t auditTamper) String() string {
+	if t.Recorded == nil {
+		return fmt.Sprintf("on %s the audit log ended at record %d but no head was recorded",
+			t.Detected.Format(time.RFC3339), t.Head.Seq)
+	}
+	return fmt.Sprintf("on %s the audit log ended at record %d (%s) instead of the recorded head %d (%s)",
+		t.Detected.Format(time.RFC3339), t.Head.Seq, t.Head.Hash, t.Recorded.Seq, t.Recorded.Hash)
+}
+
+// auditLog records the door control actions.
+var auditLog *audit.Log
+
+// openAuditLog opens the audit log and checks that it ends with the head recorded in the state
+// store. A mismatch is kept in the state store and as a tamper_detected record in the log, so
+// verify still reports it once new records have moved the head on.
+func openAuditLog(cfg *config.Config, store *state.Store) (*audit.Log, error) {
+	l, err := audit.Open(filepath.Join(cfg.StateDir, auditFileName))
+	if err != nil {
+		return nil, err
+	}
+	head := l.Head()
+	var recorded audit.Head
+	found, err := store.Get(auditHeadKey, &recorded)
+	if err != nil {
+		return nil, err
+	}
+	if checkAuditHead(head, recorded, found) != nil {
+		tamper := auditTamper{Detected: time.Now().UTC(), Head: head}
+		if found {
+			tamper.Recorded = &recorded
+		}
+		logger.Error("Audit log does not end with the recorded head, it was modified or truncated",
+			slog.Uint64("seq", head.Seq), slog.Uint64("recorded_seq", recorded.Seq), slog.Bool("recorded", found))
+		if err := recordAuditTamper(l, store, tamper); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	logger.Info("Opened audit log", slog.Uint64("seq", l.Head().Seq), slog.String("hash", l.Head().Hash))
+	return l, nil
+}
+
+// recordAuditTamper keeps a mismatch of the audit log in the state store and in the log itself.
+func recordAuditTamper(l *audit.Log, store *state.Store, tamper auditTamper) error {
+	var tampers []auditTamper
+	if _, err := store.Get(auditTamperKey, &tampers); err != nil {
+		return err
+	}
+	if err := store.Set(auditTamperKey, append(tampers, tamper)); err != nil {
+		return fmt.Errorf("failed to record audit log mismatch: %w", err)
+	}
+	head, err := l.Append(audit.Record{Action: audit.ActionTamperDetected, Source: "app", Trigger: tamper.String(), Result: "error"})
+	if err != nil {
+		return fmt.Errorf("failed to record audit log mismatch: %w", err)
+	}
+	return store.Set(auditHeadKey, head)
+}
+
+// recordAudit appends a door control action and its result to the audit log.
+func recordAudit(r audit.Record, err error) {
+	r.Result = "ok"
+	if err != nil {
+		r.Result, r.Error = "error", err.Error()
+	}
+	if auditLog == nil {
+		return
+	}
+	head, appendErr := auditLog.Append(r)
+	if appendErr != nil {
+		logger.Error("Failed to write audit log", slog.String("action", r.Action), slog.String("err", appendErr.Error()))
+		return
+	}
+	if err := stateStore.Set(auditHeadKey, head); err != nil {
+		logger.Error("Failed to save audit log head", slog.String("err", err.Error()))
+	}
+}
+
+// doorAudit returns an audit record of an action on a door.
+func doorAudit(door *config.Door, action, source, actor, trigger string) audit.Record {
+	return audit.Record{
+		Action:  action,
+		DoorID:  door.UacID,
+		Door:    door.Name(),
+		Source:  source,
+		Actor:   actor,
+		Trigger: trigger,
+	}
+}
+
+// checkAuditHead compares the end of the audit log with the head recorded in the state store,
+// found tells whether one was.
+func checkAuditHead(head, recorded audit.Head, found bool) error {
+	switch {
+	case !found && head.Seq > 0:
+		return errors.New("no head is recorded for the audit log")
+	case found && recorded.Seq > head.Seq:
+		return fmt.Errorf("the audit log was truncated: it ends at record %d, record %d was written", head.Seq, recorded.Seq)
+	case found && recorded != head:
+		return fmt.Errorf("the audit log ends at record %d, which is not the recorded head %d", head.Seq, recorded.Seq)
+	}
+	return nil
+}
+
+// runVerify verifies the hash chain of the audit log and compares its end with the recorded head.
+func runVerify(configPath string, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", configPath, "path of the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	head, err := audit.Verify(filepath.Join(cfg.StateDir, auditFileName))
+	if err != nil {
+		return err
+	}
+
+	store, err := state.Open(filepath.Join(cfg.StateDir, stateFileName))
+	if err != nil {
+		return err
+	}
+	var recorded audit.Head
+	found, err := store.Get(auditHeadKey, &recorded)
+	if err != nil {
+		return err
+	}
+	if err := checkAuditHead(head, recorded, found); err != nil {
+		return err
+	}
+
+	// mismatches found earlier stay reported after the head moved on
+	var problems []string
+	var tampers []auditTamper
+	if _, err := store.Get(auditTamperKey, &tampers); err != nil {
+		return err
+	}
+	for _, t := range tampers {
+		problems = append(problems, t.String())
+	}
+	records, err := audit.TamperRecords(filepath.Join(cfg.StateDir, auditFileName))
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		// a record without its entry in the state store, which was removed
+		if !slices.Contains(problems, r.Trigger) {
+			problems = append(problems, fmt.Sprintf("record %d: %s", r.Seq, r.Trigger))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the audit log chain is intact up to record %d, but it was found modified or truncated before:\n%s",
+			head.Seq, strings.Join(problems, "\n"))
+	}
+	fmt.Printf("audit log is intact: %d records, head %s\n", head.Seq, head.Hash)
+	return nil
+}
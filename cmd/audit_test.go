@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/audit"
+)
+
+func TestCheckAuditHead(t *testing.T) {
+	head := audit.Head{Seq: 3, Hash: "c"}
+	tests := []struct {
+		name     string
+		head     audit.Head
+		recorded audit.Head
+		found    bool
+		err      string
+	}{
+		{"matching", head, head, true, ""},
+		{"empty log without a head", audit.Head{}, audit.Head{}, false, ""},
+		{"no head recorded", head, audit.Head{}, false, "no head is recorded"},
+		{"records removed from the end", head, audit.Head{Seq: 5, Hash: "e"}, true, "truncated: it ends at record 3, record 5 was written"},
+		{"emptied log", audit.Head{}, head, true, "truncated: it ends at record 0, record 3 was written"},
+		{"last record replaced", head, audit.Head{Seq: 3, Hash: "x"}, true, "not the recorded head 3"},
+		{"records added", head, audit.Head{Seq: 2, Hash: "b"}, true, "not the recorded head 2"},
+	}
+	for _, tt := range tests {
+		err := checkAuditHead(tt.head, tt.recorded, tt.found)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: checkAuditHead = %v, want nil", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: checkAuditHead = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
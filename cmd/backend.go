@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/homeassistant"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+)
+
+// deviceBackend drives the devices mirroring the doors in a home automation system. The kind
+// of a device is "contact", "lock" or "switch", device states are "open" or "closed",
+// "locked" or "unlocked" and "on" or "off".
+type deviceBackend interface {
+	AssertContact(id string, open bool) error
+	AssertLock(id string, locked bool) error
+	AssertSwitch(id string, on bool) error
+	// DeviceState returns the display name and current state of a device.
+	DeviceState(kind, id string) (name, state string, err error)
+	// VerifyDevice checks that a device exists and can be driven as the given kind.
+	VerifyDevice(kind, id string) error
+	// Ping checks that the backend answers, doors are the doors it mirrors.
+	Ping(doors []config.Door) error
+}
+
+// backends holds the configured backends by instance, see config.Door.BackendInstance.
+var backends = map[string]deviceBackend{}
+
+// backendOf returns the backend of a door.
+func backendOf(door *config.Door) (deviceBackend, error) {
+	b, ok := backends[door.BackendInstance()]
+	if !ok {
+		return nil, fmt.Errorf("backend %s is not configured", door.BackendInstance())
+	}
+	return b, nil
+}
+
+// newBackends returns the backends of the configured clients, one per Hubitat hub.
+func newBackends(clients *upstreamClients) map[string]deviceBackend {
+	b := make(map[string]deviceBackend)
+	for name, client := range clients.hubitat {
+		b[config.HubitatInstance(name)] = hubitatBackend{client}
+	}
+	if clients.homeAssistant != nil {
+		b[config.BackendHomeAssistant] = homeAssistantBackend{clients.homeAssistant}
+	}
+	return b
+}
+
+// hubitatBackend drives Hubitat devices through the Maker API.
+type hubitatBackend struct {
+	client *hubitat.Client
+}
+
+func (b hubitatBackend) AssertContact(id string, open bool) error {
+	if open {
+		return b.client.AssertDoorContactOpened(id)
+	}
+	return b.client.AssertDoorContactClosed(id)
+}
+
+func (b hubitatBackend) AssertLock(id string, locked bool) error {
+	if locked {
+		return b.client.AssertDoorLockLocked(id)
+	}
+	return b.client.AssertDoorLockUnlocked(id)
+}
+
+func (b hubitatBackend) AssertSwitch(id string, on bool) error {
+	if on {
+		return b.client.AssertDoorSwitchOn(id)
+	}
+	return b.client.AssertDoorSwitchOff(id)
+}
+
+func (b hubitatBackend) DeviceState(kind, id string) (string, string, error) {
+	info, err := b.client.GetDeviceInfo(id)
+	if err != nil {
+		return "", "", err
+	}
+	// the attribute is named after the device type, e.g. "contact" for a contact sensor
+	return info.Label, info.AttributeValue(kind), nil
+}
+
+func (b hubitatBackend) VerifyDevice(kind, id string) error {
+	switch kind {
+	case "contact":
+		return b.client.VerifyContactDevice(id)
+	case "lock":
+		return b.client.VerifyLockDevice(id)
+	default:
+		return b.client.VerifySwitchDevice(id)
+	}
+}
+
+// Ping queries the contact sensor of the first door, or the device list when there are no doors.
+func (b hubitatBackend) Ping(doors []config.Door) error {
+	if len(doors) == 0 {
+		_, err := b.client.ListDevices()
+		return err
+	}
+	_, err := b.client.GetDeviceInfo(doors[0].ContactID())
+	return err
+}
+
+// homeAssistantBackend drives Home Assistant entities through the REST API.
+type homeAssistantBackend struct {
+	client *homeassistant.Client
+}
+
+func (b homeAssistantBackend) AssertContact(id string, open bool) error {
+	return b.client.AssertContact(id, open)
+}
+
+func (b homeAssistantBackend) AssertLock(id string, locked bool) error {
+	return b.client.AssertLock(id, locked)
+}
+
+func (b homeAssistantBackend) AssertSwitch(id string, on bool) error {
+	return b.client.AssertSwitch(id, on)
+}
+
+func (b homeAssistantBackend) DeviceState(kind, id string) (string, string, error) {
+	return b.client.EntityState(kind, id)
+}
+
+func (b homeAssistantBackend) VerifyDevice(kind, id string) error {
+	return b.client.VerifyEntity(kind, id)
+}
+
+func (b homeAssistantBackend) Ping([]config.Door) error {
+	return b.client.Ping()
+}
+
+// listenHomeAssistant handles the state changes of the Home Assistant entities mapped to doors
+// until the context is cancelled.
+func listenHomeAssistant(ctx context.Context, wg *sync.WaitGroup, client *homeassistant.Client) {
+	defer wg.Done()
+	client.Listen(ctx, func(change homeassistant.StateChange) {
+		door, kind, found := getDoorByDevice(config.BackendHomeAssistant, change.EntityID)
+		if !found {
+			return
+		}
+		logger.Info("Received Home Assistant Event", slog.Any("event", change))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleDeviceEvent(door, config.BackendHomeAssistant, kind, change.EntityID,
+				homeassistant.NormalizeState(kind, change.EntityID, change.NewState))
+		}()
+	})
+}
@@ -1,40 +1,952 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/homekit"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/notify"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/outbound"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/schedule"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server  *Server  `yaml:"server"`
-	UAC     *UAC     `yaml:"uac"`
-	Hubitat *Hubitat `yaml:"hubitat"`
-	Doors   []Door   `yaml:"doors"`
+	Server *Server `yaml:"server"`
+	// UAC is the default UniFi Access controller, used by the doors without a uac_controller.
+	UAC *UAC `yaml:"uac,omitempty"`
+	// UACControllers are further, named UniFi Access controllers, e.g. the consoles of other sites.
+	UACControllers []UAC `yaml:"uac_controllers,omitempty"`
+	// Hubitat is the default Hubitat hub, used by the Hubitat doors without a hubitat_hub.
+	Hubitat *Hubitat `yaml:"hubitat,omitempty"`
+	// HubitatHubs are further, named Hubitat hubs, e.g. a hub in the garage.
+	HubitatHubs []Hubitat `yaml:"hubitat_hubs,omitempty"`
+	// HomeAssistant is used by the doors with the home_assistant backend.
+	HomeAssistant *HomeAssistant `yaml:"home_assistant,omitempty"`
+	// MQTT publishes the door states to an MQTT broker and accepts commands from it.
+	MQTT *MQTT `yaml:"mqtt,omitempty"`
+	// HomeKit exposes the doors to Apple Home as a HomeKit bridge.
+	HomeKit *HomeKit `yaml:"homekit,omitempty"`
+	// OutboundWebhooks deliver the door events to HTTP endpoints.
+	OutboundWebhooks []OutboundWebhook `yaml:"outbound_webhooks,omitempty"`
+	// Notifications alert people about door problems by email, push or Hubitat notification devices.
+	Notifications *Notifications `yaml:"notifications,omitempty"`
+	// Schedules set the lock rules of doors at given times of the week.
+	Schedules *Schedules `yaml:"schedules,omitempty"`
+	Doors     []Door     `yaml:"doors"`
+	// DoorGroups drive several doors with one Hubitat lock or switch.
+	DoorGroups []DoorGroup `yaml:"door_groups,omitempty"`
+	// StateDir is the directory where state that must survive restarts is kept.
+	StateDir string `yaml:"state_dir,omitempty"`
+	// Journal keeps the door events in <state_dir>/journal.
+	Journal Journal `yaml:"journal,omitempty"`
+}
+
+// DefaultStateDir is the state directory used when none is configured.
+const DefaultStateDir = "data"
+
+// Journal configures the event journal and how long events are kept.
+type Journal struct {
+	Disabled bool `yaml:"disabled,omitempty"`
+	// MaxAge is how long events are kept.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+	// MaxSizeMB is how large the journal may grow before the oldest days are removed.
+	MaxSizeMB int `yaml:"max_size_mb,omitempty"`
+}
+
+const (
+	DefaultJournalMaxAge    = 90 * 24 * time.Hour
+	DefaultJournalMaxSizeMB = 100
+)
+
+// ApplyDefaults fills in the journal settings that are not set.
+func (j *Journal) ApplyDefaults() {
+	if j.MaxAge == 0 {
+		j.MaxAge = DefaultJournalMaxAge
+	}
+	if j.MaxSizeMB == 0 {
+		j.MaxSizeMB = DefaultJournalMaxSizeMB
+	}
 }
 
 type Server struct {
-	BaseURL   string `yaml:"base_url"`
-	AuthToken string `yaml:"auth_token"`
+	BaseURL string `yaml:"base_url"`
+	// AuthToken is the legacy token shared by the UAC and Hubitat webhooks. It is only
+	// used for the sources that have no tokens of their own.
+	AuthToken     string `yaml:"auth_token,omitempty"`
+	AuthTokenFile string `yaml:"auth_token_file,omitempty"`
+	Tokens        Tokens `yaml:"tokens,omitempty"`
+	// Listen is the address the HTTP server listens on.
+	Listen string     `yaml:"listen,omitempty"`
+	TLS    *ServerTLS `yaml:"tls,omitempty"`
+	// Timeouts of the HTTP server, see net/http.Server.
+	ReadTimeout       time.Duration `yaml:"read_timeout,omitempty"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout,omitempty"`
+	WriteTimeout      time.Duration `yaml:"write_timeout,omitempty"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout,omitempty"`
+	// ShutdownTimeout bounds how long shutdown waits for connections and queued events to finish.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout,omitempty"`
+	// ReadyMaxLatency is how long UAC and Hubitat may take to answer the readiness checks.
+	ReadyMaxLatency time.Duration `yaml:"ready_max_latency,omitempty"`
+	// TrustedProxies are the proxies whose X-Forwarded-For header is used to find the client IP.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	// Routes restricts who may call each route.
+	Routes Routes `yaml:"routes,omitempty"`
+	// AuthFailures blocks clients that fail authentication too often.
+	AuthFailures AuthFailureLimit `yaml:"auth_failures,omitempty"`
+	// StartDegraded starts the server with the doors that failed validation
+	// disabled instead of refusing to start.
+	StartDegraded bool `yaml:"start_degraded,omitempty"`
+}
+
+// Tokens holds the tokens accepted from each source. Several tokens can be active at
+// once so they can be rotated without downtime.
+type Tokens struct {
+	// UAC tokens are sent by UniFi Access in the webhook Authorization header. The first
+	// one is registered with UniFi Access.
+	UAC     []string `yaml:"uac,omitempty"`
+	UACFile string   `yaml:"uac_file,omitempty"`
+	// Hubitat tokens are sent by the Maker API in the authorization query parameter.
+	Hubitat     []string `yaml:"hubitat,omitempty"`
+	HubitatFile string   `yaml:"hubitat_file,omitempty"`
+	// Admin tokens authorize the admin API. There is no fallback to auth_token.
+	Admin     []string `yaml:"admin,omitempty"`
+	AdminFile string   `yaml:"admin_file,omitempty"`
+	// Monitoring tokens authorize /metrics and /status, which admin tokens also do.
+	Monitoring     []string `yaml:"monitoring,omitempty"`
+	MonitoringFile string   `yaml:"monitoring_file,omitempty"`
+}
+
+// UACTokens returns the tokens accepted on the UAC webhook.
+func (s *Server) UACTokens() []string {
+	return tokensOrDefault(s.Tokens.UAC, s.AuthToken)
+}
+
+// HubitatTokens returns the tokens accepted on the Hubitat webhook.
+func (s *Server) HubitatTokens() []string {
+	return tokensOrDefault(s.Tokens.Hubitat, s.AuthToken)
+}
+
+// AdminTokens returns the tokens accepted on the admin API.
+func (s *Server) AdminTokens() []string {
+	return s.Tokens.Admin
+}
+
+// MonitoringTokens returns the tokens accepted on /metrics and /status.
+func (s *Server) MonitoringTokens() []string {
+	return append(slices.Clip(s.Tokens.Monitoring), s.Tokens.Admin...)
+}
+
+func tokensOrDefault(tokens []string, fallback string) []string {
+	if len(tokens) > 0 {
+		return tokens
+	}
+	if fallback != "" {
+		return []string{fallback}
+	}
+	return nil
+}
+
+// Routes holds the access restrictions of each route.
+type Routes struct {
+	UAC     RouteAccess `yaml:"uac,omitempty"`
+	Hubitat RouteAccess `yaml:"hubitat,omitempty"`
+	Metrics RouteAccess `yaml:"metrics,omitempty"`
+	// Health covers /healthz and /readyz.
+	Health RouteAccess `yaml:"health,omitempty"`
+	Status RouteAccess `yaml:"status,omitempty"`
+	// Admin covers the admin API below /api/.
+	Admin RouteAccess `yaml:"admin,omitempty"`
+	// Dashboard covers the web dashboard below /dashboard/.
+	Dashboard RouteAccess `yaml:"dashboard,omitempty"`
+	// Events covers the event stream on /events/stream.
+	Events RouteAccess `yaml:"events,omitempty"`
+}
+
+// RouteAccess restricts the clients allowed to call a route.
+type RouteAccess struct {
+	// AllowCIDRs lists the networks (or single IPs) allowed to call the route. Empty allows everyone.
+	AllowCIDRs []string `yaml:"allow_cidrs,omitempty"`
+	// RequireClientCert requires a client certificate signed by server.tls.client_ca_file.
+	RequireClientCert bool `yaml:"require_client_cert,omitempty"`
+}
+
+// AuthFailureLimit blocks a client IP for Block after MaxFailures failed attempts within Window.
+type AuthFailureLimit struct {
+	MaxFailures int           `yaml:"max_failures,omitempty"`
+	Window      time.Duration `yaml:"window,omitempty"`
+	Block       time.Duration `yaml:"block,omitempty"`
+}
+
+// ServerTLS enables HTTPS on the server, either with the given certificate or a self-signed one.
+type ServerTLS struct {
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// SelfSigned generates a self-signed certificate. When cert_file and key_file are set
+	// and don't exist yet, the generated certificate is saved there and reused on restart.
+	SelfSigned bool `yaml:"self_signed,omitempty"`
+	// ClientCAFile verifies the client certificates presented to the server against these CAs.
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+}
+
+const (
+	DefaultListen            = "0.0.0.0:9423"
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultShutdownTimeout   = 30 * time.Second
+	DefaultReadyMaxLatency   = 2 * time.Second
+	DefaultAuthMaxFailures   = 10
+	DefaultAuthWindow        = time.Minute
+	DefaultAuthBlock         = 5 * time.Minute
+	// MinTokenLength is the minimum length of the scoped tokens.
+	MinTokenLength = 16
+)
+
+// ApplyDefaults fills in the server settings that are not set.
+func (s *Server) ApplyDefaults() {
+	if s.Listen == "" {
+		s.Listen = DefaultListen
+	}
+	if s.ReadTimeout == 0 {
+		s.ReadTimeout = DefaultReadTimeout
+	}
+	if s.ReadHeaderTimeout == 0 {
+		s.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if s.WriteTimeout == 0 {
+		s.WriteTimeout = DefaultWriteTimeout
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = DefaultIdleTimeout
+	}
+	if s.ShutdownTimeout == 0 {
+		s.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if s.ReadyMaxLatency == 0 {
+		s.ReadyMaxLatency = DefaultReadyMaxLatency
+	}
+	if s.AuthFailures.MaxFailures == 0 {
+		s.AuthFailures.MaxFailures = DefaultAuthMaxFailures
+	}
+	if s.AuthFailures.Window == 0 {
+		s.AuthFailures.Window = DefaultAuthWindow
+	}
+	if s.AuthFailures.Block == 0 {
+		s.AuthFailures.Block = DefaultAuthBlock
+	}
 }
 
 type UAC struct {
-	BaseURL string `yaml:"base_url"`
-	APIKey  string `yaml:"api_key"`
+	// Name identifies a controller of uac_controllers. It is part of its webhook route.
+	Name       string     `yaml:"name,omitempty"`
+	BaseURL    string     `yaml:"base_url"`
+	APIKey     string     `yaml:"api_key"`
+	APIKeyFile string     `yaml:"api_key_file,omitempty"`
+	TLS        *ClientTLS `yaml:"tls,omitempty"`
+}
+
+// DefaultUACController is the name of the controller of the uac section.
+const DefaultUACController = "default"
+
+// controllerNamePattern matches the names of controllers and hubs, which are part of webhook routes.
+var controllerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Controllers returns the configured UAC controllers by name, the one of the uac section as "default".
+func (c *Config) Controllers() map[string]*UAC {
+	controllers := make(map[string]*UAC, len(c.UACControllers)+1)
+	if c.UAC != nil {
+		controllers[DefaultUACController] = c.UAC
+	}
+	for i := range c.UACControllers {
+		controllers[c.UACControllers[i].Name] = &c.UACControllers[i]
+	}
+	return controllers
+}
+
+// validate checks the settings of a controller, prefix is the path of the controller in the config.
+func (u *UAC) validate(prefix string) []error {
+	var errs []error
+	if err := validateURL(u.BaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("%s.base_url: %w", prefix, err))
+	}
+	if u.TLS != nil {
+		errs = append(errs, u.TLS.validate(prefix+".tls")...)
+	}
+	if u.APIKey == "" {
+		errs = append(errs, fmt.Errorf("%s.api_key: is required", prefix))
+	}
+	return errs
 }
 
 type Hubitat struct {
-	BaseURL     string `yaml:"base_url"`
-	AccessToken string `yaml:"access_token"`
+	// Name identifies a hub of hubitat_hubs. It is part of its webhook route.
+	Name            string     `yaml:"name,omitempty"`
+	BaseURL         string     `yaml:"base_url"`
+	AccessToken     string     `yaml:"access_token"`
+	AccessTokenFile string     `yaml:"access_token_file,omitempty"`
+	TLS             *ClientTLS `yaml:"tls,omitempty"`
+}
+
+// DefaultHubitatHub is the name of the hub of the hubitat section.
+const DefaultHubitatHub = "default"
+
+// Hubs returns the configured Hubitat hubs by name, the one of the hubitat section as "default".
+func (c *Config) Hubs() map[string]*Hubitat {
+	hubs := make(map[string]*Hubitat, len(c.HubitatHubs)+1)
+	if c.Hubitat != nil {
+		hubs[DefaultHubitatHub] = c.Hubitat
+	}
+	for i := range c.HubitatHubs {
+		hubs[c.HubitatHubs[i].Name] = &c.HubitatHubs[i]
+	}
+	return hubs
+}
+
+// HubitatInstance returns the backend instance of a hub: "hubitat" for the default hub and
+// hubitat_<name> for the others. It names the hub in readiness checks and logs.
+func HubitatInstance(hub string) string {
+	if hub == DefaultHubitatHub {
+		return BackendHubitat
+	}
+	return BackendHubitat + "_" + hub
 }
 
+// validate checks the settings of a hub, prefix is the path of the hub in the config.
+func (h *Hubitat) validate(prefix string) []error {
+	var errs []error
+	if err := validateURL(h.BaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("%s.base_url: %w", prefix, err))
+	}
+	if h.TLS != nil {
+		errs = append(errs, h.TLS.validate(prefix+".tls")...)
+	}
+	if h.AccessToken == "" {
+		errs = append(errs, fmt.Errorf("%s.access_token: is required", prefix))
+	}
+	return errs
+}
+
+// HomeAssistant connects to Home Assistant with a long-lived access token.
+type HomeAssistant struct {
+	BaseURL   string     `yaml:"base_url"`
+	Token     string     `yaml:"token"`
+	TokenFile string     `yaml:"token_file,omitempty"`
+	TLS       *ClientTLS `yaml:"tls,omitempty"`
+}
+
+// MQTT connects to an MQTT broker.
+type MQTT struct {
+	// Broker is the URL of the broker, e.g. tcp://host:1883 or ssl://host:8883.
+	Broker       string `yaml:"broker"`
+	Username     string `yaml:"username,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+	ClientID     string `yaml:"client_id,omitempty"`
+	// TopicPrefix is the first level of every topic published or subscribed to.
+	TopicPrefix string `yaml:"topic_prefix,omitempty"`
+	// DiscoveryPrefix is the topic prefix Home Assistant discovers MQTT entities under.
+	DiscoveryPrefix  string `yaml:"discovery_prefix,omitempty"`
+	DisableDiscovery bool   `yaml:"disable_discovery,omitempty"`
+	// DisableCommands leaves out the command topics, so doors can't be controlled over MQTT.
+	DisableCommands bool `yaml:"disable_commands,omitempty"`
+	// RefreshInterval is how often the door states are queried from UAC and republished when changed.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	TLS             *ClientTLS    `yaml:"tls,omitempty"`
+}
+
+const (
+	DefaultMQTTClientID        = "unifi-access-hubitat-middleware"
+	DefaultMQTTTopicPrefix     = "uahm"
+	DefaultMQTTDiscoveryPrefix = "homeassistant"
+	DefaultMQTTRefreshInterval = 30 * time.Second
+)
+
+// ApplyDefaults fills in the MQTT settings that are not set.
+func (m *MQTT) ApplyDefaults() {
+	if m.ClientID == "" {
+		m.ClientID = DefaultMQTTClientID
+	}
+	if m.TopicPrefix == "" {
+		m.TopicPrefix = DefaultMQTTTopicPrefix
+	}
+	if m.DiscoveryPrefix == "" {
+		m.DiscoveryPrefix = DefaultMQTTDiscoveryPrefix
+	}
+	if m.RefreshInterval == 0 {
+		m.RefreshInterval = DefaultMQTTRefreshInterval
+	}
+}
+
+// HomeKit runs a HomeKit bridge with a lock and a contact sensor for each door.
+type HomeKit struct {
+	// Name is the name of the bridge shown in the Home app.
+	Name string `yaml:"name,omitempty"`
+	// SetupCode is the code entered when pairing, formatted as XXX-XX-XXX. A random one is
+	// generated and kept in the state directory when it is empty.
+	SetupCode string `yaml:"setup_code,omitempty"`
+	// Listen is the address the bridge accepts connections from controllers on.
+	Listen string `yaml:"listen,omitempty"`
+	// AdvertiseIPs are the IPv4 addresses announced over mDNS, by default those of every interface.
+	AdvertiseIPs []string `yaml:"advertise_ips,omitempty"`
+	// RefreshInterval is how often the lock states are queried from UAC.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+const (
+	DefaultHomeKitName            = "UniFi Access"
+	DefaultHomeKitListen          = ":51826"
+	DefaultHomeKitRefreshInterval = 30 * time.Second
+)
+
+// ApplyDefaults fills in the HomeKit settings that are not set.
+func (h *HomeKit) ApplyDefaults() {
+	if h.Name == "" {
+		h.Name = DefaultHomeKitName
+	}
+	if h.Listen == "" {
+		h.Listen = DefaultHomeKitListen
+	}
+	if h.RefreshInterval == 0 {
+		h.RefreshInterval = DefaultHomeKitRefreshInterval
+	}
+}
+
+// OutboundWebhook delivers the door events passing its filter to an HTTP endpoint.
+type OutboundWebhook struct {
+	// Name identifies the webhook in logs and metrics.
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Body is a text/template rendered with the event, the event as JSON when empty.
+	Body string `yaml:"body,omitempty"`
+	// Secret signs every delivery with HMAC-SHA256 when set.
+	Secret     string         `yaml:"secret,omitempty"`
+	SecretFile string         `yaml:"secret_file,omitempty"`
+	Filter     OutboundFilter `yaml:"filter,omitempty"`
+	// Timeout bounds each attempt, and Attempts is how many are made before a delivery is
+	// given up. Backoff is the delay before the first retry, doubled for every further one.
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	Attempts int           `yaml:"attempts,omitempty"`
+	Backoff  time.Duration `yaml:"backoff,omitempty"`
+	TLS      *ClientTLS    `yaml:"tls,omitempty"`
+}
+
+// OutboundFilter selects the events delivered by an outbound webhook. Empty lists match every event.
+type OutboundFilter struct {
+	// Doors are UAC door IDs or names.
+	Doors   []string `yaml:"doors,omitempty"`
+	Types   []string `yaml:"types,omitempty"`
+	Actors  []string `yaml:"actors,omitempty"`
+	Sources []string `yaml:"sources,omitempty"`
+	Results []string `yaml:"results,omitempty"`
+}
+
+const (
+	DefaultOutboundMethod   = "POST"
+	DefaultOutboundTimeout  = 10 * time.Second
+	DefaultOutboundAttempts = 3
+	DefaultOutboundBackoff  = time.Second
+	// MaxOutboundAttempts bounds how long a delivery is retried.
+	MaxOutboundAttempts = 10
+)
+
+// ApplyDefaults fills in the outbound webhook settings that are not set.
+func (o *OutboundWebhook) ApplyDefaults() {
+	if o.Method == "" {
+		o.Method = DefaultOutboundMethod
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultOutboundTimeout
+	}
+	if o.Attempts == 0 {
+		o.Attempts = DefaultOutboundAttempts
+	}
+	if o.Backoff == 0 {
+		o.Backoff = DefaultOutboundBackoff
+	}
+}
+
+// Notification triggers.
+const (
+	TriggerAccessDenied = "access_denied"
+	TriggerHeldOpen     = "held_open"
+	TriggerForcedOpen   = "forced_open"
+	TriggerEmergency    = "emergency"
+	TriggerUpstreamDown = "upstream_down"
+)
+
+// NotificationTriggers are the events notifications can be sent for.
+var NotificationTriggers = []string{TriggerAccessDenied, TriggerHeldOpen, TriggerForcedOpen, TriggerEmergency, TriggerUpstreamDown}
+
+// Notifications sends alerts through one or more channels.
+type Notifications struct {
+	Channels []NotificationChannel `yaml:"channels"`
+	// UpstreamCheckInterval is how often UAC and the backends are checked for the upstream_down trigger.
+	UpstreamCheckInterval time.Duration `yaml:"upstream_check_interval,omitempty"`
+}
+
+// NotificationChannel sends alerts through exactly one of SMTP, Push or Hubitat.
+type NotificationChannel struct {
+	// Name identifies the channel in logs and metrics.
+	Name    string               `yaml:"name"`
+	SMTP    *SMTPChannel         `yaml:"smtp,omitempty"`
+	Push    *PushChannel         `yaml:"push,omitempty"`
+	Hubitat *HubitatNotification `yaml:"hubitat,omitempty"`
+	// Triggers are the events the channel is sent, all of them when empty.
+	Triggers []string `yaml:"triggers,omitempty"`
+	// Title and Body are text/templates rendered with the event, the trigger and the default
+	// .Title and .Message. The defaults are used when they are empty.
+	Title string `yaml:"title,omitempty"`
+	Body  string `yaml:"body,omitempty"`
+	// QuietHours hold back the notifications of the channel every day between start and end.
+	QuietHours *QuietHours `yaml:"quiet_hours,omitempty"`
+}
+
+// Wants reports whether the channel is sent notifications for a trigger.
+func (n NotificationChannel) Wants(trigger string) bool {
+	return len(n.Triggers) == 0 || slices.Contains(n.Triggers, trigger)
+}
+
+// SMTPChannel sends notifications as emails.
+type SMTPChannel struct {
+	Host         string   `yaml:"host"`
+	Port         int      `yaml:"port,omitempty"`
+	Username     string   `yaml:"username,omitempty"`
+	Password     string   `yaml:"password,omitempty"`
+	PasswordFile string   `yaml:"password_file,omitempty"`
+	From         string   `yaml:"from"`
+	To           []string `yaml:"to"`
+	// Security is starttls, tls or none. Defaults to tls on port 465 and starttls otherwise.
+	Security string     `yaml:"security,omitempty"`
+	TLS      *ClientTLS `yaml:"tls,omitempty"`
+}
+
+// PushChannel posts notifications to an ntfy-style HTTP endpoint.
+type PushChannel struct {
+	// URL is the topic, e.g. https://ntfy.sh/my-doors.
+	URL       string     `yaml:"url"`
+	Token     string     `yaml:"token,omitempty"`
+	TokenFile string     `yaml:"token_file,omitempty"`
+	Priority  string     `yaml:"priority,omitempty"`
+	Tags      []string   `yaml:"tags,omitempty"`
+	TLS       *ClientTLS `yaml:"tls,omitempty"`
+}
+
+// HubitatNotification sends notifications to a Hubitat notification device, such as the mobile app.
+type HubitatNotification struct {
+	DeviceID string `yaml:"device_id"`
+	// Hub is the name of the hub of the device in hubitat_hubs, the hubitat section when empty.
+	Hub string `yaml:"hub,omitempty"`
+}
+
+// HubName returns the name of the hub of the notification device.
+func (h *HubitatNotification) HubName() string {
+	if h.Hub == "" {
+		return DefaultHubitatHub
+	}
+	return h.Hub
+}
+
+// QuietHours is a daily period, HH:MM to HH:MM in local time, that may cross midnight.
+type QuietHours struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Except are the triggers still sent during quiet hours.
+	Except []string `yaml:"except,omitempty"`
+}
+
+const (
+	DefaultUpstreamCheckInterval = time.Minute
+	DefaultSMTPPort              = 587
+	DefaultSMTPTLSPort           = 465
+)
+
+// ApplyDefaults fills in the notification settings that are not set.
+func (n *Notifications) ApplyDefaults() {
+	if n.UpstreamCheckInterval == 0 {
+		n.UpstreamCheckInterval = DefaultUpstreamCheckInterval
+	}
+	for _, ch := range n.Channels {
+		if s := ch.SMTP; s != nil {
+			if s.Port == 0 {
+				s.Port = DefaultSMTPPort
+				if s.Security == notify.SecurityTLS {
+					s.Port = DefaultSMTPTLSPort
+				}
+			}
+			if s.Security == "" {
+				s.Security = notify.SecurityStartTLS
+				if s.Port == DefaultSMTPTLSPort {
+					s.Security = notify.SecurityTLS
+				}
+			}
+		}
+	}
+}
+
+// ClientTLS controls how the certificate of an upstream HTTPS server is verified. Without
+// any option the certificate must be valid for the host and signed by a system CA.
+type ClientTLS struct {
+	// CAFile verifies the certificate against these CAs instead of the system ones.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// PinSHA256 accepts only certificates whose SHA-256 fingerprint, or the SHA-256 of
+	// whose public key, is listed. The CA chain is not checked unless ca_file is set.
+	PinSHA256 []string `yaml:"pin_sha256,omitempty"`
+	// TrustOnFirstUse pins the public key seen on the first connection and stores it in the state directory.
+	TrustOnFirstUse bool `yaml:"trust_on_first_use,omitempty"`
+	// InsecureSkipVerify accepts any certificate.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// validate checks the TLS options, prefix is the path of the options in the config.
+func (t *ClientTLS) validate(prefix string) []error {
+	var errs []error
+	if t.InsecureSkipVerify && (t.CAFile != "" || len(t.PinSHA256) > 0 || t.TrustOnFirstUse) {
+		errs = append(errs, fmt.Errorf("%s.insecure_skip_verify: must not be combined with other options", prefix))
+	}
+	if t.TrustOnFirstUse && len(t.PinSHA256) > 0 {
+		errs = append(errs, fmt.Errorf("%s.trust_on_first_use: must not be combined with pin_sha256", prefix))
+	}
+	for i, pin := range t.PinSHA256 {
+		if _, err := ParseFingerprint(pin); err != nil {
+			errs = append(errs, fmt.Errorf("%s.pin_sha256[%d]: %w", prefix, i, err))
+		}
+	}
+	return errs
+}
+
+// ParseFingerprint decodes a SHA-256 fingerprint written in hex, optionally separated by colons.
+func ParseFingerprint(value string) ([]byte, error) {
+	fp, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", value)
+	}
+	return fp, nil
+}
+
+// The backends mirroring the doors.
+const (
+	BackendHubitat       = "hubitat"
+	BackendHomeAssistant = "home_assistant"
+)
+
+// Door maps a UAC door to its Hubitat devices or Home Assistant entities. The UAC door and
+// each Hubitat device can be referenced either by ID or by name, names are resolved to IDs
+// at startup.
 type Door struct {
-	UacID            string  `yaml:"uac_id"`
-	HubitatContactID string  `yaml:"hubitat_contact_id"`
-	HubitatLockID    *string `yaml:"hubitat_lock_id,omitempty"`
-	HubitatSwitchID  string  `yaml:"hubitat_switch_id"`
+	UacID   string `yaml:"uac_id,omitempty"`
+	UacName string `yaml:"uac_name,omitempty"`
+	// UACController is the name of the controller of the door in uac_controllers, the uac section when empty.
+	UACController string `yaml:"uac_controller,omitempty"`
+	// Backend is "hubitat" (the default) or "home_assistant".
+	Backend string `yaml:"backend,omitempty"`
+	// HubitatHub is the name of the hub of the Hubitat devices in hubitat_hubs, the hubitat section when empty.
+	HubitatHub          string      `yaml:"hubitat_hub,omitempty"`
+	HubitatContactID    string      `yaml:"hubitat_contact_id,omitempty"`
+	HubitatContactLabel string      `yaml:"hubitat_contact_label,omitempty"`
+	HubitatLockID       *string     `yaml:"hubitat_lock_id,omitempty"`
+	HubitatLockLabel    string      `yaml:"hubitat_lock_label,omitempty"`
+	HubitatSwitchID     string      `yaml:"hubitat_switch_id,omitempty"`
+	HubitatSwitchLabel  string      `yaml:"hubitat_switch_label,omitempty"`
+	HAContactEntity     string      `yaml:"ha_contact_entity,omitempty"`
+	HALockEntity        string      `yaml:"ha_lock_entity,omitempty"`
+	HASwitchEntity      string      `yaml:"ha_switch_entity,omitempty"`
+	Options             DoorOptions `yaml:"options,omitempty"`
+}
+
+// BackendName returns the backend mirroring the door.
+func (d *Door) BackendName() string {
+	if d.Backend == "" {
+		return BackendHubitat
+	}
+	return d.Backend
+}
+
+// Controller returns the name of the UAC controller of the door.
+func (d *Door) Controller() string {
+	if d.UACController == "" {
+		return DefaultUACController
+	}
+	return d.UACController
+}
+
+// Hub returns the name of the Hubitat hub of the door.
+func (d *Door) Hub() string {
+	if d.HubitatHub == "" {
+		return DefaultHubitatHub
+	}
+	return d.HubitatHub
+}
+
+// BackendInstance returns the backend instance mirroring the door: "home_assistant", or the
+// HubitatInstance of its hub. Device IDs are only unique within an instance.
+func (d *Door) BackendInstance() string {
+	if d.BackendName() == BackendHubitat {
+		return HubitatInstance(d.Hub())
+	}
+	return d.BackendName()
+}
+
+// ContactID returns the ID of the contact sensor in the backend of the door.
+func (d *Door) ContactID() string {
+	if d.BackendName() == BackendHomeAssistant {
+		return d.HAContactEntity
+	}
+	return d.HubitatContactID
+}
+
+// LockID returns the ID of the lock in the backend of the door, and false when the door has none.
+func (d *Door) LockID() (string, bool) {
+	if d.BackendName() == BackendHomeAssistant {
+		return d.HALockEntity, d.HALockEntity != ""
+	}
+	if d.HubitatLockID == nil {
+		return "", false
+	}
+	return *d.HubitatLockID, true
+}
+
+// SwitchID returns the ID of the switch in the backend of the door.
+func (d *Door) SwitchID() string {
+	if d.BackendName() == BackendHomeAssistant {
+		return d.HASwitchEntity
+	}
+	return d.HubitatSwitchID
+}
+
+// Name returns the name used to refer to the door in logs and errors.
+func (d *Door) Name() string {
+	if d.UacName != "" {
+		return d.UacName
+	}
+	return d.UacID
+}
+
+// DefaultUnlockSettleDelay gives the door lock time to actually unlock before the Hubitat switch is turned on.
+const DefaultUnlockSettleDelay = 200 * time.Millisecond
+
+// DoorOptions tunes how a single door is handled.
+type DoorOptions struct {
+	// UnlockSettleDelay is how long to wait after a UAC unlock before turning on the Hubitat switch.
+	UnlockSettleDelay *time.Duration `yaml:"unlock_settle_delay,omitempty"`
+	// SwitchPulse turns the Hubitat switch off again after this long. Zero leaves it to the device auto off.
+	SwitchPulse time.Duration `yaml:"switch_pulse,omitempty"`
+	// MirrorLockRule mirrors the UAC lock rule to the Hubitat lock. Defaults to true.
+	MirrorLockRule *bool `yaml:"mirror_lock_rule,omitempty"`
+	// HeldOpenThreshold reports the door as held open when it stays open longer than this. Zero disables it.
+	HeldOpenThreshold time.Duration `yaml:"held_open_threshold,omitempty"`
+	// ForcedOpenWindow reports the door as forced open when it opens while locked and without an
+	// unlock in this long before. Zero disables it.
+	ForcedOpenWindow time.Duration `yaml:"forced_open_window,omitempty"`
+}
+
+// SettleDelay returns the unlock settle delay, falling back to the default.
+func (o DoorOptions) SettleDelay() time.Duration {
+	if o.UnlockSettleDelay == nil {
+		return DefaultUnlockSettleDelay
+	}
+	return *o.UnlockSettleDelay
+}
+
+// MirrorsLockRule reports whether the UAC lock rule is mirrored to the Hubitat lock.
+func (o DoorOptions) MirrorsLockRule() bool {
+	return o.MirrorLockRule == nil || *o.MirrorLockRule
+}
+
+// DoorGroup drives several doors with one Hubitat lock or switch: locking or unlocking the lock
+// applies to every member, and turning the switch on unlocks them all. The members are given by
+// reference, imported from a UAC door group, or both.
+type DoorGroup struct {
+	Name string `yaml:"name"`
+	// Doors are the members, by the uac_id or uac_name of configured doors.
+	Doors []string `yaml:"doors,omitempty"`
+	// UACGroup adds the configured doors of the UAC door group with this name or ID.
+	UACGroup string `yaml:"uac_group,omitempty"`
+	// UACController is the name of the controller of the uac_group, the uac section when empty.
+	UACController string `yaml:"uac_controller,omitempty"`
+	// HubitatHub is the name of the hub of the devices in hubitat_hubs, the hubitat section when empty.
+	HubitatHub         string `yaml:"hubitat_hub,omitempty"`
+	HubitatLockID      string `yaml:"hubitat_lock_id,omitempty"`
+	HubitatLockLabel   string `yaml:"hubitat_lock_label,omitempty"`
+	HubitatSwitchID    string `yaml:"hubitat_switch_id,omitempty"`
+	HubitatSwitchLabel string `yaml:"hubitat_switch_label,omitempty"`
+
+	// Members are the UAC IDs of the member doors, resolved at startup.
+	Members []string `yaml:"-"`
+}
+
+// Controller returns the name of the UAC controller of the uac_group.
+func (g *DoorGroup) Controller() string {
+	if g.UACController == "" {
+		return DefaultUACController
+	}
+	return g.UACController
+}
+
+// Hub returns the name of the Hubitat hub of the group devices.
+func (g *DoorGroup) Hub() string {
+	if g.HubitatHub == "" {
+		return DefaultHubitatHub
+	}
+	return g.HubitatHub
 }
 
+// Schedules set the lock rules of doors at given times of the week.
+type Schedules struct {
+	// Timezone is the IANA time zone of the times, e.g. America/New_York. Defaults to the local time zone.
+	Timezone string `yaml:"timezone,omitempty"`
+	// Holidays are the dates, YYYY-MM-DD or MM-DD for every year, on which schedules don't run by default.
+	Holidays []string   `yaml:"holidays,omitempty"`
+	Entries  []Schedule `yaml:"entries"`
+}
+
+// Schedule sets the lock rule of doors at given times on given weekdays.
+type Schedule struct {
+	Name string `yaml:"name"`
+	// Doors are doors by uac_id or uac_name, and DoorGroups door groups whose members are included.
+	Doors      []string `yaml:"doors,omitempty"`
+	DoorGroups []string `yaml:"door_groups,omitempty"`
+	// Weekdays are the days the schedule runs on, e.g. [mon, tue]. Every day when empty.
+	Weekdays []string `yaml:"weekdays,omitempty"`
+	// Timezone overrides the time zone of the schedules.
+	Timezone string `yaml:"timezone,omitempty"`
+	// OnHolidays is skip (the default), run or only.
+	OnHolidays  string               `yaml:"on_holidays,omitempty"`
+	Transitions []ScheduleTransition `yaml:"transitions"`
+}
+
+// ScheduleTransition sets a lock rule at a time of day.
+type ScheduleTransition struct {
+	// At is the time of day, HH:MM.
+	At   string `yaml:"at"`
+	Rule string `yaml:"rule"`
+	// Minutes is how long a custom rule keeps the doors unlocked.
+	Minutes int `yaml:"minutes,omitempty"`
+}
+
+// ScheduleRules are the UAC lock rules a schedule can set.
+var ScheduleRules = []string{"keep_unlock", "keep_lock", "custom", "reset", "lock_early"}
+
+// ApplyDefaults fills in the defaults of unset schedule settings.
+func (s *Schedules) ApplyDefaults() {
+	for i := range s.Entries {
+		if s.Entries[i].OnHolidays == "" {
+			s.Entries[i].OnHolidays = schedule.HolidaysSkip
+		}
+	}
+}
+
+// Location returns the time zone of a schedule.
+func (s *Schedules) Location(entry *Schedule) (*time.Location, error) {
+	name := entry.Timezone
+	if name == "" {
+		name = s.Timezone
+	}
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Days returns the days a schedule runs on.
+func (s *Schedules) Days(entry *Schedule) (schedule.Days, error) {
+	days := schedule.Days{OnHolidays: entry.OnHolidays}
+	for _, v := range entry.Weekdays {
+		d, err := schedule.ParseWeekday(v)
+		if err != nil {
+			return days, err
+		}
+		days.Weekdays[d] = true
+	}
+	var err error
+	days.Holidays, err = schedule.ParseHolidays(s.Holidays)
+	return days, err
+}
+
+// validate checks the schedules, groups are the names of the door groups.
+func (s *Schedules) validate(groups map[string]bool) []error {
+	var errs []error
+	if _, err := schedule.ParseHolidays(s.Holidays); err != nil {
+		errs = append(errs, fmt.Errorf("schedules.holidays: %w", err))
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("schedules.timezone: %w", err))
+		}
+	}
+	names := make(map[string]bool)
+	for i := range s.Entries {
+		e := &s.Entries[i]
+		field := fmt.Sprintf("schedules.entries[%d]", i)
+		switch {
+		case e.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case names[e.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another schedule", field, e.Name))
+		}
+		names[e.Name] = true
+		if len(e.Doors) == 0 && len(e.DoorGroups) == 0 {
+			errs = append(errs, fmt.Errorf("%s: doors or door_groups is required", field))
+		}
+		for _, g := range e.DoorGroups {
+			if !groups[g] {
+				errs = append(errs, fmt.Errorf("%s.door_groups: %q is not a configured door group", field, g))
+			}
+		}
+		for _, d := range e.Weekdays {
+			if _, err := schedule.ParseWeekday(d); err != nil {
+				errs = append(errs, fmt.Errorf("%s.weekdays: %w", field, err))
+			}
+		}
+		if e.Timezone != "" {
+			if _, err := time.LoadLocation(e.Timezone); err != nil {
+				errs = append(errs, fmt.Errorf("%s.timezone: %w", field, err))
+			}
+		}
+		switch e.OnHolidays {
+		case schedule.HolidaysSkip, schedule.HolidaysRun, schedule.HolidaysOnly:
+		default:
+			errs = append(errs, fmt.Errorf("%s.on_holidays: must be %s, %s or %s", field,
+				schedule.HolidaysSkip, schedule.HolidaysRun, schedule.HolidaysOnly))
+		}
+		if len(e.Transitions) == 0 {
+			errs = append(errs, fmt.Errorf("%s.transitions: at least one is required", field))
+		}
+		for j, t := range e.Transitions {
+			tfield := fmt.Sprintf("%s.transitions[%d]", field, j)
+			if _, err := schedule.ParseClock(t.At); err != nil {
+				errs = append(errs, fmt.Errorf("%s.at: %w", tfield, err))
+			}
+			if !slices.Contains(ScheduleRules, t.Rule) {
+				errs = append(errs, fmt.Errorf("%s.rule: must be one of %s", tfield, strings.Join(ScheduleRules, ", ")))
+			}
+			switch {
+			case t.Rule == "custom" && t.Minutes <= 0:
+				errs = append(errs, fmt.Errorf("%s.minutes: must be at least 1 for a custom rule", tfield))
+			case t.Rule != "custom" && t.Minutes != 0:
+				errs = append(errs, fmt.Errorf("%s.minutes: is only used by custom rules", tfield))
+			}
+		}
+	}
+	return errs
+}
+
+// LoadConfig reads the config file and applies the ${VAR} references in its values,
+// the UAHM_* environment variable overrides and the secret files, in that order.
 func LoadConfig(configPath string) (*Config, error) {
 	file, err := os.Open(configPath)
 	if err != nil {
@@ -42,10 +954,622 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 	defer file.Close()
 
-	decoder := yaml.NewDecoder(file)
 	var cfg Config
-	if err := decoder.Decode(&cfg); err != nil {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(file).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
+	if doc.Kind != 0 {
+		if err := interpolate(&doc); err != nil {
+			return nil, err
+		}
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnvironment(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Server != nil {
+		cfg.Server.ApplyDefaults()
+	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = DefaultStateDir
+	}
+	cfg.Journal.ApplyDefaults()
+	if cfg.MQTT != nil {
+		cfg.MQTT.ApplyDefaults()
+	}
+	if cfg.HomeKit != nil {
+		cfg.HomeKit.ApplyDefaults()
+	}
+	for i := range cfg.OutboundWebhooks {
+		cfg.OutboundWebhooks[i].ApplyDefaults()
+	}
+	if cfg.Notifications != nil {
+		cfg.Notifications.ApplyDefaults()
+	}
+	if cfg.Schedules != nil {
+		cfg.Schedules.ApplyDefaults()
+	}
 	return &cfg, nil
 }
+
+// SaveConfig writes the config as YAML to configPath, replacing any existing file.
+func SaveConfig(configPath string, cfg *Config) error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, buf.Bytes(), 0600)
+}
+
+// Validate checks that all required fields are set. Every problem found is
+// reported in the returned error rather than stopping at the first one.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server == nil {
+		errs = append(errs, errors.New("server: section is missing"))
+	} else {
+		if err := validateURL(c.Server.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("server.base_url: %w", err))
+		}
+		if len(c.Server.UACTokens()) == 0 {
+			errs = append(errs, errors.New("server.tokens.uac: is required when server.auth_token is not set"))
+		}
+		if len(c.Hubs()) > 0 && len(c.Server.HubitatTokens()) == 0 {
+			errs = append(errs, errors.New("server.tokens.hubitat: is required when server.auth_token is not set"))
+		}
+		for _, scope := range []struct {
+			name   string
+			tokens []string
+		}{
+			{"uac", c.Server.Tokens.UAC},
+			{"hubitat", c.Server.Tokens.Hubitat},
+			{"admin", c.Server.Tokens.Admin},
+			{"monitoring", c.Server.Tokens.Monitoring},
+		} {
+			for i, token := range scope.tokens {
+				if len(token) < MinTokenLength {
+					errs = append(errs, fmt.Errorf("server.tokens.%s[%d]: must be at least %d characters", scope.name, i, MinTokenLength))
+				}
+			}
+		}
+		if _, _, err := net.SplitHostPort(c.Server.Listen); c.Server.Listen != "" && err != nil {
+			errs = append(errs, fmt.Errorf("server.listen: %w", err))
+		}
+		if t := c.Server.TLS; t != nil {
+			if (t.CertFile == "") != (t.KeyFile == "") {
+				errs = append(errs, errors.New("server.tls: cert_file and key_file must be set together"))
+			}
+			if t.CertFile == "" && !t.SelfSigned {
+				errs = append(errs, errors.New("server.tls: cert_file and key_file, or self_signed, are required"))
+			}
+		}
+		for _, timeout := range []struct {
+			field string
+			value time.Duration
+		}{
+			{"read_timeout", c.Server.ReadTimeout},
+			{"read_header_timeout", c.Server.ReadHeaderTimeout},
+			{"write_timeout", c.Server.WriteTimeout},
+			{"idle_timeout", c.Server.IdleTimeout},
+			{"shutdown_timeout", c.Server.ShutdownTimeout},
+			{"ready_max_latency", c.Server.ReadyMaxLatency},
+		} {
+			if timeout.value < 0 {
+				errs = append(errs, fmt.Errorf("server.%s: must not be negative", timeout.field))
+			}
+		}
+		if c.Server.AuthFailures.MaxFailures < 0 || c.Server.AuthFailures.Window < 0 || c.Server.AuthFailures.Block < 0 {
+			errs = append(errs, errors.New("server.auth_failures: values must not be negative"))
+		}
+		for i, cidr := range c.Server.TrustedProxies {
+			if _, err := ParseCIDR(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("server.trusted_proxies[%d]: %w", i, err))
+			}
+		}
+		for _, route := range []struct {
+			name   string
+			access RouteAccess
+		}{
+			{"uac", c.Server.Routes.UAC},
+			{"hubitat", c.Server.Routes.Hubitat},
+			{"metrics", c.Server.Routes.Metrics},
+			{"health", c.Server.Routes.Health},
+			{"status", c.Server.Routes.Status},
+			{"admin", c.Server.Routes.Admin},
+			{"dashboard", c.Server.Routes.Dashboard},
+			{"events", c.Server.Routes.Events},
+		} {
+			for i, cidr := range route.access.AllowCIDRs {
+				if _, err := ParseCIDR(cidr); err != nil {
+					errs = append(errs, fmt.Errorf("server.routes.%s.allow_cidrs[%d]: %w", route.name, i, err))
+				}
+			}
+			if route.access.RequireClientCert && (c.Server.TLS == nil || c.Server.TLS.ClientCAFile == "") {
+				errs = append(errs, fmt.Errorf("server.routes.%s.require_client_cert: requires server.tls.client_ca_file", route.name))
+			}
+		}
+	}
+
+	if c.UAC == nil && len(c.UACControllers) == 0 {
+		errs = append(errs, errors.New("uac: section is missing"))
+	}
+	if c.UAC != nil {
+		if c.UAC.Name != "" {
+			errs = append(errs, errors.New("uac.name: is only used in uac_controllers"))
+		}
+		errs = append(errs, c.UAC.validate("uac")...)
+	}
+	controllerNames := make(map[string]bool)
+	for i := range c.UACControllers {
+		u := &c.UACControllers[i]
+		field := fmt.Sprintf("uac_controllers[%d]", i)
+		switch {
+		case u.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case !controllerNamePattern.MatchString(u.Name):
+			errs = append(errs, fmt.Errorf("%s.name: must be lower case letters, digits, - and _", field))
+		case u.Name == DefaultUACController:
+			errs = append(errs, fmt.Errorf("%s.name: %q is the name of the uac section", field, u.Name))
+		case controllerNames[u.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another controller", field, u.Name))
+		}
+		controllerNames[u.Name] = true
+		errs = append(errs, u.validate(field)...)
+	}
+
+	usesBackend := map[string]bool{}
+	for _, d := range c.Doors {
+		usesBackend[d.BackendName()] = true
+	}
+	if c.Hubitat == nil {
+		if c.HomeAssistant == nil && len(c.HubitatHubs) == 0 {
+			errs = append(errs, errors.New("hubitat: section is missing"))
+		}
+	} else {
+		if c.Hubitat.Name != "" {
+			errs = append(errs, errors.New("hubitat.name: is only used in hubitat_hubs"))
+		}
+		errs = append(errs, c.Hubitat.validate("hubitat")...)
+	}
+	hubNames := make(map[string]bool)
+	for i := range c.HubitatHubs {
+		h := &c.HubitatHubs[i]
+		field := fmt.Sprintf("hubitat_hubs[%d]", i)
+		switch {
+		case h.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case !controllerNamePattern.MatchString(h.Name):
+			errs = append(errs, fmt.Errorf("%s.name: must be lower case letters, digits, - and _", field))
+		case h.Name == DefaultHubitatHub:
+			errs = append(errs, fmt.Errorf("%s.name: %q is the name of the hubitat section", field, h.Name))
+		case hubNames[h.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another hub", field, h.Name))
+		}
+		hubNames[h.Name] = true
+		errs = append(errs, h.validate(field)...)
+	}
+
+	if c.HomeAssistant == nil {
+		if usesBackend[BackendHomeAssistant] {
+			errs = append(errs, errors.New("home_assistant: section is missing"))
+		}
+	} else {
+		if err := validateURL(c.HomeAssistant.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("home_assistant.base_url: %w", err))
+		}
+		if c.HomeAssistant.TLS != nil {
+			errs = append(errs, c.HomeAssistant.TLS.validate("home_assistant.tls")...)
+		}
+		if c.HomeAssistant.Token == "" {
+			errs = append(errs, errors.New("home_assistant.token: is required"))
+		}
+	}
+
+	if m := c.MQTT; m != nil {
+		if err := validateBrokerURL(m.Broker); err != nil {
+			errs = append(errs, fmt.Errorf("mqtt.broker: %w", err))
+		}
+		if m.TLS != nil {
+			errs = append(errs, m.TLS.validate("mqtt.tls")...)
+		}
+		if m.Password != "" && m.Username == "" {
+			errs = append(errs, errors.New("mqtt.username: is required with a password"))
+		}
+		for _, topic := range []struct{ name, value string }{{"topic_prefix", m.TopicPrefix}, {"discovery_prefix", m.DiscoveryPrefix}} {
+			if strings.ContainsAny(topic.value, "+#") || strings.HasPrefix(topic.value, "/") || strings.HasSuffix(topic.value, "/") {
+				errs = append(errs, fmt.Errorf("mqtt.%s: must not contain wildcards or start or end with /", topic.name))
+			}
+		}
+		if m.RefreshInterval < time.Second {
+			errs = append(errs, errors.New("mqtt.refresh_interval: must be at least 1s"))
+		}
+	}
+
+	if h := c.HomeKit; h != nil {
+		if h.SetupCode != "" && !homekit.ValidSetupCode(h.SetupCode) {
+			errs = append(errs, errors.New("homekit.setup_code: must be formatted as XXX-XX-XXX and not be trivial like 123-45-678"))
+		}
+		if _, _, err := net.SplitHostPort(h.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("homekit.listen: %w", err))
+		}
+		for i, ip := range h.AdvertiseIPs {
+			if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+				errs = append(errs, fmt.Errorf("homekit.advertise_ips[%d]: %q is not an IPv4 address", i, ip))
+			}
+		}
+		if h.RefreshInterval < time.Second {
+			errs = append(errs, errors.New("homekit.refresh_interval: must be at least 1s"))
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, o := range c.OutboundWebhooks {
+		field := fmt.Sprintf("outbound_webhooks[%d]", i)
+		switch {
+		case o.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case names[o.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another webhook", field, o.Name))
+		}
+		names[o.Name] = true
+		if err := validateURL(o.URL); err != nil {
+			errs = append(errs, fmt.Errorf("%s.url: %w", field, err))
+		}
+		switch o.Method {
+		case "POST", "PUT", "PATCH", "GET":
+		default:
+			errs = append(errs, fmt.Errorf("%s.method: must be POST, PUT, PATCH or GET", field))
+		}
+		if o.Body != "" {
+			if _, err := outbound.ParseBody(o.Name, o.Body); err != nil {
+				errs = append(errs, fmt.Errorf("%s.body: %w", field, err))
+			}
+		}
+		if o.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%s.timeout: must be positive", field))
+		}
+		if o.Attempts < 1 || o.Attempts > MaxOutboundAttempts {
+			errs = append(errs, fmt.Errorf("%s.attempts: must be between 1 and %d", field, MaxOutboundAttempts))
+		}
+		if o.Backoff < 0 {
+			errs = append(errs, fmt.Errorf("%s.backoff: must not be negative", field))
+		}
+		if o.TLS != nil {
+			errs = append(errs, o.TLS.validate(field+".tls")...)
+		}
+	}
+
+	if n := c.Notifications; n != nil {
+		errs = append(errs, n.validate(c.Hubs())...)
+	}
+
+	if len(c.Doors) == 0 {
+		errs = append(errs, errors.New("doors: at least one door is required"))
+	}
+	for i, d := range c.Doors {
+		if d.UacID == "" && d.UacName == "" {
+			errs = append(errs, fmt.Errorf("doors[%d]: uac_id or uac_name is required", i))
+		}
+		if err := c.checkController(fmt.Sprintf("doors[%d].uac_controller", i), d.UACController, controllerNames); err != nil {
+			errs = append(errs, err)
+		}
+		switch d.BackendName() {
+		case BackendHubitat:
+			if err := c.checkHub(fmt.Sprintf("doors[%d].hubitat_hub", i), d.HubitatHub, hubNames); err != nil {
+				errs = append(errs, err)
+			}
+			if d.HubitatContactID == "" && d.HubitatContactLabel == "" {
+				errs = append(errs, fmt.Errorf("doors[%d]: hubitat_contact_id or hubitat_contact_label is required", i))
+			}
+			if d.HubitatLockID != nil && *d.HubitatLockID == "" {
+				errs = append(errs, fmt.Errorf("doors[%d].hubitat_lock_id: must not be empty when set", i))
+			}
+			if d.HubitatSwitchID == "" && d.HubitatSwitchLabel == "" {
+				errs = append(errs, fmt.Errorf("doors[%d]: hubitat_switch_id or hubitat_switch_label is required", i))
+			}
+		case BackendHomeAssistant:
+			if d.HubitatHub != "" {
+				errs = append(errs, fmt.Errorf("doors[%d].hubitat_hub: is only used by hubitat doors", i))
+			}
+			if d.HAContactEntity == "" {
+				errs = append(errs, fmt.Errorf("doors[%d]: ha_contact_entity is required", i))
+			}
+			if d.HASwitchEntity == "" {
+				errs = append(errs, fmt.Errorf("doors[%d]: ha_switch_entity is required", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("doors[%d].backend: must be %s or %s", i, BackendHubitat, BackendHomeAssistant))
+		}
+		if d.Options.UnlockSettleDelay != nil && *d.Options.UnlockSettleDelay < 0 {
+			errs = append(errs, fmt.Errorf("doors[%d].options.unlock_settle_delay: must not be negative", i))
+		}
+		if d.Options.SwitchPulse < 0 {
+			errs = append(errs, fmt.Errorf("doors[%d].options.switch_pulse: must not be negative", i))
+		}
+		if d.Options.HeldOpenThreshold < 0 {
+			errs = append(errs, fmt.Errorf("doors[%d].options.held_open_threshold: must not be negative", i))
+		}
+		if d.Options.ForcedOpenWindow < 0 {
+			errs = append(errs, fmt.Errorf("doors[%d].options.forced_open_window: must not be negative", i))
+		}
+	}
+
+	groupNames := make(map[string]bool)
+	for i := range c.DoorGroups {
+		g := &c.DoorGroups[i]
+		field := fmt.Sprintf("door_groups[%d]", i)
+		switch {
+		case g.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case groupNames[g.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another group", field, g.Name))
+		}
+		groupNames[g.Name] = true
+		if len(g.Doors) == 0 && g.UACGroup == "" {
+			errs = append(errs, fmt.Errorf("%s: doors or uac_group is required", field))
+		}
+		if g.UACGroup != "" {
+			if err := c.checkController(field+".uac_controller", g.UACController, controllerNames); err != nil {
+				errs = append(errs, err)
+			}
+		} else if g.UACController != "" {
+			errs = append(errs, fmt.Errorf("%s.uac_controller: is only used with uac_group", field))
+		}
+		if err := c.checkHub(field+".hubitat_hub", g.HubitatHub, hubNames); err != nil {
+			errs = append(errs, err)
+		}
+		if g.HubitatLockID == "" && g.HubitatLockLabel == "" && g.HubitatSwitchID == "" && g.HubitatSwitchLabel == "" {
+			errs = append(errs, fmt.Errorf("%s: a hubitat lock or switch is required", field))
+		}
+	}
+
+	if s := c.Schedules; s != nil {
+		errs = append(errs, s.validate(groupNames)...)
+	}
+
+	if c.Journal.MaxAge < 0 {
+		errs = append(errs, errors.New("journal.max_age: must not be negative"))
+	}
+	if c.Journal.MaxSizeMB < 0 {
+		errs = append(errs, errors.New("journal.max_size_mb: must not be negative"))
+	}
+
+	if err := c.CheckDuplicates(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks the notification settings, hubs are the configured Hubitat hubs.
+func (n *Notifications) validate(hubs map[string]*Hubitat) []error {
+	var errs []error
+	checkTriggers := func(field string, triggers []string) {
+		for i, t := range triggers {
+			if !slices.Contains(NotificationTriggers, t) {
+				errs = append(errs, fmt.Errorf("%s[%d]: must be one of %s", field, i, strings.Join(NotificationTriggers, ", ")))
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for i, ch := range n.Channels {
+		field := fmt.Sprintf("notifications.channels[%d]", i)
+		switch {
+		case ch.Name == "":
+			errs = append(errs, fmt.Errorf("%s.name: is required", field))
+		case names[ch.Name]:
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another channel", field, ch.Name))
+		}
+		names[ch.Name] = true
+
+		kinds := 0
+		if s := ch.SMTP; s != nil {
+			kinds++
+			if s.Host == "" {
+				errs = append(errs, fmt.Errorf("%s.smtp.host: is required", field))
+			}
+			if s.Port < 1 || s.Port > 65535 {
+				errs = append(errs, fmt.Errorf("%s.smtp.port: must be between 1 and 65535", field))
+			}
+			if s.From == "" {
+				errs = append(errs, fmt.Errorf("%s.smtp.from: is required", field))
+			}
+			if len(s.To) == 0 {
+				errs = append(errs, fmt.Errorf("%s.smtp.to: at least one recipient is required", field))
+			}
+			switch s.Security {
+			case notify.SecurityStartTLS, notify.SecurityTLS, notify.SecurityNone:
+			default:
+				errs = append(errs, fmt.Errorf("%s.smtp.security: must be %s, %s or %s", field,
+					notify.SecurityStartTLS, notify.SecurityTLS, notify.SecurityNone))
+			}
+			if s.TLS != nil {
+				errs = append(errs, s.TLS.validate(field+".smtp.tls")...)
+			}
+		}
+		if p := ch.Push; p != nil {
+			kinds++
+			if err := validateURL(p.URL); err != nil {
+				errs = append(errs, fmt.Errorf("%s.push.url: %w", field, err))
+			}
+			if p.TLS != nil {
+				errs = append(errs, p.TLS.validate(field+".push.tls")...)
+			}
+		}
+		if h := ch.Hubitat; h != nil {
+			kinds++
+			if h.DeviceID == "" {
+				errs = append(errs, fmt.Errorf("%s.hubitat.device_id: is required", field))
+			}
+			if _, ok := hubs[h.HubName()]; !ok {
+				if h.Hub == "" {
+					errs = append(errs, fmt.Errorf("%s.hubitat: the hubitat section is required", field))
+				} else {
+					errs = append(errs, fmt.Errorf("%s.hubitat.hub: %q is not configured in hubitat_hubs", field, h.Hub))
+				}
+			}
+		}
+		if kinds != 1 {
+			errs = append(errs, fmt.Errorf("%s: exactly one of smtp, push or hubitat is required", field))
+		}
+
+		checkTriggers(field+".triggers", ch.Triggers)
+		if ch.Title != "" {
+			if _, err := notify.ParseTemplate(ch.Name, ch.Title); err != nil {
+				errs = append(errs, fmt.Errorf("%s.title: %w", field, err))
+			}
+		}
+		if ch.Body != "" {
+			if _, err := notify.ParseTemplate(ch.Name, ch.Body); err != nil {
+				errs = append(errs, fmt.Errorf("%s.body: %w", field, err))
+			}
+		}
+		if q := ch.QuietHours; q != nil {
+			if _, err := notify.ParseQuietHours(q.Start, q.End); err != nil {
+				errs = append(errs, fmt.Errorf("%s.quiet_hours: %w", field, err))
+			}
+			checkTriggers(field+".quiet_hours.except", q.Except)
+		}
+	}
+	if n.UpstreamCheckInterval < time.Second {
+		errs = append(errs, errors.New("notifications.upstream_check_interval: must be at least 1s"))
+	}
+	return errs
+}
+
+// checkController checks the uac_controller reference at field, names are the controllers of uac_controllers.
+func (c *Config) checkController(field, controller string, names map[string]bool) error {
+	switch {
+	case controller == "" && c.UAC == nil:
+		return fmt.Errorf("%s: is required without a uac section", field)
+	case controller != "" && controller != DefaultUACController && !names[controller]:
+		return fmt.Errorf("%s: %q is not configured in uac_controllers", field, controller)
+	case controller == DefaultUACController && c.UAC == nil:
+		return fmt.Errorf("%s: there is no uac section", field)
+	}
+	return nil
+}
+
+// checkHub checks the hubitat_hub reference at field, names are the hubs of hubitat_hubs.
+func (c *Config) checkHub(field, hub string, names map[string]bool) error {
+	switch {
+	case hub == "" && c.Hubitat == nil:
+		return fmt.Errorf("%s: is required without a hubitat section", field)
+	case hub != "" && hub != DefaultHubitatHub && !names[hub]:
+		return fmt.Errorf("%s: %q is not configured in hubitat_hubs", field, hub)
+	case hub == DefaultHubitatHub && c.Hubitat == nil:
+		return fmt.Errorf("%s: there is no hubitat section", field)
+	}
+	return nil
+}
+
+// CheckDuplicates checks that no UAC door or backend device is mapped more than once.
+// Doors referenced by name are only checked once their IDs have been resolved.
+func (c *Config) CheckDuplicates() error {
+	var errs []error
+
+	uacIDs := make(map[string]int)
+	deviceIDs := make(map[[2]string]string)
+	checkDevice := func(field, backend, id string) {
+		if id == "" {
+			return
+		}
+		key := [2]string{backend, id}
+		if prev, ok := deviceIDs[key]; ok {
+			errs = append(errs, fmt.Errorf("%s: device %s is already used by %s", field, id, prev))
+			return
+		}
+		deviceIDs[key] = field
+	}
+
+	for i, d := range c.Doors {
+		if d.UacID != "" {
+			if prev, ok := uacIDs[d.UacID]; ok {
+				errs = append(errs, fmt.Errorf("doors[%d].uac_id: door %s is already mapped by doors[%d]", i, d.UacID, prev))
+			} else {
+				uacIDs[d.UacID] = i
+			}
+		}
+		if d.BackendName() == BackendHomeAssistant {
+			checkDevice(fmt.Sprintf("doors[%d].ha_contact_entity", i), BackendHomeAssistant, d.HAContactEntity)
+			checkDevice(fmt.Sprintf("doors[%d].ha_lock_entity", i), BackendHomeAssistant, d.HALockEntity)
+			checkDevice(fmt.Sprintf("doors[%d].ha_switch_entity", i), BackendHomeAssistant, d.HASwitchEntity)
+			continue
+		}
+		// Hubitat device IDs are only unique within a hub.
+		instance := d.BackendInstance()
+		checkDevice(fmt.Sprintf("doors[%d].hubitat_contact_id", i), instance, d.HubitatContactID)
+		if d.HubitatLockID != nil {
+			checkDevice(fmt.Sprintf("doors[%d].hubitat_lock_id", i), instance, *d.HubitatLockID)
+		}
+		checkDevice(fmt.Sprintf("doors[%d].hubitat_switch_id", i), instance, d.HubitatSwitchID)
+	}
+	for i, g := range c.DoorGroups {
+		instance := HubitatInstance(g.Hub())
+		checkDevice(fmt.Sprintf("door_groups[%d].hubitat_lock_id", i), instance, g.HubitatLockID)
+		checkDevice(fmt.Sprintf("door_groups[%d].hubitat_switch_id", i), instance, g.HubitatSwitchID)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseCIDR parses a network in CIDR notation, or a single IP address as a network of one.
+func ParseCIDR(value string) (*net.IPNet, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// validateBrokerURL checks an MQTT broker URL.
+func validateBrokerURL(value string) error {
+	if value == "" {
+		return errors.New("is required")
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return fmt.Errorf("invalid URL %q: scheme must be tcp, mqtt, ssl, tls or mqtts", value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: host is missing", value)
+	}
+	return nil
+}
+
+// validateURL checks that value is an absolute http(s) URL.
+func validateURL(value string) error {
+	if value == "" {
+		return errors.New("is required")
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q: scheme must be http or https", value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: host is missing", value)
+	}
+	return nil
+}
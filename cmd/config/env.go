@@ -0,0 +1,338 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables that override config fields.
+const EnvPrefix = "UAHM_"
+
+// fileSuffix is the suffix of fields and environment variables that name a file to read a value from.
+const fileSuffix = "_file"
+
+// interpolationPattern matches ${VAR} and ${VAR:-default}. A leading $ escapes the reference.
+var interpolationPattern = regexp.MustCompile(`\$(\$)?\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate replaces the ${VAR} references in every scalar value of a YAML document.
+func interpolate(node *yaml.Node) error {
+	var errs []error
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode {
+			n.Value = interpolationPattern.ReplaceAllStringFunc(n.Value, func(ref string) string {
+				m := interpolationPattern.FindStringSubmatch(ref)
+				if m[1] != "" {
+					return ref[1:]
+				}
+				if value, ok := os.LookupEnv(m[2]); ok {
+					return value
+				}
+				if strings.Contains(ref, ":-") {
+					return m[3]
+				}
+				errs = append(errs, fmt.Errorf("line %d: environment variable %s is not set", n.Line, m[2]))
+				return ""
+			})
+			return
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+	return errors.Join(errs...)
+}
+
+// readSecretFile reads a value from a file, dropping the trailing newline most editors add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// yamlName returns the YAML key of a struct field, or "" when the field is not mapped.
+func yamlName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// fieldByYAMLName returns the field of struct value v with the given YAML key.
+func fieldByYAMLName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// envOverrider applies UAHM_* environment variables to the config fields.
+type envOverrider struct {
+	env  map[string]string
+	errs []error
+}
+
+func newEnvOverrider() *envOverrider {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, EnvPrefix) {
+			env[key] = value
+		}
+	}
+	return &envOverrider{env: env}
+}
+
+// targets reports whether a variable below prefix maps to a field of a struct of type t. A
+// longer sibling key sharing the prefix, e.g. UAHM_UAC_CONTROLLERS_0_API_KEY next to the uac
+// section, does not count.
+func (o *envOverrider) targets(t reflect.Type, prefix string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		name := yamlName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct && ft != reflect.TypeFor[yaml.Node]():
+			if o.targets(ft, key+"_") {
+				return true
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			for k := range o.env {
+				rest, ok := strings.CutPrefix(k, key+"_")
+				index, _, _ := strings.Cut(rest, "_")
+				if _, err := strconv.Atoi(index); ok && err == nil {
+					return true
+				}
+			}
+		case ft.Kind() == reflect.Map:
+		default:
+			_, hasValue := o.env[key]
+			_, hasFile := o.env[key+"_FILE"]
+			if hasValue || hasFile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply overrides the fields of v, a struct, using the variables below prefix.
+func (o *envOverrider) apply(v reflect.Value, prefix string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := yamlName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		if base, ok := strings.CutSuffix(name, fileSuffix); ok {
+			if _, paired := fieldByYAMLName(v, base); paired {
+				// set through the UAHM_<BASE>_FILE variable of the paired field
+				continue
+			}
+		}
+		o.applyField(v, v.Field(i), name, prefix+strings.ToUpper(name))
+	}
+}
+
+// applyField overrides a single field whose environment variable is key.
+func (o *envOverrider) applyField(parent, field reflect.Value, name, key string) {
+	switch {
+	case field.Kind() == reflect.Struct && field.Type() != reflect.TypeFor[yaml.Node]():
+		o.apply(field, key+"_")
+		return
+	case field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Struct:
+		if field.IsNil() {
+			if !o.targets(field.Type().Elem(), key+"_") {
+				return
+			}
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		o.apply(field.Elem(), key+"_")
+		return
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+		o.applySlice(field, key+"_")
+		return
+	case field.Kind() == reflect.Map:
+		// maps have free-form keys and can only be set in YAML
+		return
+	}
+
+	value, hasValue := o.env[key]
+	path, hasFile := o.env[key+"_FILE"]
+	if hasValue && hasFile {
+		o.errs = append(o.errs, fmt.Errorf("%s and %s_FILE must not both be set", key, key))
+		return
+	}
+	target := field
+	if field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.String && (hasValue || hasFile) {
+		field.Set(reflect.New(field.Type().Elem()))
+		target = field.Elem()
+	}
+	if hasFile {
+		if !isStrings(target) && target.Kind() != reflect.String {
+			o.errs = append(o.errs, fmt.Errorf("%s_FILE: only text fields can be read from a file", key))
+			return
+		}
+		secret, err := readSecretFile(path)
+		if err != nil {
+			o.errs = append(o.errs, fmt.Errorf("%s_FILE: %w", key, err))
+			return
+		}
+		if isStrings(target) {
+			target.Set(reflect.ValueOf(splitLines(secret)))
+			o.clearFileField(parent, name)
+			return
+		}
+		value, hasValue = secret, true
+	}
+	if !hasValue {
+		return
+	}
+
+	if target.Kind() == reflect.String {
+		target.SetString(value)
+	} else if err := yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+		o.errs = append(o.errs, fmt.Errorf("%s: %w", key, err))
+		return
+	}
+
+	o.clearFileField(parent, name)
+}
+
+// clearFileField clears the *_file field paired with a field set from the environment,
+// as the environment wins over a file configured in YAML.
+func (o *envOverrider) clearFileField(parent reflect.Value, name string) {
+	if fileField, ok := fieldByYAMLName(parent, name+fileSuffix); ok && fileField.Kind() == reflect.String {
+		fileField.SetString("")
+	}
+}
+
+// isStrings reports whether v is a list of strings, which is read from a file one entry per line.
+func isStrings(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String
+}
+
+// splitLines returns the non-empty lines of s.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// applySlice overrides the elements of a slice of structs. Variables are indexed from 0
+// (UAHM_DOORS_0_UAC_ID) and an index past the end appends new elements.
+func (o *envOverrider) applySlice(field reflect.Value, prefix string) {
+	maxIndex := -1
+	for key := range o.env {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		index, _, _ := strings.Cut(rest, "_")
+		if i, err := strconv.Atoi(index); err == nil && i > maxIndex {
+			maxIndex = i
+		}
+	}
+	if maxIndex >= field.Len() {
+		grown := reflect.MakeSlice(field.Type(), maxIndex+1, maxIndex+1)
+		reflect.Copy(grown, field)
+		field.Set(grown)
+	}
+	for i := 0; i < field.Len(); i++ {
+		o.apply(field.Index(i), fmt.Sprintf("%s%d_", prefix, i))
+	}
+}
+
+// resolveSecretFiles reads the value of every field paired with a *_file field that is set.
+func resolveSecretFiles(v reflect.Value, path string) []error {
+	var errs []error
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			errs = append(errs, resolveSecretFiles(v.Elem(), path)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, resolveSecretFiles(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+
+			base, isFile := strings.CutSuffix(name, fileSuffix)
+			target, paired := fieldByYAMLName(v, base)
+			if !isFile || !paired || (target.Kind() != reflect.String && !isStrings(target)) || v.Field(i).Kind() != reflect.String {
+				errs = append(errs, resolveSecretFiles(v.Field(i), fieldPath)...)
+				continue
+			}
+
+			file := v.Field(i).String()
+			if file == "" {
+				continue
+			}
+			if !target.IsZero() {
+				errs = append(errs, fmt.Errorf("%s: must not be set together with %s", fieldPath, base))
+				continue
+			}
+			secret, err := readSecretFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", fieldPath, err))
+				continue
+			}
+			if isStrings(target) {
+				target.Set(reflect.ValueOf(splitLines(secret)))
+			} else {
+				target.SetString(secret)
+			}
+		}
+	}
+	return errs
+}
+
+// applyEnvironment applies the environment variable overrides and secret files to cfg.
+func applyEnvironment(cfg *Config) error {
+	o := newEnvOverrider()
+	o.apply(reflect.ValueOf(cfg).Elem(), EnvPrefix)
+	errs := append(o.errs, resolveSecretFiles(reflect.ValueOf(cfg), "")...)
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
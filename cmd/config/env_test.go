@@ -0,0 +1,213 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to a file in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestAPIKeyPrecedence checks each source of uac.api_key, from lowest to highest precedence:
+// the YAML value with ${VAR} interpolation, api_key_file, UAHM_UAC_API_KEY and UAHM_UAC_API_KEY_FILE.
+func TestAPIKeyPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		// uac is the uac section, where {{file}} is replaced by the path of a file holding "from-file"
+		uac     string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "yaml value",
+			uac:  `api_key: from-yaml`,
+			want: "from-yaml",
+		},
+		{
+			name: "interpolated variable",
+			uac:  `api_key: "${TEST_UAC_KEY}"`,
+			env:  map[string]string{"TEST_UAC_KEY": "from-var"},
+			want: "from-var",
+		},
+		{
+			name: "interpolation default",
+			uac:  `api_key: "${TEST_UAC_KEY_UNSET:-fallback}"`,
+			want: "fallback",
+		},
+		{
+			name: "escaped reference",
+			uac:  `api_key: "$${TEST_UAC_KEY}"`,
+			env:  map[string]string{"TEST_UAC_KEY": "from-var"},
+			want: "${TEST_UAC_KEY}",
+		},
+		{
+			name:    "interpolated variable not set",
+			uac:     `api_key: "${TEST_UAC_KEY_UNSET}"`,
+			wantErr: "environment variable TEST_UAC_KEY_UNSET is not set",
+		},
+		{
+			name: "yaml file",
+			uac:  `api_key_file: "{{file}}"`,
+			want: "from-file",
+		},
+		{
+			name:    "yaml value and file",
+			uac:     "api_key: from-yaml\n  api_key_file: \"{{file}}\"",
+			wantErr: "uac.api_key_file: must not be set together with api_key",
+		},
+		{
+			name: "environment over yaml value",
+			uac:  `api_key: from-yaml`,
+			env:  map[string]string{"UAHM_UAC_API_KEY": "from-env"},
+			want: "from-env",
+		},
+		{
+			name: "environment over interpolated variable",
+			uac:  `api_key: "${TEST_UAC_KEY}"`,
+			env:  map[string]string{"TEST_UAC_KEY": "from-var", "UAHM_UAC_API_KEY": "from-env"},
+			want: "from-env",
+		},
+		{
+			name: "environment over yaml file",
+			uac:  `api_key_file: "{{file}}"`,
+			env:  map[string]string{"UAHM_UAC_API_KEY": "from-env"},
+			want: "from-env",
+		},
+		{
+			name: "environment file over yaml value",
+			uac:  `api_key: from-yaml`,
+			env:  map[string]string{"UAHM_UAC_API_KEY_FILE": "{{file}}"},
+			want: "from-file",
+		},
+		{
+			name:    "environment value and file",
+			uac:     `api_key: from-yaml`,
+			env:     map[string]string{"UAHM_UAC_API_KEY": "from-env", "UAHM_UAC_API_KEY_FILE": "{{file}}"},
+			wantErr: "UAHM_UAC_API_KEY and UAHM_UAC_API_KEY_FILE must not both be set",
+		},
+		{
+			name:    "environment file missing",
+			uac:     `api_key: from-yaml`,
+			env:     map[string]string{"UAHM_UAC_API_KEY_FILE": "/nonexistent/uac_api_key"},
+			wantErr: "UAHM_UAC_API_KEY_FILE: open /nonexistent/uac_api_key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// the trailing newline is dropped like that of Docker secrets
+			secret := writeFile(t, dir, "secret", "from-file\n")
+			for key, value := range tt.env {
+				t.Setenv(key, strings.ReplaceAll(value, "{{file}}", secret))
+			}
+			uac := strings.ReplaceAll(tt.uac, "{{file}}", secret)
+			path := writeFile(t, dir, "config.yaml", "uac:\n  base_url: https://uac.example\n  "+uac+"\n")
+
+			cfg, err := LoadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.UAC.APIKey != tt.want {
+				t.Errorf("api_key = %q, want %q", cfg.UAC.APIKey, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentFields(t *testing.T) {
+	dir := t.TempDir()
+	tokens := writeFile(t, dir, "admin_tokens", "first\n\nsecond\n")
+	t.Setenv("UAHM_SERVER_TOKENS_ADMIN_FILE", tokens)
+	t.Setenv("UAHM_SERVER_START_DEGRADED", "true")
+	t.Setenv("UAHM_DOORS_0_HUBITAT_CONTACT_ID", "12")
+	t.Setenv("UAHM_DOORS_1_UAC_ID", "door-2")
+	path := writeFile(t, dir, "config.yaml", `server:
+  tokens:
+    admin: [from-yaml]
+doors:
+  - uac_id: door-1
+    hubitat_contact_id: "11"
+`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if want := []string{"first", "second"}; !slices.Equal(cfg.Server.Tokens.Admin, want) {
+		t.Errorf("server.tokens.admin = %q, want one token per line %q", cfg.Server.Tokens.Admin, want)
+	}
+	if !cfg.Server.StartDegraded {
+		t.Error("server.start_degraded = false, want the value parsed as YAML")
+	}
+	if len(cfg.Doors) != 2 {
+		t.Fatalf("got %d doors, want an entry appended for index 1", len(cfg.Doors))
+	}
+	if d := cfg.Doors[0]; d.UacID != "door-1" || d.HubitatContactID != "12" {
+		t.Errorf("doors[0] = %s/%s, want door-1/12", d.UacID, d.HubitatContactID)
+	}
+	if d := cfg.Doors[1]; d.UacID != "door-2" {
+		t.Errorf("doors[1].uac_id = %q, want door-2", d.UacID)
+	}
+}
+
+func TestEnvironmentNonTextFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("UAHM_SERVER_START_DEGRADED_FILE", writeFile(t, dir, "degraded", "true"))
+	path := writeFile(t, dir, "config.yaml", "server: {}\n")
+
+	_, err := LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "only text fields can be read from a file") {
+		t.Fatalf("LoadConfig() error = %v, want non-text file rejected", err)
+	}
+}
+
+func TestEnvironmentSections(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "server: {}\n")
+	t.Setenv("UAHM_UAC_CONTROLLERS_0_NAME", "warehouse")
+	t.Setenv("UAHM_UAC_CONTROLLERS_0_API_KEY", "warehouse-key")
+	t.Setenv("UAHM_HUBITAT_HUBS_0_NAME", "garage")
+	t.Setenv("UAHM_HUBITAT_HUBS_0_ACCESS_TOKEN", "garage-token")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	// the variables of the lists share the prefix of the default sections but don't create them
+	if cfg.UAC != nil || cfg.Hubitat != nil {
+		t.Errorf("uac = %+v, hubitat = %+v, want both sections left out", cfg.UAC, cfg.Hubitat)
+	}
+	if len(cfg.UACControllers) != 1 || cfg.UACControllers[0].APIKey != "warehouse-key" {
+		t.Errorf("uac_controllers = %+v, want the warehouse controller", cfg.UACControllers)
+	}
+	if len(cfg.HubitatHubs) != 1 || cfg.HubitatHubs[0].AccessToken != "garage-token" {
+		t.Errorf("hubitat_hubs = %+v, want the garage hub", cfg.HubitatHubs)
+	}
+
+	// a variable of a field of its own, also a nested one, creates a section
+	t.Setenv("UAHM_HUBITAT_TLS_INSECURE_SKIP_VERIFY", "true")
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.UAC != nil || cfg.Hubitat == nil || cfg.Hubitat.TLS == nil || !cfg.Hubitat.TLS.InsecureSkipVerify {
+		t.Errorf("uac = %+v, hubitat = %+v, want only the hubitat section with its tls options", cfg.UAC, cfg.Hubitat)
+	}
+}
@@ -0,0 +1,261 @@
+"use strict";
+
+// The dashboard talks to the admin API with the admin token kept in local storage.
+const tokenKey = "uahm.adminToken";
+const refreshInterval = 5000;
+const eventsLimit = 100;
+const reconnectDelay = 3000;
+
+const $ = (sel, root = document) => root.querySelector(sel);
+
+let refreshTimer = null;
+let stream = null;
+let events = [];
+
+function token() {
+  return localStorage.getItem(tokenKey) || "";
+}
+
+class UnauthorizedError extends Error {}
+
+async function api(method, path, body) {
+  const opts = { method, headers: { Authorization: "Bearer " + token() } };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, opts);
+  if (resp.status === 401) {
+    throw new UnauthorizedError("invalid or missing admin token");
+  }
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    const msg = data.error || (data.errors || []).join("; ") || resp.statusText;
+    throw new Error(msg);
+  }
+  return data;
+}
+
+function showLogin(message) {
+  clearInterval(refreshTimer);
+  refreshTimer = null;
+  closeStream();
+  $("#app").hidden = true;
+  $("#login").hidden = false;
+  $("#login-error").textContent = message || "";
+  $("#token").focus();
+}
+
+function showApp() {
+  $("#login").hidden = true;
+  $("#app").hidden = false;
+  refresh();
+  loadEvents();
+  if (!refreshTimer) {
+    refreshTimer = setInterval(refresh, refreshInterval);
+  }
+}
+
+function handleError(err) {
+  if (err instanceof UnauthorizedError) {
+    showLogin(token() ? err.message : "");
+    return;
+  }
+  $("#error").textContent = err.message;
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function lockRuleText(uac) {
+  if (!uac.lock_rule) {
+    return "none";
+  }
+  if (uac.lock_rule_ends) {
+    return uac.lock_rule + " until " + new Date(uac.lock_rule_ends * 1000).toLocaleTimeString();
+  }
+  return uac.lock_rule;
+}
+
+function renderDevices(tbody, devices) {
+  tbody.replaceChildren();
+  for (const type of ["contact", "lock", "switch"]) {
+    const device = devices[type];
+    if (!device) {
+      continue;
+    }
+    const row = tbody.insertRow();
+    row.insertCell().textContent = type;
+    row.insertCell().textContent = device.name || device.id;
+    row.insertCell().textContent = device.state || "unknown";
+    const sync = row.insertCell();
+    if (device.in_sync === true) {
+      sync.textContent = "in sync";
+      sync.className = "in-sync";
+    } else if (device.in_sync === false) {
+      sync.textContent = "expected " + device.expected;
+      sync.className = "out-of-sync";
+    }
+  }
+}
+
+function renderDoor(door) {
+  const node = $("#door-template").content.firstElementChild.cloneNode(true);
+  node.dataset.door = door.uac_id;
+  $(".name", node).textContent = door.name;
+  if (door.controller && door.controller !== "default") {
+    $(".name", node).textContent += " (" + door.controller + ")";
+  }
+  $(".position", node).textContent = door.uac.position || "unknown";
+  $(".relay", node).textContent = door.uac.relay || "unknown";
+  $(".lock-rule", node).textContent = lockRuleText(door.uac);
+  $(".backend", node).textContent = door.backend === "home_assistant" ? "Home Assistant" : "Hubitat";
+  if (door.hub && door.hub !== "default") {
+    $(".backend", node).textContent += " (" + door.hub + ")";
+  }
+  renderDevices($(".devices tbody", node), door.devices || {});
+
+  if (door.last_event) {
+    $(".activity", node).textContent = "Last event: " + door.last_event_type + " at " + formatTime(door.last_event);
+  }
+  const errors = (door.errors || []).slice();
+  if (door.last_error) {
+    errors.push(door.last_error + " (" + formatTime(door.last_error_time) + ")");
+  }
+  $(".last-error", node).textContent = errors.join("; ");
+
+  for (const button of node.querySelectorAll("button[data-action]")) {
+    button.addEventListener("click", () => doorAction(door, button));
+  }
+  return node;
+}
+
+function renderEvents() {
+  const tbody = $("#events tbody");
+  tbody.replaceChildren();
+  for (const e of events.slice().reverse()) {
+    const row = tbody.insertRow();
+    for (const value of [formatTime(e.time), e.door, e.source, e.type, e.value, e.actor]) {
+      row.insertCell().textContent = value || "";
+    }
+  }
+}
+
+async function refresh() {
+  try {
+    const status = await api("GET", "/api/v1/doors");
+    $("#doors").replaceChildren(...status.doors.map(renderDoor));
+    $("#error").textContent = "";
+    $("#updated").textContent = "Updated " + new Date(status.updated).toLocaleTimeString();
+  } catch (err) {
+    handleError(err);
+  }
+}
+
+// loadEvents shows the recent events, then follows the event stream for new ones.
+async function loadEvents() {
+  try {
+    const data = await api("GET", "/api/v1/events?limit=" + eventsLimit);
+    events = data.events;
+    renderEvents();
+    await openStream(events.length ? events[events.length - 1].id : 0);
+  } catch (err) {
+    handleError(err);
+  }
+}
+
+async function openStream(lastID) {
+  closeStream();
+  // an EventSource can't send headers, so it connects with a single-use ticket
+  const { ticket } = await api("POST", "/api/v1/events/stream-ticket");
+  stream = new EventSource("/events/stream?ticket=" + encodeURIComponent(ticket));
+  stream.onmessage = (msg) => {
+    const e = JSON.parse(msg.data);
+    if (e.id <= lastID) {
+      return;
+    }
+    lastID = e.id;
+    events.push(e);
+    events = events.slice(-eventsLimit);
+    renderEvents();
+    refreshSoon();
+  };
+  stream.onerror = () => {
+    // the ticket was used up, so instead of the browser reconnect with it, reload the
+    // events missed and connect with a new ticket
+    closeStream();
+    refresh();
+    setTimeout(() => {
+      if (!$("#app").hidden && !stream) {
+        loadEvents();
+      }
+    }, reconnectDelay);
+  };
+}
+
+function closeStream() {
+  if (stream) {
+    stream.close();
+    stream = null;
+  }
+}
+
+let refreshSoonTimer = null;
+
+// refreshSoon refreshes the doors shortly after an event, once for a burst of events.
+function refreshSoon() {
+  clearTimeout(refreshSoonTimer);
+  refreshSoonTimer = setTimeout(refresh, 500);
+}
+
+async function doorAction(door, button) {
+  const action = button.dataset.action;
+  let body;
+  if (action === "timed-unlock") {
+    const minutes = parseInt(prompt("Unlock " + door.name + " for how many minutes?", "15"), 10);
+    if (!(minutes > 0)) {
+      return;
+    }
+    body = { minutes };
+  }
+  button.disabled = true;
+  try {
+    await api("POST", "/api/v1/doors/" + encodeURIComponent(door.uac_id) + "/" + action, body);
+    await refresh();
+  } catch (err) {
+    handleError(err);
+  } finally {
+    button.disabled = false;
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("#login").addEventListener("submit", (e) => {
+    e.preventDefault();
+    localStorage.setItem(tokenKey, $("#token").value);
+    $("#token").value = "";
+    showApp();
+  });
+  $("#logout").addEventListener("click", () => {
+    localStorage.removeItem(tokenKey);
+    showLogin();
+  });
+  $("#reconcile").addEventListener("click", async (e) => {
+    e.target.disabled = true;
+    try {
+      await api("POST", "/api/v1/reconcile");
+      await refresh();
+    } catch (err) {
+      handleError(err);
+    } finally {
+      e.target.disabled = false;
+    }
+  });
+
+  if (token()) {
+    showApp();
+  } else {
+    showLogin();
+  }
+});
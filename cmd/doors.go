@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+)
+
+// mappedDevice is a backend device mapped to a door.
+type mappedDevice struct {
+	door       *config.Door
+	deviceType string // "contact", "lock", or "switch"
+}
+
+// deviceKey identifies a device in one of the backends.
+type deviceKey struct {
+	backend, id string
+}
+
+// doorIndex maps UAC door IDs and backend device IDs to the configured doors.
+type doorIndex struct {
+	byUacID  map[string]*config.Door
+	byDevice map[deviceKey]mappedDevice
+	// byGroupDevice maps the devices of the door groups.
+	byGroupDevice map[deviceKey]mappedGroup
+}
+
+// newDoorIndex builds the lookup index for the given doors and door groups.
+func newDoorIndex(doors []config.Door, groups []config.DoorGroup) *doorIndex {
+	idx := &doorIndex{
+		byUacID:       make(map[string]*config.Door, len(doors)),
+		byDevice:      make(map[deviceKey]mappedDevice, len(doors)*3),
+		byGroupDevice: make(map[deviceKey]mappedGroup, len(groups)*2),
+	}
+	for i := range doors {
+		d := &doors[i]
+		backend := d.BackendInstance()
+		idx.byUacID[d.UacID] = d
+		idx.byDevice[deviceKey{backend, d.ContactID()}] = mappedDevice{door: d, deviceType: "contact"}
+		if lockID, ok := d.LockID(); ok {
+			idx.byDevice[deviceKey{backend, lockID}] = mappedDevice{door: d, deviceType: "lock"}
+		}
+		idx.byDevice[deviceKey{backend, d.SwitchID()}] = mappedDevice{door: d, deviceType: "switch"}
+	}
+	for i := range groups {
+		g := &groups[i]
+		backend := config.HubitatInstance(g.Hub())
+		if g.HubitatLockID != "" {
+			idx.byGroupDevice[deviceKey{backend, g.HubitatLockID}] = mappedGroup{group: g, deviceType: "lock"}
+		}
+		if g.HubitatSwitchID != "" {
+			idx.byGroupDevice[deviceKey{backend, g.HubitatSwitchID}] = mappedGroup{group: g, deviceType: "switch"}
+		}
+	}
+	return idx
+}
+
+// heldOpenTimers tracks the open doors that have a held open threshold.
+var heldOpenTimers = struct {
+	sync.Mutex
+	timers map[string]*time.Timer
+}{timers: make(map[string]*time.Timer)}
+
+// trackDoorPosition starts the held open timer when a door opens and stops it when the door closes.
+func trackDoorPosition(door *config.Door, open bool) {
+	threshold := door.Options.HeldOpenThreshold
+	if threshold <= 0 {
+		return
+	}
+
+	heldOpenTimers.Lock()
+	defer heldOpenTimers.Unlock()
+
+	timer, running := heldOpenTimers.timers[door.UacID]
+	if !open {
+		if running {
+			timer.Stop()
+			delete(heldOpenTimers.timers, door.UacID)
+		}
+		return
+	}
+	if running {
+		// already open, keep the original timer
+		return
+	}
+
+	uacID := door.UacID
+	heldOpenTimers.timers[uacID] = time.AfterFunc(threshold, func() {
+		logger.Warn("Door held open", slog.String("door_id", uacID), slog.Duration("threshold", threshold))
+		publishDoorEvent(door, "app", "held_open", threshold.String(), "")
+	})
+}
+
+// lastUnlocks holds when each door was last unlocked, to tell a forced entry from a normal one.
+var lastUnlocks = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// noteUnlock remembers the time of an event that unlocked a door.
+func noteUnlock(e events.Event) {
+	switch e.Type {
+	case "unlock", "keep_unlock", "timed_unlock":
+	case "command":
+		if e.Value == "lock" {
+			return
+		}
+	default:
+		return
+	}
+	lastUnlocks.Lock()
+	lastUnlocks.at[e.DoorID] = e.Time
+	lastUnlocks.Unlock()
+}
+
+// checkForcedOpen reports a door that just opened as forced open when it wasn't unlocked within
+// its forced open window, its relay is locked and its lock rule doesn't keep it unlocked.
+func checkForcedOpen(door *config.Door) {
+	window := door.Options.ForcedOpenWindow
+	if window <= 0 {
+		return
+	}
+	lastUnlocks.Lock()
+	last := lastUnlocks.at[door.UacID]
+	lastUnlocks.Unlock()
+	if time.Since(last) <= window {
+		return
+	}
+
+	// the door may have been unlocked without an event, e.g. by a lock rule or from the UniFi app
+	d, err := uacFor(door).FetchDoor(door.UacID)
+	if err != nil {
+		logger.Warn("Failed to fetch door to check for forced entry", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+		return
+	}
+	if d.DoorLockRelayStatus == "unlock" {
+		return
+	}
+	rule, err := uacFor(door).GetDoorLockRule(door.UacID)
+	if err != nil {
+		logger.Warn("Failed to get door lock rule to check for forced entry", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+		return
+	}
+	if state, known := lockRuleState(rule.Type); known && state == "unlocked" {
+		return
+	}
+
+	logger.Warn("Door forced open", slog.String("door_id", door.UacID), slog.Duration("window", window))
+	publishDoorEvent(door, "uac", "forced_open", "", "")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+func TestForcedOpenLockRules(t *testing.T) {
+	tests := []struct {
+		rule   string
+		forced bool
+	}{
+		{"", true},
+		// a door held shut by a rule is the one where a forced entry matters most
+		{"keep_lock", true},
+		{"lock_early", true},
+		{"keep_unlock", false},
+		{"custom", false},
+		// an unknown rule doesn't hide a forced entry
+		{"new_rule", true},
+	}
+	for _, tt := range tests {
+		door := config.Door{UacID: "d1", Options: config.DoorOptions{ForcedOpenWindow: time.Minute}}
+		setTestGroup(t, map[string]string{"d1": tt.rule}, config.DoorGroup{Name: "all"}, door)
+		events, unsubscribe := eventBus.Subscribe(10)
+
+		checkForcedOpen(&getAppConfig().Doors[0])
+		unsubscribe()
+		forced := false
+		for e := range events {
+			forced = forced || (e.Type == "forced_open" && e.DoorID == "d1")
+		}
+		if forced != tt.forced {
+			t.Errorf("opened with the lock rule %q: reported forced open %t, want %t", tt.rule, forced, tt.forced)
+		}
+	}
+}
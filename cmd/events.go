@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+)
+
+// recentEventsSize is the number of events kept in memory for the events API.
+const recentEventsSize = 1000
+
+// eventBus distributes the door events.
+var eventBus = events.NewBus(recentEventsSize)
+
+// publishDoorEvent publishes an event for a door and writes it to the journal.
+func publishDoorEvent(door *config.Door, source, eventType, value, actor string) {
+	e := eventBus.Publish(events.Event{
+		Source: source,
+		Type:   eventType,
+		DoorID: door.UacID,
+		Door:   door.Name(),
+		Value:  value,
+		Actor:  actor,
+	})
+	noteUnlock(e)
+	journalEvent(e)
+}
+
+// publishAccessEvent publishes an access attempt reported by UAC, with its result.
+func publishAccessEvent(door *config.Door, eventType, actor, result string) {
+	e := eventBus.Publish(events.Event{
+		Source: "uac",
+		Type:   eventType,
+		DoorID: door.UacID,
+		Door:   door.Name(),
+		Actor:  actor,
+		Result: result,
+	})
+	noteUnlock(e)
+	journalEvent(e)
+}
+
+// publishAppEvent publishes an event that is not about a single door, e.g. an upstream going down.
+func publishAppEvent(source, eventType, value, result string) {
+	e := eventBus.Publish(events.Event{
+		Source: source,
+		Type:   eventType,
+		Value:  value,
+		Result: result,
+	})
+	journalEvent(e)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// mappedGroup is a Hubitat device mapped to a door group.
+type mappedGroup struct {
+	group      *config.DoorGroup
+	deviceType string // "lock" or "switch"
+}
+
+// getGroupByDevice returns the door group and device type for a device ID of a backend instance.
+func getGroupByDevice(backend, deviceID string) (group *config.DoorGroup, deviceType string, found bool) {
+	device, found := appState.Load().doors.byGroupDevice[deviceKey{backend, deviceID}]
+	return device.group, device.deviceType, found
+}
+
+// resolveGroup fills in the members and device IDs of a door group. uacGroups are the door groups
+// of its controller and devices the devices of its hub, both only fetched when needed. While the
+// controller is unreachable the uac_group is left unresolved and only the listed doors are members.
+func resolveGroup(cfg *config.Config, g *config.DoorGroup, uacGroups []uac.DoorGroup, reachable bool, devices []hubitat.Device) []error {
+	var errs []error
+	g.Members = nil
+	addMember := func(id string) {
+		if !slices.Contains(g.Members, id) {
+			g.Members = append(g.Members, id)
+		}
+	}
+
+	for _, ref := range g.Doors {
+		door, found := lookupDoor(cfg.Doors, ref)
+		if !found || door.UacID == "" {
+			errs = append(errs, fmt.Errorf("doors: %q is not a configured door", ref))
+			continue
+		}
+		addMember(door.UacID)
+	}
+
+	if g.UACGroup != "" && !reachable {
+		logger.Warn("UAC controller of door group is unreachable, skipping its uac_group",
+			slog.String("group", g.Name), slog.String("controller", g.Controller()))
+	} else if g.UACGroup != "" {
+		var matches []uac.DoorGroup
+		for _, ug := range uacGroups {
+			if ug.ID == g.UACGroup || strings.EqualFold(ug.Name, g.UACGroup) {
+				matches = append(matches, ug)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			errs = append(errs, fmt.Errorf("uac_group %q does not match any UniFi Access door group", g.UACGroup))
+		case 1:
+			for _, id := range matches[0].DoorIDs() {
+				configured := slices.ContainsFunc(cfg.Doors, func(d config.Door) bool {
+					return d.UacID == id && d.Controller() == g.Controller()
+				})
+				if !configured {
+					// door groups often include doors that are not mirrored
+					logger.Warn("Door of UAC door group is not configured, skipping it",
+						slog.String("group", g.Name), slog.String("door_id", id))
+					continue
+				}
+				addMember(id)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("uac_group %q matches %d UniFi Access door groups", g.UACGroup, len(matches)))
+		}
+	}
+	if len(errs) == 0 && len(g.Members) == 0 && reachable {
+		errs = append(errs, errors.New("has no configured doors"))
+	}
+
+	resolve := func(id *string, field, label string) {
+		if *id != "" || label == "" {
+			return
+		}
+		resolved, err := resolveDeviceLabel(devices, field, label)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*id = resolved
+	}
+	resolve(&g.HubitatLockID, "hubitat_lock_label", g.HubitatLockLabel)
+	resolve(&g.HubitatSwitchID, "hubitat_switch_label", g.HubitatSwitchLabel)
+	return errs
+}
+
+// groupResult is the outcome of a group command for one member door.
+type groupResult struct {
+	door string
+	err  error
+}
+
+// handleGroupEvent carries out the UAC command for a state change of a group device on every
+// member door in parallel: turning the switch on unlocks the doors, and the lock keeps them
+// unlocked or locks them. Each door is audited on its own and the failures are reported together.
+func handleGroupEvent(group *config.DoorGroup, deviceType, deviceID, value string) {
+	if deviceType == "lock" && groupLockWrites.echoed(group.Hub(), deviceID, value) {
+		// the lock only reports the state of its members the middleware wrote to it
+		logger.Debug("Ignoring the echo of a group lock update", slog.String("group", group.Name),
+			slog.String("device_id", deviceID), slog.String("value", value))
+		return
+	}
+
+	var command string
+	var apply func(door *config.Door) error
+	switch {
+	case deviceType == "switch" && value == "on":
+		command = "unlock"
+		apply = func(door *config.Door) error { return uacFor(door).AssertToggleDoorUnlock(door.UacID) }
+	case deviceType == "switch":
+		return
+	case deviceType == "lock" && value == "unlocked":
+		command = "keep_unlock"
+		apply = func(door *config.Door) error { return uacFor(door).AssertUnlockDoor(door.UacID) }
+	case deviceType == "lock" && value == "locked":
+		command = "lock"
+		apply = func(door *config.Door) error { return uacFor(door).AssertLockDoor(door.UacID) }
+	default:
+		logger.Error("Unknown group device value", slog.String("group", group.Name),
+			slog.String("device_id", deviceID), slog.String("value", value))
+		return
+	}
+
+	trigger := fmt.Sprintf("hubitat group %s %s %s (device %s)", group.Name, deviceType, value, deviceID)
+	results := make([]groupResult, len(group.Members))
+	var wg sync.WaitGroup
+	for i, id := range group.Members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			door, found := getDoorByUacID(id)
+			if !found {
+				// the door was disabled or removed since the group was resolved
+				results[i] = groupResult{door: id, err: errors.New("door is not configured")}
+				return
+			}
+			err := apply(door)
+			recordAudit(doorAudit(door, command, config.BackendHubitat, "", trigger), err)
+			results[i] = groupResult{door: door.Name(), err: err}
+			if err != nil {
+				recordDoorError(door, err)
+				return
+			}
+			publishDoorEvent(door, "app", "command", command, config.BackendHubitat)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.err != nil {
+			logger.Error("Failed to execute group command for door", slog.String("group", group.Name),
+				slog.String("command", command), slog.String("door", r.door), slog.String("err", r.err.Error()))
+			failed = append(failed, r.door+": "+r.err.Error())
+		}
+	}
+	outcome := fmt.Sprintf("%d of %d doors done", len(results)-len(failed), len(results))
+	if len(failed) > 0 {
+		outcome += ", failed " + strings.Join(failed, "; ")
+		logger.Error("Group command failed for some doors", slog.String("group", group.Name),
+			slog.String("command", command), slog.Int("failed", len(failed)), slog.Int("doors", len(results)))
+	} else {
+		logger.Info("Group command applied", slog.String("group", group.Name),
+			slog.String("command", command), slog.Int("doors", len(results)))
+	}
+	publishAppEvent(config.BackendHubitat, "group_command", command, group.Name+": "+outcome)
+	lastGroupResults.Lock()
+	lastGroupResults.byGroup[group.Name] = command + ": " + outcome
+	lastGroupResults.Unlock()
+
+	if deviceType == "lock" {
+		// report the actual state, which stays unlocked when a door failed to lock
+		_, _ = syncGroupLock(group, "")
+	}
+}
+
+// groupLockWrites holds the state the middleware last wrote to each group lock, so the event the
+// hub sends back for it is not carried out on every member as a group command.
+var groupLockWrites = pendingGroupLocks{byDevice: make(map[deviceKey]string)}
+
+type pendingGroupLocks struct {
+	sync.Mutex
+	byDevice map[deviceKey]string
+}
+
+// expect remembers that state is being written to the lock of a hub, an empty state forgets it.
+func (p *pendingGroupLocks) expect(hub, deviceID, state string) {
+	p.Lock()
+	defer p.Unlock()
+	key := deviceKey{config.HubitatInstance(hub), deviceID}
+	if state == "" {
+		delete(p.byDevice, key)
+		return
+	}
+	p.byDevice[key] = state
+}
+
+// echoed reports whether value is the state last written to the lock of a hub, and forgets that
+// write: any later event of the lock is a change made on the hub.
+func (p *pendingGroupLocks) echoed(hub, deviceID, value string) bool {
+	p.Lock()
+	defer p.Unlock()
+	key := deviceKey{config.HubitatInstance(hub), deviceID}
+	pending, found := p.byDevice[key]
+	delete(p.byDevice, key)
+	return found && pending == value
+}
+
+// groupLockState returns "locked" when every member of a group is locked and "unlocked" otherwise.
+func groupLockState(group *config.DoorGroup) (string, error) {
+	state := "locked"
+	for _, id := range group.Members {
+		door, found := getDoorByUacID(id)
+		if !found {
+			return "", fmt.Errorf("door %s is not configured", id)
+		}
+		rule, err := uacFor(door).GetDoorLockRule(door.UacID)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", door.Name(), err)
+		}
+		if s, known := lockRuleState(rule.Type); !known || s != "locked" {
+			state = "unlocked"
+		}
+	}
+	return state, nil
+}
+
+// syncGroupLock sets the lock of a group to the state of its members unless it is prev, the
+// state last mirrored, and returns the new state.
+func syncGroupLock(group *config.DoorGroup, prev string) (string, error) {
+	if group.HubitatLockID == "" {
+		return prev, nil
+	}
+	state, err := groupLockState(group)
+	if err != nil {
+		return prev, err
+	}
+	if state == prev {
+		return state, nil
+	}
+	backend, ok := backends[config.HubitatInstance(group.Hub())]
+	if !ok {
+		return prev, fmt.Errorf("hubitat_hub %s is not running", group.Hub())
+	}
+	// the event of the update may arrive before AssertLock returns
+	groupLockWrites.expect(group.Hub(), group.HubitatLockID, state)
+	if err := backend.AssertLock(group.HubitatLockID, state == "locked"); err != nil {
+		groupLockWrites.expect(group.Hub(), group.HubitatLockID, "")
+		return prev, err
+	}
+	return state, nil
+}
+
+// syncGroupLocks mirrors the state of the members of every group with a lock. states holds the
+// state last mirrored for each group, only groups whose state differs are asserted.
+func syncGroupLocks(states map[string]string) error {
+	var errs []error
+	cfg := getAppConfig()
+	for i := range cfg.DoorGroups {
+		group := &cfg.DoorGroups[i]
+		state, err := syncGroupLock(group, states[group.Name])
+		if err != nil {
+			logger.Error("Failed to sync group lock", slog.String("group", group.Name), slog.String("err", err.Error()))
+			pollErrors.Inc()
+			errs = append(errs, fmt.Errorf("group %s: %w", group.Name, err))
+			continue
+		}
+		states[group.Name] = state
+	}
+	return errors.Join(errs...)
+}
+
+// pollGroupLocks keeps the group locks in sync with their members until ctx is cancelled.
+func pollGroupLocks(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	states := make(map[string]string)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = syncGroupLocks(states)
+		}
+	}
+}
+
+// lastGroupResults holds the summary of the last command of each group.
+var lastGroupResults = struct {
+	sync.Mutex
+	byGroup map[string]string
+}{byGroup: make(map[string]string)}
+
+// groupStatus is the state of a door group.
+type groupStatus struct {
+	Name    string                   `json:"name"`
+	Hub     string                   `json:"hub"`
+	Members []string                 `json:"members"`
+	Devices map[string]*deviceStatus `json:"devices"`
+	// LastCommand summarizes the outcome of the last command for the members.
+	LastCommand string   `json:"last_command,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// collectGroupStatus reports the state of every door group, doors are the states of the doors.
+// The group lock is expected to be locked when the lock rules of all members are.
+func collectGroupStatus(cfg *config.Config, doors []doorStatus) []groupStatus {
+	byUacID := make(map[string]*doorStatus, len(doors))
+	for i := range doors {
+		byUacID[doors[i].UacID] = &doors[i]
+	}
+
+	lastGroupResults.Lock()
+	defer lastGroupResults.Unlock()
+	statuses := make([]groupStatus, 0, len(cfg.DoorGroups))
+	for i := range cfg.DoorGroups {
+		g := &cfg.DoorGroups[i]
+		s := groupStatus{
+			Name:        g.Name,
+			Hub:         g.Hub(),
+			Members:     []string{},
+			Devices:     make(map[string]*deviceStatus),
+			LastCommand: lastGroupResults.byGroup[g.Name],
+		}
+		lockExpected := "locked"
+		for _, id := range g.Members {
+			d, ok := byUacID[id]
+			if !ok {
+				s.Members = append(s.Members, id)
+				s.Errors = append(s.Errors, fmt.Sprintf("door %s is not configured", id))
+				lockExpected = ""
+				continue
+			}
+			s.Members = append(s.Members, d.Name)
+			state, known := lockRuleState(d.UAC.LockRule)
+			switch {
+			case d.UAC.LockRule == "" && len(d.Errors) > 0:
+				lockExpected = "" // the lock rule may not have been fetched
+			case lockExpected != "" && (!known || state != "locked"):
+				lockExpected = "unlocked"
+			}
+		}
+
+		backend, ok := backends[config.HubitatInstance(g.Hub())]
+		if !ok {
+			s.Errors = append(s.Errors, fmt.Sprintf("hubitat_hub %s is not running", g.Hub()))
+			statuses = append(statuses, s)
+			continue
+		}
+		for _, dev := range []struct{ kind, id, expected string }{
+			{"lock", g.HubitatLockID, lockExpected}, {"switch", g.HubitatSwitchID, ""},
+		} {
+			if dev.id == "" {
+				continue
+			}
+			name, state, err := backend.DeviceState(dev.kind, dev.id)
+			if err != nil {
+				s.Errors = append(s.Errors, fmt.Sprintf("%s: %s", dev.kind, err))
+				s.Devices[dev.kind] = &deviceStatus{ID: dev.id}
+				continue
+			}
+			status := &deviceStatus{ID: dev.id, Name: name, State: state, Expected: dev.expected}
+			if dev.expected != "" {
+				inSync := status.State == dev.expected
+				status.InSync = &inSync
+			}
+			s.Devices[dev.kind] = status
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
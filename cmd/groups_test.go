@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// fakeLockRules serves the lock rules of UAC doors and records the rules set.
+type fakeLockRules struct {
+	mu    sync.Mutex
+	rules map[string]string
+	set   []string
+}
+
+func (f *fakeLockRules) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/developer/doors/"), "/lock_rule")
+	if r.Method == http.MethodPut {
+		var rule struct{ Type string }
+		json.NewDecoder(r.Body).Decode(&rule)
+		f.set = append(f.set, id+" "+rule.Type)
+		json.NewEncoder(w).Encode(uac.Response[any]{Code: "SUCCESS"})
+		return
+	}
+	json.NewEncoder(w).Encode(uac.Response[uac.DoorLockRule]{Code: "SUCCESS", Data: uac.DoorLockRule{Type: f.rules[id]}})
+}
+
+func (f *fakeLockRules) setRules() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.set
+}
+
+// echoingHub is a Hubitat hub whose lock reports every update back as an event, like the Maker
+// API does while the update is still in flight.
+type echoingHub struct {
+	deviceBackend
+	group  *config.DoorGroup
+	writes []bool
+}
+
+func (h *echoingHub) AssertLock(id string, locked bool) error {
+	h.writes = append(h.writes, locked)
+	if len(h.writes) == 1 {
+		handleGroupEvent(h.group, "lock", id, map[bool]string{true: "locked", false: "unlocked"}[locked])
+	}
+	return nil
+}
+
+// setTestGroup makes doors and group the config in effect, with lock rules served by a fake UAC.
+func setTestGroup(t *testing.T, rules map[string]string, group config.DoorGroup, doors ...config.Door) (*fakeLockRules, *echoingHub) {
+	t.Helper()
+	setTestDoors(t)
+	setAppConfig(&config.Config{Doors: doors, DoorGroups: []config.DoorGroup{group}})
+	g := &getAppConfig().DoorGroups[0]
+
+	fake := &fakeLockRules{rules: rules}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	hub := &echoingHub{group: g}
+	savedUAC, savedBackends := uacClients, backends
+	t.Cleanup(func() { uacClients, backends = savedUAC, savedBackends })
+	uacClients = map[string]*uac.Client{config.DefaultUACController: uac.NewClient(srv.URL, "key", nil)}
+	backends = map[string]deviceBackend{config.HubitatInstance(g.Hub()): hub}
+	return fake, hub
+}
+
+func TestGroupLockState(t *testing.T) {
+	tests := []struct {
+		rules []string
+		want  string
+	}{
+		{[]string{"", ""}, "locked"},
+		{[]string{"keep_lock", "lock_early"}, "locked"},
+		{[]string{"", "keep_unlock"}, "unlocked"},
+		{[]string{"", "custom"}, "unlocked"},
+	}
+	group := config.DoorGroup{Name: "all", Members: []string{"d1", "d2"}}
+	doors := []config.Door{{UacID: "d1"}, {UacID: "d2"}}
+	for _, tt := range tests {
+		setTestGroup(t, map[string]string{"d1": tt.rules[0], "d2": tt.rules[1]}, group, doors...)
+		got, err := groupLockState(&getAppConfig().DoorGroups[0])
+		if err != nil || got != tt.want {
+			t.Errorf("groupLockState() with rules %q = %q, %v, want %q", tt.rules, got, err, tt.want)
+		}
+	}
+}
+
+func TestPartialGroupStateDoesNotFanOut(t *testing.T) {
+	group := config.DoorGroup{Name: "all", HubitatLockID: "50", Members: []string{"d1", "d2"}}
+	// one door is kept unlocked, the other is locked
+	fake, hub := setTestGroup(t, map[string]string{"d1": "keep_unlock", "d2": ""}, group,
+		config.Door{UacID: "d1"}, config.Door{UacID: "d2"})
+
+	states := make(map[string]string)
+	if err := syncGroupLocks(states); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.writes) != 1 || hub.writes[0] || states["all"] != "unlocked" {
+		t.Fatalf("group lock writes = %v, state %q, want it unlocked once", hub.writes, states["all"])
+	}
+	if set := fake.setRules(); len(set) != 0 {
+		t.Errorf("the echo of the group lock set the lock rules %q", set)
+	}
+
+	// the echo of a write that completed is ignored as well
+	g := &getAppConfig().DoorGroups[0]
+	groupLockWrites.expect(g.Hub(), g.HubitatLockID, "unlocked")
+	handleGroupEvent(g, "lock", "50", "unlocked")
+	if set := fake.setRules(); len(set) != 0 {
+		t.Errorf("the echo of the group lock set the lock rules %q", set)
+	}
+
+	// a change made on the hub is carried out on every member
+	handleGroupEvent(g, "lock", "50", "unlocked")
+	if set := fake.setRules(); len(set) != 1 || set[0] != "d2 keep_unlock" {
+		t.Errorf("unlocking the group lock set the lock rules %q, want d2 kept unlocked", set)
+	}
+}
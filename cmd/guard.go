@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+// failureLimiter counts failed authentication attempts per client IP and blocks
+// clients that fail too often.
+type failureLimiter struct {
+	mu      sync.Mutex
+	limit   config.AuthFailureLimit
+	clients map[string]*failureEntry
+}
+
+type failureEntry struct {
+	failures     int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+func newFailureLimiter(limit config.AuthFailureLimit) *failureLimiter {
+	return &failureLimiter{limit: limit, clients: make(map[string]*failureEntry)}
+}
+
+// blocked reports whether the client is currently blocked.
+func (l *failureLimiter) blocked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.clients[ip]
+	return ok && time.Now().Before(e.blockedUntil)
+}
+
+// fail records a failed attempt and reports whether the client is now blocked.
+func (l *failureLimiter) fail(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	e, ok := l.clients[ip]
+	if !ok || now.Sub(e.windowStart) > l.limit.Window {
+		e = &failureEntry{windowStart: now}
+		l.clients[ip] = e
+	}
+	e.failures++
+	if e.failures >= l.limit.MaxFailures {
+		e.blockedUntil = now.Add(l.limit.Block)
+		return true
+	}
+	return false
+}
+
+// prune forgets the clients that are neither blocked nor within a failure window.
+func (l *failureLimiter) prune(now time.Time) {
+	for ip, e := range l.clients {
+		if now.After(e.blockedUntil) && now.Sub(e.windowStart) > l.limit.Window {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// routeGuard restricts a route to allowed networks and, optionally, to clients with a
+// verified certificate. Every rejected or unauthorized request is audit logged and counted
+// towards blocking the client.
+type routeGuard struct {
+	route          string
+	allow          []*net.IPNet
+	requireCert    bool
+	trustedProxies []*net.IPNet
+	limiter        *failureLimiter
+	next           http.Handler
+}
+
+// newRouteGuard wraps next with the access restrictions of a route.
+func newRouteGuard(route string, access config.RouteAccess, server *config.Server, limiter *failureLimiter, next http.Handler) (*routeGuard, error) {
+	allow, err := parseCIDRs(access.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := parseCIDRs(server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &routeGuard{
+		route:          route,
+		allow:          allow,
+		requireCert:    access.RequireClientCert,
+		trustedProxies: trustedProxies,
+		limiter:        limiter,
+		next:           next,
+	}, nil
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		network, err := config.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// ServeHTTP implements http.Handler for routeGuard
+func (g *routeGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := g.clientIP(r)
+	httpRequests.Inc(g.route)
+
+	if g.limiter.blocked(ip) {
+		g.audit(r, ip, "blocked after repeated failures")
+		httpRejected.Inc(g.route, "blocked")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+	if len(g.allow) > 0 && !containsIP(g.allow, net.ParseIP(ip)) {
+		g.reject(w, r, ip, "ip_not_allowed", "client IP not allowed")
+		return
+	}
+	if g.requireCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		g.reject(w, r, ip, "client_cert_required", "client certificate required")
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	g.next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	switch {
+	case rec.status == http.StatusUnauthorized || rec.status == http.StatusForbidden:
+		g.failed(r, ip, "unauthorized", "authentication failed")
+	case rec.status >= 400 && rec.status < 500:
+		httpRejected.Inc(g.route, "bad_request")
+	}
+}
+
+// reject denies the request and records the failure.
+func (g *routeGuard) reject(w http.ResponseWriter, r *http.Request, ip, metricReason, reason string) {
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	g.failed(r, ip, metricReason, reason)
+}
+
+// failed records a failed attempt for the client, counts it under metricReason and audit logs it.
+func (g *routeGuard) failed(r *http.Request, ip, metricReason, reason string) {
+	g.audit(r, ip, reason)
+	httpRejected.Inc(g.route, metricReason)
+	if g.limiter.fail(ip) {
+		logger.Warn("Blocking client after repeated failures",
+			slog.String("audit", "auth_block"),
+			slog.String("route", g.route),
+			slog.String("remote_ip", ip))
+	}
+}
+
+func (g *routeGuard) audit(r *http.Request, ip, reason string) {
+	// the query string is left out as it may carry a token
+	logger.Warn("Rejected request",
+		slog.String("audit", "auth_failure"),
+		slog.String("route", g.route),
+		slog.String("reason", reason),
+		slog.String("remote_ip", ip),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("user_agent", r.UserAgent()))
+}
+
+// clientIP returns the IP of the client. Behind a trusted proxy it is the last
+// X-Forwarded-For address that is not itself a trusted proxy.
+func (g *routeGuard) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !containsIP(g.trustedProxies, net.ParseIP(host)) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		if !containsIP(g.trustedProxies, ip) {
+			return hop
+		}
+	}
+	return host
+}
+
+// clientIPKey is the request context key of the client IP determined by the route guard.
+type clientIPKey struct{}
+
+// requestClientIP returns the client IP of a request that passed a route guard.
+func requestClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
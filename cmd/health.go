@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+// readyCacheTTL is how long a readiness result is reused, so frequent probes don't load UAC and Hubitat.
+const readyCacheTTL = 5 * time.Second
+
+// uacWebhooks are the webhook routes of the UAC controllers by name.
+var uacWebhooks map[string]*uacWebhookRoute
+
+// checkResult is the outcome of a single readiness check.
+type checkResult struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// readiness runs the readiness checks and caches their result.
+type readiness struct {
+	mu      sync.Mutex
+	checked time.Time
+	results []checkResult
+}
+
+// check returns the results of the readiness checks and whether all of them passed.
+func (rd *readiness) check() ([]checkResult, bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if time.Since(rd.checked) > readyCacheTTL {
+		rd.results = runReadyChecks(getAppConfig())
+		rd.checked = time.Now()
+	}
+	for _, r := range rd.results {
+		if !r.OK {
+			return rd.results, false
+		}
+	}
+	return rd.results, true
+}
+
+// runReadyChecks checks that the UAC webhook is registered and that UAC and every configured
+// backend answer within the configured latency.
+func runReadyChecks(cfg *config.Config) []checkResult {
+	var unregistered []string
+	for _, name := range slices.Sorted(maps.Keys(uacWebhooks)) {
+		if !uacWebhooks[name].registered() {
+			unregistered = append(unregistered, uacUpstreamName(name))
+		}
+	}
+	results := []checkResult{{Name: "webhook", OK: len(unregistered) == 0}}
+	if !results[0].OK {
+		results[0].Error = "UAC webhook is not registered on " + strings.Join(unregistered, ", ")
+	}
+	if homeAssistantClient != nil {
+		events := checkResult{Name: "home_assistant_events", OK: homeAssistantClient.Connected()}
+		if !events.OK {
+			events.Error = "not subscribed to Home Assistant state changes"
+		}
+		results = append(results, events)
+	}
+	if mqttClient != nil {
+		broker := checkResult{Name: "mqtt", OK: mqttClient.Connected()}
+		if !broker.OK {
+			broker.Error = "not connected to the MQTT broker"
+		}
+		results = append(results, broker)
+	}
+
+	var checks []struct {
+		name string
+		fn   func() error
+	}
+	for _, name := range slices.Sorted(maps.Keys(uacClients)) {
+		checks = append(checks, struct {
+			name string
+			fn   func() error
+		}{uacUpstreamName(name), func() error {
+			_, err := uacClients[name].FetchAllDoors()
+			return err
+		}})
+	}
+	for _, name := range slices.Sorted(maps.Keys(backends)) {
+		var doors []config.Door
+		for _, d := range cfg.Doors {
+			if d.BackendInstance() == name {
+				doors = append(doors, d)
+			}
+		}
+		checks = append(checks, struct {
+			name string
+			fn   func() error
+		}{name, func() error { return backends[name].Ping(doors) }})
+	}
+
+	upstream := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upstream[i] = timedCheck(c.name, cfg.Server.ReadyMaxLatency, c.fn)
+		}()
+	}
+	wg.Wait()
+	return append(results, upstream...)
+}
+
+// timedCheck runs fn, failing the check when it errors or does not return within maxLatency.
+// A check that is too slow keeps running in the background until the client times out.
+func timedCheck(name string, maxLatency time.Duration, fn func() error) checkResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	result := checkResult{Name: name}
+	select {
+	case err := <-done:
+		result.LatencyMS = time.Since(start).Milliseconds()
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.OK = true
+		}
+	case <-time.After(maxLatency):
+		result.LatencyMS = maxLatency.Milliseconds()
+		result.Error = fmt.Sprintf("no answer within %s", maxLatency)
+	}
+	return result
+}
+
+// handleHealthz reports that the process is alive.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// readyzHandler reports whether the app can handle events.
+func readyzHandler(rd *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results, ready := rd.check()
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, struct {
+			Ready  bool          `json:"ready"`
+			Checks []checkResult `json:"checks"`
+		}{ready, results})
+	}
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// runHealth implements the "health" command, which checks a running instance and is meant
+// for container health checks. It exits with an error unless the instance is healthy.
+func runHealth(configPath string, args []string) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", configPath, "path of the config file")
+	ready := fs.Bool("ready", false, "check readiness instead of liveness")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Server == nil {
+		return errors.New("server: section is required")
+	}
+	host, port, err := net.SplitHostPort(cfg.Server.Listen)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	scheme, client := "http", &http.Client{Timeout: 10 * time.Second}
+	if cfg.Server.TLS != nil {
+		// the local instance is checked, not its certificate
+		scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	path := "/healthz"
+	if *ready {
+		path = "/readyz"
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, port), path))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
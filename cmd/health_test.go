@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+func TestUnregisteredWebhookIsUnhealthy(t *testing.T) {
+	setTestDoors(t)
+	saved := uacWebhooks
+	t.Cleanup(func() { uacWebhooks = saved })
+	reachable := &uacWebhookRoute{controller: config.DefaultUACController}
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	reachable.handler.Store(&h)
+	unreachable := &uacWebhookRoute{controller: "warehouse"}
+	uacWebhooks = map[string]*uacWebhookRoute{reachable.controller: reachable, unreachable.controller: unreachable}
+
+	for _, tt := range []struct {
+		route *uacWebhookRoute
+		want  int
+	}{
+		{reachable, http.StatusNoContent},
+		{unreachable, http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		tt.route.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, uacWebhookPath(tt.route.controller), nil))
+		if rec.Code != tt.want {
+			t.Errorf("webhook of %s answered %d, want %d", tt.route.controller, rec.Code, tt.want)
+		}
+	}
+
+	results := runReadyChecks(getAppConfig())
+	if webhook := results[0]; webhook.OK || webhook.Error != "UAC webhook is not registered on uac_warehouse" {
+		t.Errorf("webhook check = %+v, want only uac_warehouse failing", webhook)
+	}
+	unreachable.handler.Store(&h)
+	if webhook := runReadyChecks(getAppConfig())[0]; !webhook.OK {
+		t.Errorf("webhook check = %+v after registering, want it passing", webhook)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/homekit"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+const (
+	// homeKitEventBuffer is how many events may queue up while states are refreshed.
+	homeKitEventBuffer = 64
+	// homeKitRefreshDelay is how long after a command the door is queried for its new lock state.
+	homeKitRefreshDelay = 2 * time.Second
+)
+
+// homeKitServer is nil unless HomeKit is configured.
+var homeKitServer *homekit.Server
+
+// newHomeKitServer creates the bridge. Its identity and pairings are kept in the state store.
+func newHomeKitServer(cfg *config.HomeKit, store *state.Store) (*homekit.Server, error) {
+	var ips []net.IP
+	for _, ip := range cfg.AdvertiseIPs {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return homekit.NewServer(homekit.Options{
+		Name:         cfg.Name,
+		SetupCode:    cfg.SetupCode,
+		Addr:         cfg.Listen,
+		AdvertiseIPs: ips,
+		Storage:      store,
+		SetLock:      setHomeKitLock,
+	})
+}
+
+// setHomeKitLock carries out a change of the target lock state of a door from the Home app:
+// unlocking keeps the door unlocked until it is locked again.
+func setHomeKitLock(doorID string, locked bool) error {
+	door, found := getDoorByUacID(doorID)
+	if !found {
+		return fmt.Errorf("door %s is not configured", doorID)
+	}
+	action, command := "keep_unlock", uacFor(door).AssertUnlockDoor
+	if locked {
+		action, command = "lock", uacFor(door).AssertLockDoor
+	}
+	err := command(door.UacID)
+	logger.Info("HomeKit command",
+		slog.String("audit", "homekit_command"),
+		slog.String("action", action),
+		slog.String("door_id", door.UacID),
+		slog.Bool("ok", err == nil))
+	recordAudit(doorAudit(door, action, "homekit", "", "homekit lock target state"), err)
+	if err != nil {
+		recordDoorError(door, err)
+		return err
+	}
+	publishDoorEvent(door, "homekit", action, "", "")
+	return nil
+}
+
+// homeKitBridge keeps the accessories of the bridge in line with the configured doors and their states.
+type homeKitBridge struct {
+	server  *homekit.Server
+	refresh chan struct{}
+	// doors are the accessories last set, to notice config reloads.
+	doors []homekit.Door
+}
+
+// runHomeKitBridge serves HomeKit controllers and updates the door states until ctx is cancelled.
+func runHomeKitBridge(ctx context.Context, wg *sync.WaitGroup, server *homekit.Server, cfg *config.HomeKit) {
+	defer wg.Done()
+	b := &homeKitBridge{server: server, refresh: make(chan struct{}, 1)}
+
+	evts, cancel := eventBus.Subscribe(homeKitEventBuffer)
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(ctx); err != nil {
+			logger.Error("HomeKit bridge stopped", slog.String("err", err.Error()))
+		}
+	}()
+	logger.Info("HomeKit bridge started", slog.String("listen", cfg.Listen), slog.Bool("paired", server.Paired()))
+	if !server.Paired() {
+		// the setup code lets anyone pair the bridge, so it is only shown by the homekit command
+		logger.Info("HomeKit bridge is not paired, run the homekit command to show its setup code")
+	}
+
+	b.refreshStates()
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evts:
+			b.handleEvent(e)
+		case <-ticker.C:
+			b.refreshStates()
+		case <-b.refresh:
+			b.refreshStates()
+		}
+	}
+}
+
+// requestRefresh makes the bridge query the door states soon.
+func (b *homeKitBridge) requestRefresh() {
+	select {
+	case b.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// handleEvent updates the contact sensor right away, and the lock after commands and lock rule changes.
+func (b *homeKitBridge) handleEvent(e events.Event) {
+	if e.DoorID == "" {
+		return
+	}
+	switch e.Type {
+	case "position":
+		if open, known := contactState(e.Value); known {
+			b.server.SetContactState(e.DoorID, open == "open")
+		}
+	case "unlock", "lock", "keep_unlock", "timed_unlock", "command":
+		// the relay follows the command a moment later
+		time.AfterFunc(homeKitRefreshDelay, b.requestRefresh)
+	case "lock_rule":
+		b.requestRefresh()
+	}
+}
+
+// refreshStates updates the accessories after config reloads and sets the states queried from
+// UAC. A door is shown unlocked while its relay is unlocked or its lock rule keeps it unlocked.
+// The accessories are only changed when every controller answered, so names don't flip.
+func (b *homeKitBridge) refreshStates() {
+	uacDoors, err := fetchUacDoors()
+	if err != nil {
+		logger.Warn("Failed to fetch UAC doors for HomeKit", slog.String("err", err.Error()))
+		if len(uacDoors) == 0 {
+			return
+		}
+	}
+	names := make(map[string]string, len(uacDoors))
+	for _, d := range uacDoors {
+		names[d.ID] = d.Name
+	}
+
+	configured := getAppConfig().Doors
+	doors := make([]homekit.Door, 0, len(configured))
+	for _, door := range configured {
+		name := names[door.UacID]
+		if name == "" {
+			name = door.Name()
+		}
+		doors = append(doors, homekit.Door{ID: door.UacID, Name: name})
+	}
+	if err == nil && !slices.Equal(doors, b.doors) {
+		if err := b.server.SetDoors(doors); err != nil {
+			logger.Error("Failed to update HomeKit accessories", slog.String("err", err.Error()))
+			return
+		}
+		b.doors = doors
+	}
+
+	for _, d := range uacDoors {
+		door, found := getDoorByUacID(d.ID)
+		if !found {
+			continue
+		}
+		if open, known := contactState(d.DoorPositionStatus); known {
+			b.server.SetContactState(d.ID, open == "open")
+		}
+		locked := d.DoorLockRelayStatus != "unlock"
+		if locked {
+			rule, err := uacFor(door).GetDoorLockRule(d.ID)
+			if err != nil {
+				logger.Warn("Failed to get door lock rule for HomeKit", slog.String("door_id", d.ID), slog.String("err", err.Error()))
+				continue
+			}
+			if state, known := lockRuleState(rule.Type); known {
+				locked = state == "locked"
+			}
+		}
+		b.server.SetLockState(d.ID, locked)
+	}
+}
+
+// runHomeKitCommand prints the setup code and the setup QR code payload of the HomeKit bridge.
+// It only reads the state store, so it can run next to the server.
+func runHomeKitCommand(configPath string, args []string) error {
+	fs := flag.NewFlagSet("homekit", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", configPath, "path of the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+	if cfg.HomeKit == nil {
+		return errors.New("no homekit section is configured")
+	}
+	store, err := state.Open(filepath.Join(cfg.StateDir, stateFileName))
+	if err != nil {
+		return err
+	}
+	setup, err := homekit.ReadSetup(store, cfg.HomeKit.SetupCode)
+	if errors.Is(err, homekit.ErrNoIdentity) {
+		return fmt.Errorf("%w, start it once to create its setup code", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Setup code: %s\n", setup.Code)
+	fmt.Printf("Setup URI:  %s\n", setup.URI)
+	if setup.Paired {
+		fmt.Println("The bridge is already paired, share it from the Home app to add more people.")
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// prompter reads answers to interactive questions. When interactive is false
+// every question is answered with its default value.
+type prompter struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+}
+
+// ask prompts for a value, returning def when the answer is empty.
+func (p *prompter) ask(label, def string) string {
+	if !p.interactive {
+		return def
+	}
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", label)
+	}
+	line, _ := p.in.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+// confirm prompts for a yes/no answer, returning def when the answer is empty.
+func (p *prompter) confirm(label string, def bool) bool {
+	if !p.interactive {
+		return def
+	}
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	fmt.Fprintf(p.out, "%s [%s]: ", label, choices)
+	line, _ := p.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
+
+// runInit implements the "init" command. It discovers the UAC doors and Hubitat
+// devices, matches them by name and writes the resulting config file.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	output := fs.String("output", "config.yaml", "path of the config file to write")
+	force := fs.Bool("force", false, "overwrite the config file if it already exists")
+	nonInteractive := fs.Bool("non-interactive", false, "do not prompt, use flags and auto-matched devices only")
+	serverURL := fs.String("server-url", "", "URL where this app is accessible (server.base_url)")
+	uacURL := fs.String("uac-url", "", "UniFi Access base URL")
+	uacAPIKey := fs.String("uac-api-key", "", "UniFi Access API key")
+	hubitatURL := fs.String("hubitat-url", "", "Hubitat Maker API base URL (e.g. http://hub/apps/api/123)")
+	hubitatToken := fs.String("hubitat-token", "", "Hubitat Maker API access token")
+	uacPin := fs.String("uac-pin", "", "SHA-256 fingerprint to pin when the UniFi Access certificate is not trusted by the system")
+	hubitatPin := fs.String("hubitat-pin", "", "SHA-256 fingerprint to pin when the Hubitat certificate is not trusted by the system")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout, interactive: !*nonInteractive}
+
+	if _, err := os.Stat(*output); err == nil && !*force {
+		if !p.confirm(fmt.Sprintf("%s already exists, overwrite it?", *output), false) {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", *output)
+		}
+	}
+
+	tokens, err := generateTokens(3)
+	if err != nil {
+		return err
+	}
+
+	cfg := &config.Config{
+		Server: &config.Server{
+			BaseURL: p.ask("Server base URL (where UAC and Hubitat reach this app)", *serverURL),
+			Tokens: config.Tokens{
+				UAC:     tokens[0:1],
+				Hubitat: tokens[1:2],
+				Admin:   tokens[2:3],
+			},
+		},
+		UAC: &config.UAC{
+			BaseURL: p.ask("UniFi Access URL", *uacURL),
+			APIKey:  p.ask("UniFi Access API key", *uacAPIKey),
+		},
+		Hubitat: &config.Hubitat{
+			BaseURL:     strings.TrimSuffix(p.ask("Hubitat Maker API URL", *hubitatURL), "/"),
+			AccessToken: p.ask("Hubitat Maker API access token", *hubitatToken),
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("incomplete configuration:\n%w", err)
+	}
+
+	if cfg.UAC.TLS, err = trustCertificate(p, "UniFi Access", cfg.UAC.BaseURL, "uac-pin", *uacPin); err != nil {
+		return err
+	}
+	if cfg.Hubitat.TLS, err = trustCertificate(p, "Hubitat", cfg.Hubitat.BaseURL, "hubitat-pin", *hubitatPin); err != nil {
+		return err
+	}
+	clients, err := newUpstreamClients(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	uacDoors, err := clients.uac[config.DefaultUACController].FetchAllDoors()
+	if err != nil {
+		return fmt.Errorf("failed to fetch UAC doors: %w", err)
+	}
+	devices, err := clients.hubitat[config.DefaultHubitatHub].ListDevices()
+	if err != nil {
+		return fmt.Errorf("failed to fetch Hubitat devices: %w", err)
+	}
+	fmt.Fprintf(p.out, "Found %d UAC doors and %d Hubitat devices\n", len(uacDoors), len(devices))
+
+	used := make(map[string]bool)
+	for _, d := range uacDoors {
+		door, ok := matchDoor(p, d, devices, used)
+		if !ok {
+			continue
+		}
+		cfg.Doors = append(cfg.Doors, door)
+	}
+
+	if len(cfg.Doors) == 0 {
+		return errors.New("no doors were mapped, nothing to write")
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("generated configuration is invalid:\n%w", err)
+	}
+	if err := config.SaveConfig(*output, cfg); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	fmt.Fprintf(p.out, "Wrote %s with %d doors\n", *output, len(cfg.Doors))
+	fmt.Fprintf(p.out, "Set the Maker API \"URL to POST device events to\" to %s/webhook/hubitat?authorization=%s\n",
+		cfg.Server.BaseURL, cfg.Server.Tokens.Hubitat[0])
+	return nil
+}
+
+// trustCertificate checks the certificate of an HTTPS upstream. A certificate that is not signed
+// by a system CA, such as the self-signed one of UniFi Access, is shown and pinned once confirmed,
+// or when it matches expected, the fingerprint passed with the flag pinFlag. Without prompts it
+// is only pinned when it matches expected.
+func trustCertificate(p *prompter, name, baseURL, pinFlag, expected string) (*config.ClientTLS, error) {
+	var want []byte
+	if expected != "" {
+		fp, err := config.ParseFingerprint(expected)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", pinFlag, err)
+		}
+		want = fp
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme != "https" {
+		return nil, nil
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	// the certificate is verified below, after it has been shown
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr,
+		&tls.Config{InsecureSkipVerify: true, ServerName: u.Hostname()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", name, err)
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	conn.Close()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{DNSName: u.Hostname(), Intermediates: intermediates}); err == nil {
+		return nil, nil
+	}
+
+	pin := fingerprint(certs[0].RawSubjectPublicKeyInfo)
+	fmt.Fprintf(p.out, "\n%s presents a certificate that is not trusted by the system:\n", name)
+	fmt.Fprintf(p.out, "  subject:    %s\n  issuer:     %s\n  expires:    %s\n  pin_sha256: %s\n",
+		certs[0].Subject, certs[0].Issuer, certs[0].NotAfter.Format(time.DateOnly), pin)
+	switch {
+	case want != nil:
+		if !matchesPin(certs[0], [][]byte{want}) {
+			return nil, fmt.Errorf("the %s certificate does not match -%s", name, pinFlag)
+		}
+		return &config.ClientTLS{PinSHA256: []string{expected}}, nil
+	case !p.interactive:
+		return nil, fmt.Errorf("the %s certificate is not trusted, pass -%s with its pin_sha256 once checked or configure tls.ca_file", name, pinFlag)
+	case !p.confirm("Pin this certificate?", true):
+		return nil, fmt.Errorf("the %s certificate is not trusted, configure tls.ca_file instead", name)
+	}
+	return &config.ClientTLS{PinSHA256: []string{pin}}, nil
+}
+
+// matchDoor builds the door mapping for a UAC door, proposing the Hubitat devices
+// whose names match the door and letting the user confirm or override them.
+func matchDoor(p *prompter, d uac.Door, devices []hubitat.Device, used map[string]bool) (config.Door, bool) {
+	contact := bestDeviceMatch(d, devices, "ContactSensor", used)
+	lock := bestDeviceMatch(d, devices, "Lock", used)
+	sw := bestDeviceMatch(d, devices, "Switch", used)
+
+	fmt.Fprintf(p.out, "\nDoor %q (%s)\n", d.FullName, d.ID)
+	fmt.Fprintf(p.out, "  contact: %s\n  lock:    %s\n  switch:  %s\n",
+		describeDevice(contact), describeDevice(lock), describeDevice(sw))
+
+	if !p.interactive && (contact == nil || sw == nil) {
+		fmt.Fprintf(p.out, "  skipping, no matching contact and switch devices\n")
+		return config.Door{}, false
+	}
+	if !p.confirm("Include this door?", contact != nil && sw != nil) {
+		return config.Door{}, false
+	}
+
+	door := config.Door{
+		UacID:            d.ID,
+		HubitatContactID: p.ask("  Hubitat contact device ID", deviceID(contact)),
+		HubitatSwitchID:  p.ask("  Hubitat switch device ID", deviceID(sw)),
+	}
+	if lockID := p.ask("  Hubitat lock device ID (optional)", deviceID(lock)); lockID != "" {
+		door.HubitatLockID = &lockID
+	}
+
+	used[door.HubitatContactID] = true
+	used[door.HubitatSwitchID] = true
+	if door.HubitatLockID != nil {
+		used[*door.HubitatLockID] = true
+	}
+	return door, true
+}
+
+// bestDeviceMatch returns the unused device with the given capability whose name
+// best matches the UAC door name, or nil when no device matches.
+func bestDeviceMatch(d uac.Door, devices []hubitat.Device, capability string, used map[string]bool) *hubitat.Device {
+	doorNames := []string{normalizeName(d.Name), normalizeName(d.FullName)}
+
+	var candidates []hubitat.Device
+	for _, dev := range devices {
+		if used[dev.ID] || !dev.HasCapability(capability) {
+			continue
+		}
+		name := normalizeName(dev.DisplayName())
+		for _, doorName := range doorNames {
+			if doorName != "" && strings.Contains(name, doorName) {
+				candidates = append(candidates, dev)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// prefer virtual devices, then the closest (shortest) name
+	sort.SliceStable(candidates, func(i, j int) bool {
+		vi := strings.HasPrefix(candidates[i].Type, "Virtual")
+		vj := strings.HasPrefix(candidates[j].Type, "Virtual")
+		if vi != vj {
+			return vi
+		}
+		return len(candidates[i].DisplayName()) < len(candidates[j].DisplayName())
+	})
+	return &candidates[0]
+}
+
+// normalizeName lowercases a name and strips everything but letters and digits.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func describeDevice(dev *hubitat.Device) string {
+	if dev == nil {
+		return "(no match)"
+	}
+	return fmt.Sprintf("%s (%s, id %s)", dev.DisplayName(), dev.Type, dev.ID)
+}
+
+func deviceID(dev *hubitat.Device) string {
+	if dev == nil {
+		return ""
+	}
+	return dev.ID
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTrustCertificate(t *testing.T) {
+	leaf := newTestCert(t, "uac", false, nil)
+	srv := serveTLS(t, leaf)
+	keyPin := fingerprint(leaf.cert.RawSubjectPublicKeyInfo)
+	other := fingerprint(newTestCert(t, "other", false, nil).cert.RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name        string
+		interactive bool
+		answer      string
+		expected    string
+		pin         string
+		err         string
+	}{
+		// without prompts nothing is pinned unless the fingerprint was passed
+		{"non-interactive", false, "", "", "", "pass -uac-pin"},
+		{"non-interactive with the pin", false, "", keyPin, keyPin, ""},
+		{"non-interactive with the certificate pin", false, "", fingerprint(leaf.cert.Raw), fingerprint(leaf.cert.Raw), ""},
+		{"non-interactive with another pin", false, "", other, "", "does not match -uac-pin"},
+		{"invalid pin", false, "", "xyz", "", "-uac-pin"},
+		{"confirmed", true, "\n", "", keyPin, ""},
+		{"declined", true, "n\n", "", "", "configure tls.ca_file"},
+		{"interactive with another pin", true, "y\n", other, "", "does not match -uac-pin"},
+	}
+	for _, tt := range tests {
+		p := &prompter{in: bufio.NewReader(strings.NewReader(tt.answer)), out: io.Discard, interactive: tt.interactive}
+		opts, err := trustCertificate(p, "UniFi Access", srv.URL, "uac-pin", tt.expected)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: trustCertificate = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || opts == nil || len(opts.PinSHA256) != 1 || opts.PinSHA256[0] != tt.pin {
+			t.Errorf("%s: trustCertificate = %+v, %v, want the pin %s", tt.name, opts, err, tt.pin)
+		}
+	}
+}
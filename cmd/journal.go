@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/journal"
+)
+
+const (
+	// journalDirName is the directory of the journal in the state directory.
+	journalDirName = "journal"
+	// journalPruneInterval is how often the retention of the journal is applied.
+	journalPruneInterval = time.Hour
+	// defaultJournalLimit is the number of events returned as JSON unless a limit is given.
+	defaultJournalLimit = 1000
+)
+
+// eventJournal keeps the events on disk, it is nil when the journal is disabled.
+var eventJournal *journal.Journal
+
+// openJournal opens the journal in the state directory unless it is disabled.
+func openJournal(cfg *config.Config) (*journal.Journal, error) {
+	if cfg.Journal.Disabled {
+		return nil, nil
+	}
+	return journal.Open(filepath.Join(cfg.StateDir, journalDirName))
+}
+
+// journalEvent appends a published event to the journal.
+func journalEvent(e events.Event) {
+	if eventJournal == nil {
+		return
+	}
+	if err := eventJournal.Append(e); err != nil {
+		logger.Error("Failed to write event to journal", slog.Uint64("event_id", e.ID), slog.String("err", err.Error()))
+	}
+}
+
+// pruneJournal applies the retention of the journal until the context is cancelled.
+func pruneJournal(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	prune := func() {
+		retention := getAppConfig().Journal
+		if err := eventJournal.Prune(retention.MaxAge, int64(retention.MaxSizeMB)<<20); err != nil {
+			logger.Error("Failed to prune journal", slog.String("err", err.Error()))
+		}
+	}
+	prune()
+
+	ticker := time.NewTicker(journalPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prune()
+		}
+	}
+}
+
+// parseJournalQuery reads the door, kind, source, actor, since and until query parameters.
+// Times are RFC 3339 or dates, which are midnight in the local time zone.
+func parseJournalQuery(r *http.Request) (journal.Query, error) {
+	params := r.URL.Query()
+	q := journal.Query{
+		Kinds:   splitList(params["kind"]),
+		Sources: splitList(params["source"]),
+		Actor:   params.Get("actor"),
+	}
+	for _, ref := range splitList(params["door"]) {
+		door, found := findDoor(ref)
+		if !found {
+			return q, fmt.Errorf("door %q not found", ref)
+		}
+		q.DoorIDs = append(q.DoorIDs, door.UacID)
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		v := params.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation(time.DateOnly, v, time.Local)
+		}
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 time or a date", param.name)
+		}
+		*param.t = t
+	}
+	return q, nil
+}
+
+// handleQueryJournal returns the journaled events matching the query, oldest first. With
+// ?format=csv or ?format=jsonl every matching event is exported, otherwise the last limit
+// events are returned as JSON.
+func handleQueryJournal(w http.ResponseWriter, r *http.Request) {
+	if eventJournal == nil {
+		writeError(w, http.StatusNotFound, "the journal is disabled")
+		return
+	}
+	q, err := parseJournalQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format == "csv" || format == "jsonl" {
+		// large exports may take longer than the server write timeout
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "time", "source", "type", "door_id", "door", "value", "actor"})
+		err = eventJournal.Query(q, func(e events.Event) bool {
+			return cw.Write([]string{strconv.FormatUint(e.ID, 10), e.Time.Format(time.RFC3339Nano),
+				e.Source, e.Type, e.DoorID, e.Door, e.Value, e.Actor}) == nil
+		})
+		cw.Flush()
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.jsonl"`)
+		enc := json.NewEncoder(w)
+		err = eventJournal.Query(q, func(e events.Event) bool {
+			return enc.Encode(e) == nil
+		})
+	case "", "json":
+		limit := defaultJournalLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeError(w, http.StatusBadRequest, "limit must be a positive number")
+				return
+			}
+			limit = n
+		}
+		var found []events.Event
+		err = eventJournal.Query(q, func(e events.Event) bool {
+			found = append(found, e)
+			if len(found) > limit {
+				found = found[1:]
+			}
+			return true
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Events []events.Event `json:"events"`
+		}{found})
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json, jsonl or csv")
+		return
+	}
+	if err != nil {
+		// the export has started, the client sees a cut short response
+		logger.Error("Failed to export journal", slog.String("err", err.Error()))
+	}
+}
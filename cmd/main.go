@@ -2,50 +2,67 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
-	"time"
 
 	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/homeassistant"
 	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
 	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
 )
 
 var (
-	logger        *slog.Logger
-	uacClient     *uac.Client
-	hubitatClient *hubitat.Client
-	appConfig     *config.Config
+	logger *slog.Logger
+	// uacClients are the clients of the UAC controllers by name.
+	uacClients map[string]*uac.Client
+	// hubitatClients are the clients of the Hubitat hubs by name.
+	hubitatClients map[string]*hubitat.Client
+	stateStore     *state.Store
+	// homeAssistantClient is nil unless Home Assistant is configured.
+	homeAssistantClient *homeassistant.Client
 )
 
 // getDoorByUacID returns the Door struct for a given UAC door ID.
 func getDoorByUacID(uacID string) (door *config.Door, found bool) {
-	for i, d := range appConfig.Doors {
-		if d.UacID == uacID {
-			return &appConfig.Doors[i], true
-		}
-	}
-	return nil, false
+	door, found = appState.Load().doors.byUacID[uacID]
+	return door, found
 }
 
-// getDoorByHubitatID returns the Door struct and device type ("contact", "lock", or "switch") for a given Hubitat device ID.
-func getDoorByHubitatID(hubitatID string) (door *config.Door, deviceType string, found bool) {
-	for i, d := range appConfig.Doors {
-		if d.HubitatContactID == hubitatID {
-			return &appConfig.Doors[i], "contact", true
-		}
-		if d.HubitatLockID != nil && *d.HubitatLockID == hubitatID {
-			return &appConfig.Doors[i], "lock", true
-		}
-		if d.HubitatSwitchID == hubitatID {
-			return &appConfig.Doors[i], "switch", true
+// uacFor returns the client of the UAC controller of a door.
+func uacFor(door *config.Door) *uac.Client {
+	return uacClients[door.Controller()]
+}
+
+// fetchUacDoors fetches the doors of every UAC controller. The doors of the controllers that
+// answered are returned along with the errors of the others.
+func fetchUacDoors() ([]uac.Door, error) {
+	var doors []uac.Door
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(uacClients)) {
+		d, err := uacClients[name].FetchAllDoors()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch UAC doors of %s: %w", uacUpstreamName(name), err))
+			continue
 		}
+		doors = append(doors, d...)
 	}
-	return nil, "", false
+	return doors, errors.Join(errs...)
+}
+
+// getDoorByDevice returns the Door struct and device type ("contact", "lock", or "switch") for a device ID of a
+// backend instance, see config.Door.BackendInstance.
+func getDoorByDevice(backend, deviceID string) (door *config.Door, deviceType string, found bool) {
+	device, found := appState.Load().doors.byDevice[deviceKey{backend, deviceID}]
+	return device.door, device.deviceType, found
 }
 
 func main() {
@@ -65,77 +82,341 @@ func main() {
 		}
 	}
 
+	// run a sub command instead of the server when one is given
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			if err := runInit(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "init failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "token":
+			if err := runToken(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "token failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "health":
+			if err := runHealth(configPath, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "unhealthy:", err)
+				os.Exit(1)
+			}
+			return
+		case "verify":
+			if err := runVerify(configPath, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "verify failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "schedules":
+			if err := runSchedulesCommand(configPath, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "schedules failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "homekit":
+			if err := runHomeKitCommand(configPath, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "homekit failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "validate":
+			if err := runValidate(configPath); err != nil {
+				fmt.Fprintln(os.Stderr, "config is invalid:")
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// load config
-	appConfig, err = config.LoadConfig(configPath)
+	appConfig, err := config.LoadConfig(configPath)
 	if err != nil {
 		logger.Error("Error loading config", slog.String("ConfigPath", configPath),
 			slog.String("err", err.Error()))
 		os.Exit(1)
 	}
+	if err := appConfig.Validate(); err != nil {
+		logConfigErrors("Invalid config", err)
+		os.Exit(1)
+	}
+
+	stateStore, err = openStateStore(appConfig)
+	if err != nil {
+		logger.Error("Error opening state store", slog.String("StateDir", appConfig.StateDir),
+			slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	auditLog, err = openAuditLog(appConfig, stateStore)
+	if err != nil {
+		logger.Error("Error opening audit log", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
 
-	uacClient = uac.NewClient(appConfig.UAC.BaseURL, appConfig.UAC.APIKey)
-	hubitatClient = hubitat.NewClient(appConfig.Hubitat.BaseURL, appConfig.Hubitat.AccessToken)
+	eventJournal, err = openJournal(appConfig)
+	if err != nil {
+		logger.Error("Error opening journal", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	if eventJournal != nil {
+		// continue the event IDs of the journal so they are unique across restarts
+		lastID, err := eventJournal.LastID()
+		if err != nil {
+			logger.Error("Error reading journal", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+		eventBus.ResumeAfter(lastID)
+	}
 
-	// asset that uac webhook exists
-	uacWebHook, err := assertUacWebhookExists()
+	clients, err := newUpstreamClients(appConfig, stateStore)
 	if err != nil {
-		logger.Error("Error asserting webhook exists", slog.String("err", err.Error()))
+		logger.Error("Error creating clients", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	uacClients, hubitatClients, homeAssistantClient = clients.uac, clients.hubitat, clients.homeAssistant
+	for name, client := range uacClients {
+		client.SetRequestObserver(observeUpstream(uacUpstreamName(name)))
+	}
+	for name, client := range hubitatClients {
+		client.SetRequestObserver(observeUpstream(config.HubitatInstance(name)))
+	}
+	if homeAssistantClient != nil {
+		homeAssistantClient.SetRequestObserver(observeUpstream("home_assistant"))
+	}
+	backends = newBackends(clients)
+	if appConfig.MQTT != nil {
+		if mqttClient, err = newMQTTClient(appConfig.MQTT, stateStore); err != nil {
+			logger.Error("Error creating MQTT client", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}
+	if outboundTargets, err = newOutboundTargets(appConfig.OutboundWebhooks, stateStore); err != nil {
+		logger.Error("Error creating outbound webhooks", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	if appConfig.Notifications != nil {
+		if notifiers, err = newNotifiers(appConfig.Notifications, stateStore); err != nil {
+			logger.Error("Error creating notification channels", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}
+	if appConfig.HomeKit != nil {
+		if homeKitServer, err = newHomeKitServer(appConfig.HomeKit, stateStore); err != nil {
+			logger.Error("Error creating HomeKit bridge", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	// verify the configured doors and devices exist before accepting events for them
+	if err := prepareConfig(appConfig); err != nil {
+		logger.Error("Error validating config", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
+	setAppConfig(appConfig)
 
 	wg := sync.WaitGroup{}
 
+	// assert that the webhook exists on every uac controller, the unreachable ones are retried
+	// in the background while the others are served
+	uacWebhooks = make(map[string]*uacWebhookRoute, len(uacClients))
+	var unreachableWebhooks []*uacWebhookRoute
+	for name := range uacClients {
+		route := &uacWebhookRoute{controller: name}
+		uacWebhooks[name] = route
+		if err := route.register(&wg); err != nil {
+			logger.Error("Error asserting webhook exists, the controller is unhealthy until it is reachable",
+				slog.String("controller", name), slog.String("err", explainCertificateError(uacSection(name), err).Error()))
+			unreachableWebhooks = append(unreachableWebhooks, route)
+		}
+	}
+
 	// Register the signal handler for graceful shutdown
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
 
-	HServer := &http.Server{Addr: "0.0.0.0:9423"}
+	// Register the signal handler for config reloads
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
 
-	// runs webserver in a goroutine for graceful shutdown
-	go func(HServer *http.Server, wg *sync.WaitGroup) {
-		// Create handlers
-		uacHandler := uac.NewWebhookHandler(*uacWebHook.Secret, appConfig.Server.AuthToken, handleUacEvent, wg)
-		hubitatHandler := hubitat.NewWebhookHandler(appConfig.Server.AuthToken, handleHubitatEvent, wg)
+	// Create handlers
+	uacHandler := http.NewServeMux()
+	for name, route := range uacWebhooks {
+		uacHandler.Handle(uacWebhookPath(name), route)
+	}
+	hubitatHandler := http.NewServeMux()
+	for name := range hubitatClients {
+		onEvent := func(evt hubitat.WebhookEvent) { handleHubitatEvent(name, evt) }
+		hubitatHandler.Handle(hubitatWebhookPath(name), hubitat.NewWebhookHandler(authorizer((*config.Server).HubitatTokens), onEvent, &wg))
+	}
+
+	// Restrict who may call each route
+	limiter := newFailureLimiter(appConfig.Server.AuthFailures)
+	uacGuard, err := newRouteGuard("uac", appConfig.Server.Routes.UAC, appConfig.Server, limiter, uacHandler)
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	hubitatGuard, err := newRouteGuard("hubitat", appConfig.Server.Routes.Hubitat, appConfig.Server, limiter, hubitatHandler)
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	metricsGuard, err := newRouteGuard("metrics", appConfig.Server.Routes.Metrics, appConfig.Server, limiter,
+		requireToken(metricsRegistry, "monitoring", (*config.Server).MonitoringTokens, bearerToken))
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc("/healthz", handleHealthz)
+	healthMux.Handle("/readyz", readyzHandler(&readiness{}))
+	healthGuard, err := newRouteGuard("health", appConfig.Server.Routes.Health, appConfig.Server, limiter, healthMux)
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	statusGuard, err := newRouteGuard("status", appConfig.Server.Routes.Status, appConfig.Server, limiter,
+		requireToken(http.HandlerFunc(handleStatus), "monitoring", (*config.Server).MonitoringTokens, bearerToken))
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	adminGuard, err := newRouteGuard("admin", appConfig.Server.Routes.Admin, appConfig.Server, limiter, newAdminAPI())
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	dashboardGuard, err := newRouteGuard("dashboard", appConfig.Server.Routes.Dashboard, appConfig.Server, limiter, newDashboard())
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	eventsGuard, err := newRouteGuard("events", appConfig.Server.Routes.Events, appConfig.Server, limiter, newEventStream())
+	if err != nil {
+		logger.Error("Failed to create route guard", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
 
-		// Register the routes
-		http.Handle("/webhook/uac", uacHandler)
-		http.Handle("/webhook/hubitat", hubitatHandler)
+	// Register the routes
+	mux := http.NewServeMux()
+	mux.Handle("/webhook/uac", uacGuard)
+	mux.Handle("/webhook/uac/", uacGuard)
+	mux.Handle("/webhook/hubitat", hubitatGuard)
+	mux.Handle("/webhook/hubitat/", hubitatGuard)
+	mux.Handle("/metrics", metricsGuard)
+	mux.Handle("/healthz", healthGuard)
+	mux.Handle("/readyz", healthGuard)
+	mux.Handle("/status", statusGuard)
+	mux.Handle("/api/", adminGuard)
+	mux.Handle("/dashboard/", dashboardGuard)
+	mux.Handle("/events/stream", eventsGuard)
+	mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
 
-		// Start the HTTP server
-		logger.Info("Starting Server")
-		if err := http.ListenAndServe("0.0.0.0:9423", nil); err != nil {
-			logger.Error("Failed to start server", slog.String("err", err.Error()))
-			os.Exit(1)
-		}
-	}(HServer, &wg)
+	// Start the HTTP server
+	server, err := newHTTPServer(appConfig.Server, mux)
+	if err != nil {
+		logger.Error("Failed to create server", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	server.RegisterOnShutdown(closeEventStreams)
+	if err := startHTTPServer(server); err != nil {
+		logger.Error("Failed to start server", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
 
 	// Create a cancellable context for the polling goroutine
 	ctx, cancelPoll := context.WithCancel(context.Background())
 
-	// Start the polling goroutine to check UAC states
-	wg.Add(1)
-	go pollUacStates(ctx, &wg)
+	// Register the webhook on the controllers that were unreachable
+	for _, route := range unreachableWebhooks {
+		wg.Add(1)
+		go retryUacWebhook(ctx, &wg, route, configPath)
+	}
 
-	// Wait for a signal to shutdown
-	sig := <-osSignals
-	logger.Warn("Received shutdown signal", slog.String("signal", sig.String()))
+	// Start a polling goroutine per UAC controller to check its states
+	for name := range uacClients {
+		wg.Add(1)
+		go pollUacStates(ctx, &wg, name)
+	}
 
-	// Cancel the polling goroutine
-	cancelPoll()
+	// Collect the door status served to the dashboard and /status
+	wg.Add(1)
+	go pollStatus(ctx, &wg)
 
-	ctxShutDown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Keep the door group locks in line with their members, groups may be added on reload
+	wg.Add(1)
+	go pollGroupLocks(ctx, &wg)
 
+	// Apply the schedules, which may also be added on reload
 	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := HServer.Shutdown(ctxShutDown); err != nil {
-			logger.Error("Failed to shutdown server gracefully", slog.String("err", err.Error()))
-		} else {
-			logger.Info("Server shutdown gracefully")
+	go runSchedules(ctx, &wg)
+
+	// Start watching the config file for modifications
+	wg.Add(1)
+	go watchConfig(ctx, &wg, configPath)
+
+	// Publish the door states to MQTT and accept commands from it
+	if mqttClient != nil {
+		wg.Add(1)
+		go runMQTTBridge(ctx, &wg, mqttClient, appConfig.MQTT)
+	}
+
+	// Deliver the door events to the outbound webhooks
+	if len(outboundTargets) > 0 {
+		wg.Add(1)
+		go runOutboundWebhooks(ctx, &wg, outboundTargets)
+	}
+
+	// Send notifications for door alerts, and check the upstreams when they are wanted
+	if len(notifiers) > 0 {
+		wg.Add(1)
+		go runNotifications(ctx, &wg, notifiers)
+		if notifiersWant(notifiers, config.TriggerUpstreamDown) {
+			wg.Add(1)
+			go monitorUpstreams(ctx, &wg, appConfig.Notifications.UpstreamCheckInterval)
 		}
-	}()
+	}
+
+	// Expose the doors to Apple Home
+	if homeKitServer != nil {
+		wg.Add(1)
+		go runHomeKitBridge(ctx, &wg, homeKitServer, appConfig.HomeKit)
+	}
+
+	// Follow the state changes of the Home Assistant entities
+	if homeAssistantClient != nil {
+		wg.Add(1)
+		go listenHomeAssistant(ctx, &wg, homeAssistantClient)
+	}
+
+	// Apply the journal retention
+	if eventJournal != nil {
+		wg.Add(1)
+		go pruneJournal(ctx, &wg)
+	}
 
-	wg.Wait()
+	// Reload the config on SIGHUP until a signal to shutdown is received
+	var sig os.Signal
+	for sig == nil {
+		select {
+		case <-reloadSignals:
+			_ = reloadConfig(configPath, "signal")
+		case sig = <-osSignals:
+		}
+	}
+	logger.Warn("Received shutdown signal", slog.String("signal", sig.String()))
+
+	// Cancel the polling goroutine
+	cancelPoll()
+
+	// Stop the server, then wait for the queued events and the polling goroutine
+	shutdownHTTPServer(server, &wg, appConfig.Server.ShutdownTimeout)
+	if eventJournal != nil {
+		_ = eventJournal.Close()
+	}
+	_ = auditLog.Close()
 	logger.Info("Exiting application")
 }
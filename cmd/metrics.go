@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/metrics"
+)
+
+// metricsRegistry holds the metrics served on /metrics.
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	httpRequests = metricsRegistry.NewCounter("uahm_http_requests_total",
+		"Requests received by route. The uac and hubitat routes are the webhooks.", "route")
+	httpRejected = metricsRegistry.NewCounter("uahm_http_requests_rejected_total",
+		"Requests rejected by route and reason.", "route", "reason")
+	webhookDelay = metricsRegistry.NewHistogram("uahm_webhook_delivery_delay_seconds",
+		"Delay between UniFi Access signing a webhook and the webhook being handled.",
+		[]float64{.5, 1, 2, 5, 10, 30, 60, 300})
+
+	upstreamRequests = metricsRegistry.NewCounter("uahm_upstream_requests_total",
+		"UAC and Hubitat API requests by endpoint and status, status is 0 when no response was received.",
+		"upstream", "method", "endpoint", "status")
+	upstreamLatency = metricsRegistry.NewHistogram("uahm_upstream_request_duration_seconds",
+		"UAC and Hubitat API request latency.", metrics.DefBuckets, "upstream", "method", "endpoint")
+
+	pollErrors = metricsRegistry.NewCounter("uahm_poll_errors_total",
+		"Errors while polling UAC and syncing door states to Hubitat.")
+
+	doorOpen = metricsRegistry.NewGauge("uahm_door_open",
+		"Door position reported by UAC, 1 when open.", "door", "uac_id")
+	doorLocked = metricsRegistry.NewGauge("uahm_door_locked",
+		"Door lock rule mirrored to Hubitat, 1 when locked and 0 when kept unlocked.", "door", "uac_id")
+)
+
+func init() {
+	metricsRegistry.NewCounterFunc("uahm_config_reloads_succeeded_total", "Successful config reloads.",
+		func() float64 { return float64(reloadStats.success.Load()) })
+	metricsRegistry.NewCounterFunc("uahm_config_reloads_failed_total", "Failed config reloads.",
+		func() float64 { return float64(reloadStats.failure.Load()) })
+	metricsRegistry.NewGaugeFunc("uahm_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful config reload, 0 when there was none.",
+		func() float64 { return float64(reloadStats.lastSuccess.Load()) })
+}
+
+// observeUpstream returns a request observer recording the API requests to upstream.
+func observeUpstream(upstream string) func(method, endpoint string, status int, duration time.Duration) {
+	return func(method, endpoint string, status int, duration time.Duration) {
+		upstreamRequests.Inc(upstream, method, endpoint, strconv.Itoa(status))
+		upstreamLatency.Observe(duration.Seconds(), upstream, method, endpoint)
+	}
+}
+
+// setDoorGauge sets a per-door gauge to 1 when on and 0 otherwise.
+func setDoorGauge(g *metrics.Gauge, door *config.Door, on bool) {
+	value := 0.0
+	if on {
+		value = 1
+	}
+	g.Set(value, door.Name(), door.UacID)
+}
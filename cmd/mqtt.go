@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/mqtt"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+const (
+	// mqttEventBuffer is how many events may queue up while states are published.
+	mqttEventBuffer = 64
+	// mqttRefreshDelay is how long after a command the door is queried for its new relay state.
+	mqttRefreshDelay = 2 * time.Second
+)
+
+// mqttCommands are the payloads accepted on the command topic of a door, with the UAC call carrying them out.
+var mqttCommands = map[string]func(door *config.Door) error{
+	"unlock":      func(door *config.Door) error { return uacFor(door).AssertToggleDoorUnlock(door.UacID) },
+	"lock":        func(door *config.Door) error { return uacFor(door).AssertLockDoor(door.UacID) },
+	"keep_unlock": func(door *config.Door) error { return uacFor(door).AssertUnlockDoor(door.UacID) },
+}
+
+// mqttClient is nil unless MQTT is configured.
+var mqttClient *mqtt.Client
+
+// newMQTTClient creates the client of the configured broker. The will marks the bridge offline.
+func newMQTTClient(cfg *config.MQTT, store *state.Store) (*mqtt.Client, error) {
+	tlsConfig, err := clientTLSConfig("mqtt", cfg.TLS, store)
+	if err != nil {
+		return nil, err
+	}
+	return mqtt.NewClient(mqtt.Options{
+		Broker:    cfg.Broker,
+		ClientID:  cfg.ClientID,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		TLSConfig: tlsConfig,
+		Will:      &mqtt.Message{Topic: cfg.TopicPrefix + "/status", Payload: []byte("offline"), Retain: true},
+	}), nil
+}
+
+// mqttBridge publishes the state of every door as retained topics, announces the doors to
+// Home Assistant and carries out the commands received for them.
+type mqttBridge struct {
+	client  *mqtt.Client
+	cfg     *config.MQTT
+	wg      *sync.WaitGroup
+	refresh chan struct{}
+
+	mu sync.Mutex
+	// published is the last payload of each state topic, only changes are published.
+	published map[string]string
+	// announced are the UAC IDs of the doors whose discovery config was published.
+	announced map[string]bool
+}
+
+// runMQTTBridge connects to the broker and keeps the door topics up to date until ctx is cancelled.
+func runMQTTBridge(ctx context.Context, wg *sync.WaitGroup, client *mqtt.Client, cfg *config.MQTT) {
+	defer wg.Done()
+	b := &mqttBridge{
+		client:    client,
+		cfg:       cfg,
+		wg:        wg,
+		refresh:   make(chan struct{}, 1),
+		published: make(map[string]string),
+		announced: make(map[string]bool),
+	}
+
+	evts, cancel := eventBus.Subscribe(mqttEventBuffer)
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.Run(ctx, b.onConnect, b.onMessage)
+	}()
+
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evts:
+			b.handleEvent(e)
+		case <-ticker.C:
+			b.publishStates()
+		case <-b.refresh:
+			b.publishStates()
+		}
+	}
+}
+
+// onConnect marks the bridge online, subscribes to the commands and republishes everything,
+// as the broker may have lost the retained messages.
+func (b *mqttBridge) onConnect() {
+	logger.Info("Connected to MQTT broker", slog.String("broker", b.cfg.Broker))
+	b.mu.Lock()
+	clear(b.published)
+	clear(b.announced)
+	b.mu.Unlock()
+
+	if err := b.client.Publish(b.cfg.TopicPrefix+"/status", []byte("online"), true); err != nil {
+		logger.Error("Failed to publish MQTT status", slog.String("err", err.Error()))
+	}
+	if !b.cfg.DisableCommands {
+		if err := b.client.Subscribe(b.cfg.TopicPrefix + "/doors/+/set"); err != nil {
+			logger.Error("Failed to subscribe to MQTT commands", slog.String("err", err.Error()))
+		}
+	}
+	b.requestRefresh()
+}
+
+// requestRefresh makes the bridge query and publish the door states soon.
+func (b *mqttBridge) requestRefresh() {
+	select {
+	case b.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// doorTopic returns the topic of a door state, e.g. uahm/doors/<uac_id>/position.
+func (b *mqttBridge) doorTopic(doorID, name string) string {
+	return b.cfg.TopicPrefix + "/doors/" + doorID + "/" + name
+}
+
+// publishState publishes a retained door state unless it is unchanged.
+func (b *mqttBridge) publishState(doorID, name, value string) {
+	topic := b.doorTopic(doorID, name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if last, ok := b.published[topic]; ok && last == value {
+		return
+	}
+	if err := b.client.Publish(topic, []byte(value), true); err != nil {
+		logger.Warn("Failed to publish MQTT state", slog.String("topic", topic), slog.String("err", err.Error()))
+		return
+	}
+	b.published[topic] = value
+}
+
+// handleEvent publishes the states an event changes right away.
+func (b *mqttBridge) handleEvent(e events.Event) {
+	if e.DoorID == "" {
+		return
+	}
+	switch e.Type {
+	case "position":
+		b.publishState(e.DoorID, "position", e.Value)
+	case "unlock", "lock", "keep_unlock", "timed_unlock", "command":
+		actor := e.Actor
+		if actor == "" {
+			actor = e.Source
+		}
+		b.publishState(e.DoorID, "last_actor", actor)
+		// the relay follows the command a moment later
+		time.AfterFunc(mqttRefreshDelay, b.requestRefresh)
+	case "lock_rule":
+		b.requestRefresh()
+	}
+}
+
+// publishStates announces new doors, withdraws removed ones and publishes the position, relay
+// and lock rule of every door as queried from UAC.
+func (b *mqttBridge) publishStates() {
+	if !b.client.Connected() {
+		return
+	}
+	doors := getAppConfig().Doors
+	if !b.cfg.DisableDiscovery {
+		b.announceDoors(doors)
+	}
+
+	uacDoors, err := fetchUacDoors()
+	if err != nil {
+		logger.Warn("Failed to fetch UAC doors for MQTT", slog.String("err", err.Error()))
+	}
+	for _, d := range uacDoors {
+		if _, found := getDoorByUacID(d.ID); !found {
+			continue
+		}
+		b.publishState(d.ID, "position", d.DoorPositionStatus)
+		b.publishState(d.ID, "relay", d.DoorLockRelayStatus)
+	}
+	for _, door := range doors {
+		rule, err := uacFor(&door).GetDoorLockRule(door.UacID)
+		if err != nil {
+			logger.Warn("Failed to get door lock rule for MQTT", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+			continue
+		}
+		ruleType := rule.Type
+		if ruleType == "" {
+			ruleType = "none"
+		}
+		b.publishState(door.UacID, "lock_rule", ruleType)
+	}
+}
+
+// discoveryEntity is a Home Assistant MQTT discovery config, published retained to
+// <discovery_prefix>/<component>/uahm_<uac_id>/<object>/config.
+type discoveryEntity struct {
+	component, object string
+	config            map[string]any
+}
+
+// discoveryEntities returns the entities of a door: the door position, the lock (a binary
+// sensor of the relay when commands are disabled), the lock rule and the last actor.
+func (b *mqttBridge) discoveryEntities(door *config.Door) []discoveryEntity {
+	id := door.UacID
+	device := map[string]any{
+		"identifiers":  []string{"uahm_" + id},
+		"name":         door.Name(),
+		"manufacturer": "Ubiquiti",
+		"model":        "UniFi Access door",
+	}
+	entity := func(object, name string, extra map[string]any) map[string]any {
+		c := map[string]any{
+			"name":               name,
+			"unique_id":          "uahm_" + id + "_" + object,
+			"availability_topic": b.cfg.TopicPrefix + "/status",
+			"device":             device,
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	lock := discoveryEntity{"lock", "lock", entity("lock", "Lock", map[string]any{
+		"state_topic":    b.doorTopic(id, "relay"),
+		"state_locked":   "lock",
+		"state_unlocked": "unlock",
+		"command_topic":  b.doorTopic(id, "set"),
+		"payload_lock":   "lock",
+		"payload_unlock": "keep_unlock",
+		"payload_open":   "unlock",
+	})}
+	if b.cfg.DisableCommands {
+		lock = discoveryEntity{"binary_sensor", "lock", entity("lock", "Lock", map[string]any{
+			"state_topic":  b.doorTopic(id, "relay"),
+			"device_class": "lock",
+			"payload_on":   "unlock",
+			"payload_off":  "lock",
+		})}
+	}
+	return []discoveryEntity{
+		{"binary_sensor", "position", entity("position", "Door", map[string]any{
+			"state_topic":  b.doorTopic(id, "position"),
+			"device_class": "door",
+			"payload_on":   "open",
+			"payload_off":  "close",
+		})},
+		lock,
+		{"sensor", "lock_rule", entity("lock_rule", "Lock rule", map[string]any{
+			"state_topic": b.doorTopic(id, "lock_rule"),
+			"icon":        "mdi:lock-clock",
+		})},
+		{"sensor", "last_actor", entity("last_actor", "Last actor", map[string]any{
+			"state_topic": b.doorTopic(id, "last_actor"),
+			"icon":        "mdi:account",
+		})},
+	}
+}
+
+// discoveryTopic returns the topic of a discovery config.
+func (b *mqttBridge) discoveryTopic(doorID string, e discoveryEntity) string {
+	return b.cfg.DiscoveryPrefix + "/" + e.component + "/uahm_" + doorID + "/" + e.object + "/config"
+}
+
+// announceDoors publishes the discovery configs of the doors not announced yet, and removes
+// the entities of the doors no longer configured.
+func (b *mqttBridge) announceDoors(doors []config.Door) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := make(map[string]bool, len(doors))
+	for i := range doors {
+		door := &doors[i]
+		current[door.UacID] = true
+		if b.announced[door.UacID] {
+			continue
+		}
+		ok := true
+		for _, e := range b.discoveryEntities(door) {
+			payload, err := json.Marshal(e.config)
+			if err == nil {
+				err = b.client.Publish(b.discoveryTopic(door.UacID, e), payload, true)
+			}
+			if err != nil {
+				logger.Warn("Failed to publish MQTT discovery config", slog.String("door_id", door.UacID), slog.String("err", err.Error()))
+				ok = false
+			}
+		}
+		b.announced[door.UacID] = ok
+	}
+
+	for id := range b.announced {
+		if current[id] {
+			continue
+		}
+		// an empty retained config removes the entity from Home Assistant
+		for _, e := range b.discoveryEntities(&config.Door{UacID: id}) {
+			b.client.Publish(b.discoveryTopic(id, e), nil, true)
+		}
+		delete(b.announced, id)
+	}
+}
+
+// onMessage carries out a command received on uahm/doors/<uac_id>/set.
+func (b *mqttBridge) onMessage(msg mqtt.Message) {
+	ref, ok := strings.CutPrefix(msg.Topic, b.cfg.TopicPrefix+"/doors/")
+	if !ok {
+		return
+	}
+	ref, ok = strings.CutSuffix(ref, "/set")
+	if !ok || strings.Contains(ref, "/") {
+		return
+	}
+	if msg.Retain {
+		// a retained command would be carried out again on every reconnect
+		logger.Warn("Ignoring retained MQTT command", slog.String("topic", msg.Topic))
+		return
+	}
+
+	door, found := findDoor(ref)
+	if !found {
+		logger.Warn("Door not found for MQTT command", slog.String("topic", msg.Topic))
+		return
+	}
+	action := strings.ToLower(strings.TrimSpace(string(msg.Payload)))
+	command, ok := mqttCommands[action]
+	if !ok {
+		logger.Warn("Unknown MQTT command", slog.String("topic", msg.Topic), slog.String("payload", action))
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		err := command(door)
+		logger.Info("MQTT command",
+			slog.String("audit", "mqtt_command"),
+			slog.String("action", action),
+			slog.String("door_id", door.UacID),
+			slog.Bool("ok", err == nil))
+		recordAudit(doorAudit(door, action, "mqtt", "", "mqtt "+msg.Topic), err)
+		if err != nil {
+			recordDoorError(door, err)
+			return
+		}
+		publishDoorEvent(door, "mqtt", action, "", "")
+	}()
+}
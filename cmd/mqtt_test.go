@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/mqtt"
+)
+
+// publishedMessage is a PUBLISH the test broker received.
+type publishedMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// testBroker accepts a single MQTT connection and records what the client publishes and
+// subscribes to.
+type testBroker struct {
+	ln         net.Listener
+	published  chan publishedMessage
+	subscribed chan string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{ln: ln, published: make(chan publishedMessage, 100), subscribed: make(chan string, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			header, body, err := readTestPacket(br)
+			if err != nil {
+				return
+			}
+			switch header >> 4 {
+			case 1: // CONNECT
+				conn.Write([]byte{0x20, 2, 0, 0})
+			case 3: // PUBLISH
+				n := int(binary.BigEndian.Uint16(body))
+				b.published <- publishedMessage{string(body[2 : 2+n]), string(body[2+n:]), header&0x01 != 0}
+			case 8: // SUBSCRIBE
+				n := int(binary.BigEndian.Uint16(body[2:]))
+				b.subscribed <- string(body[4 : 4+n])
+				conn.Write([]byte{0x90, 3, body[0], body[1], 0})
+			}
+		}
+	}()
+	return b
+}
+
+// readTestPacket reads an MQTT packet, returning the first byte of its fixed header and its body.
+func readTestPacket(br *bufio.Reader) (byte, []byte, error) {
+	header, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(br, body)
+	return header, body, err
+}
+
+// collect returns the messages published until none arrives for a while.
+func (b *testBroker) collect() map[string]publishedMessage {
+	messages := make(map[string]publishedMessage)
+	for {
+		select {
+		case m := <-b.published:
+			messages[m.topic] = m
+		case <-time.After(200 * time.Millisecond):
+			return messages
+		}
+	}
+}
+
+// setTestDoors makes doors the doors in effect and silences the logger.
+func setTestDoors(t *testing.T, doors ...config.Door) {
+	t.Helper()
+	logger = slog.New(slog.DiscardHandler)
+	setAppConfig(&config.Config{Doors: doors})
+}
+
+// connectBridge connects a bridge to the broker and waits for it to come online.
+func connectBridge(t *testing.T, b *testBroker, cfg *config.MQTT) *mqttBridge {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.Options{Broker: "tcp://" + b.ln.Addr().String()})
+	bridge := &mqttBridge{
+		client:    client,
+		cfg:       cfg,
+		wg:        &sync.WaitGroup{},
+		refresh:   make(chan struct{}, 1),
+		published: make(map[string]string),
+		announced: make(map[string]bool),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx, bridge.onConnect, bridge.onMessage)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case m := <-b.published:
+		if m.topic != cfg.TopicPrefix+"/status" || m.payload != "online" || !m.retain {
+			t.Fatalf("first message = %+v, want online retained on the status topic", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the bridge did not come online")
+	}
+	return bridge
+}
+
+var testMQTTConfig = config.MQTT{
+	TopicPrefix:     config.DefaultMQTTTopicPrefix,
+	DiscoveryPrefix: config.DefaultMQTTDiscoveryPrefix,
+}
+
+func TestMQTTIgnoresRetainedCommands(t *testing.T) {
+	setTestDoors(t, config.Door{UacID: "d1", UacName: "Front"})
+	var mu sync.Mutex
+	var carriedOut []string
+	saved := mqttCommands
+	t.Cleanup(func() { mqttCommands = saved })
+	mqttCommands = map[string]func(door *config.Door) error{
+		"unlock": func(door *config.Door) error {
+			mu.Lock()
+			defer mu.Unlock()
+			carriedOut = append(carriedOut, door.UacID)
+			return nil
+		},
+	}
+
+	b := &mqttBridge{cfg: &testMQTTConfig, wg: &sync.WaitGroup{}}
+	tests := []struct {
+		name string
+		msg  mqtt.Message
+		want bool
+	}{
+		{"retained", mqtt.Message{Topic: "uahm/doors/d1/set", Payload: []byte("unlock"), Retain: true}, false},
+		{"by ID", mqtt.Message{Topic: "uahm/doors/d1/set", Payload: []byte("unlock")}, true},
+		{"by name", mqtt.Message{Topic: "uahm/doors/front/set", Payload: []byte(" UNLOCK ")}, true},
+		{"unknown door", mqtt.Message{Topic: "uahm/doors/d2/set", Payload: []byte("unlock")}, false},
+		{"unknown command", mqtt.Message{Topic: "uahm/doors/d1/set", Payload: []byte("open")}, false},
+		{"state topic", mqtt.Message{Topic: "uahm/doors/d1/position", Payload: []byte("unlock")}, false},
+	}
+	for _, tt := range tests {
+		carriedOut = nil
+		b.onMessage(tt.msg)
+		b.wg.Wait()
+		if got := len(carriedOut) == 1; got != tt.want {
+			t.Errorf("%s: carried out %q, want the command carried out: %t", tt.name, carriedOut, tt.want)
+		}
+	}
+}
+
+func TestMQTTDiscovery(t *testing.T) {
+	doors := []config.Door{{UacID: "d1", UacName: "Front"}, {UacID: "d2"}}
+	setTestDoors(t, doors...)
+	broker := newTestBroker(t)
+	b := connectBridge(t, broker, &testMQTTConfig)
+	if filter := <-broker.subscribed; filter != "uahm/doors/+/set" {
+		t.Errorf("subscribed to %q, want the command topics", filter)
+	}
+
+	b.announceDoors(doors)
+	messages := broker.collect()
+	if len(messages) != 8 {
+		t.Errorf("published %d discovery configs, want 4 for each door", len(messages))
+	}
+	lock, ok := messages["homeassistant/lock/uahm_d1/lock/config"]
+	if !ok || !lock.retain {
+		t.Fatalf("lock config = %+v, want it published retained", lock)
+	}
+	var cfg map[string]any
+	if err := json.Unmarshal([]byte(lock.payload), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"unique_id":          "uahm_d1_lock",
+		"availability_topic": "uahm/status",
+		"state_topic":        "uahm/doors/d1/relay",
+		"command_topic":      "uahm/doors/d1/set",
+		"payload_lock":       "lock",
+		"payload_unlock":     "keep_unlock",
+		"payload_open":       "unlock",
+	} {
+		if cfg[key] != want {
+			t.Errorf("lock config %s = %v, want %q", key, cfg[key], want)
+		}
+	}
+	if device, _ := cfg["device"].(map[string]any); device["name"] != "Front" {
+		t.Errorf("lock device = %v, want the door name", cfg["device"])
+	}
+	for _, topic := range []string{
+		"homeassistant/binary_sensor/uahm_d1/position/config",
+		"homeassistant/sensor/uahm_d1/lock_rule/config",
+		"homeassistant/sensor/uahm_d1/last_actor/config",
+		"homeassistant/lock/uahm_d2/lock/config",
+	} {
+		if _, ok := messages[topic]; !ok {
+			t.Errorf("no discovery config on %s", topic)
+		}
+	}
+
+	// announced doors are not published again, removed ones are withdrawn with empty configs
+	b.announceDoors(doors[:1])
+	messages = broker.collect()
+	var withdrawn []string
+	for topic, m := range messages {
+		if m.payload != "" || !m.retain {
+			t.Errorf("published %+v, want only empty retained configs", m)
+		}
+		withdrawn = append(withdrawn, topic)
+	}
+	slices.Sort(withdrawn)
+	want := []string{
+		"homeassistant/binary_sensor/uahm_d2/position/config",
+		"homeassistant/lock/uahm_d2/lock/config",
+		"homeassistant/sensor/uahm_d2/last_actor/config",
+		"homeassistant/sensor/uahm_d2/lock_rule/config",
+	}
+	if !slices.Equal(withdrawn, want) {
+		t.Errorf("withdrew %q, want %q", withdrawn, want)
+	}
+}
+
+func TestMQTTDiscoveryWithoutCommands(t *testing.T) {
+	doors := []config.Door{{UacID: "d1"}}
+	setTestDoors(t, doors...)
+	cfg := testMQTTConfig
+	cfg.DisableCommands = true
+	broker := newTestBroker(t)
+	b := connectBridge(t, broker, &cfg)
+
+	b.announceDoors(doors)
+	messages := broker.collect()
+	if _, ok := messages["homeassistant/lock/uahm_d1/lock/config"]; ok {
+		t.Error("a lock entity was announced with the commands disabled")
+	}
+	sensor, ok := messages["homeassistant/binary_sensor/uahm_d1/lock/config"]
+	if !ok {
+		t.Fatal("no binary sensor of the lock was announced")
+	}
+	var entity map[string]any
+	json.Unmarshal([]byte(sensor.payload), &entity)
+	if _, ok := entity["command_topic"]; ok || entity["device_class"] != "lock" {
+		t.Errorf("lock sensor config = %v, want a lock binary sensor without a command topic", entity)
+	}
+	select {
+	case filter := <-broker.subscribed:
+		t.Errorf("subscribed to %q with the commands disabled", filter)
+	default:
+	}
+}
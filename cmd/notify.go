@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/notify"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+const (
+	// notifyEventBuffer is how many events may queue up before they are matched to triggers.
+	notifyEventBuffer = 256
+	// notifyQueueSize is how many notifications may wait for a slow or failing channel before new ones are dropped.
+	notifyQueueSize = 50
+	// notifySendTimeout bounds the delivery of a single notification.
+	notifySendTimeout = 30 * time.Second
+)
+
+var notificationsSent = metricsRegistry.NewCounter("uahm_notifications_total",
+	"Notifications by channel and result: sent, failed, quiet or dropped.", "channel", "result")
+
+// notifier is a configured notification channel.
+type notifier struct {
+	cfg         config.NotificationChannel
+	channel     notify.Channel
+	title, body *template.Template
+	quietHours  *notify.QuietHours
+}
+
+// notifiers are the configured notification channels.
+var notifiers []*notifier
+
+// newNotifiers creates the configured notification channels. The Hubitat notification devices
+// are checked, so the Hubitat hubs must be connected first.
+func newNotifiers(cfg *config.Notifications, store *state.Store) ([]*notifier, error) {
+	var result []*notifier
+	for _, ch := range cfg.Channels {
+		n := &notifier{cfg: ch}
+		switch {
+		case ch.SMTP != nil:
+			tlsConfig, err := clientTLSConfig("notification_"+ch.Name, ch.SMTP.TLS, store)
+			if err != nil {
+				return nil, err
+			}
+			n.channel = notify.NewSMTP(notify.SMTPOptions{
+				Host:      ch.SMTP.Host,
+				Port:      ch.SMTP.Port,
+				Username:  ch.SMTP.Username,
+				Password:  ch.SMTP.Password,
+				From:      ch.SMTP.From,
+				To:        ch.SMTP.To,
+				Security:  ch.SMTP.Security,
+				Timeout:   notifySendTimeout,
+				TLSConfig: tlsConfig,
+			})
+		case ch.Push != nil:
+			tlsConfig, err := clientTLSConfig("notification_"+ch.Name, ch.Push.TLS, store)
+			if err != nil {
+				return nil, err
+			}
+			n.channel = notify.NewPush(notify.PushOptions{
+				URL:       ch.Push.URL,
+				Token:     ch.Push.Token,
+				Priority:  ch.Push.Priority,
+				Tags:      ch.Push.Tags,
+				Timeout:   notifySendTimeout,
+				TLSConfig: tlsConfig,
+			})
+		case ch.Hubitat != nil:
+			deviceID, client := ch.Hubitat.DeviceID, hubitatClients[ch.Hubitat.HubName()]
+			if client == nil {
+				// hubs are only added on restart
+				return nil, fmt.Errorf("notification channel %s: hub %s is not running, restart to add it", ch.Name, ch.Hubitat.HubName())
+			}
+			if err := client.VerifyNotificationDevice(deviceID); err != nil {
+				return nil, fmt.Errorf("notification channel %s: %w", ch.Name, err)
+			}
+			n.channel = notify.ChannelFunc(func(_ context.Context, msg notify.Message) error {
+				text := msg.Body
+				if msg.Title != "" {
+					text = msg.Title + ": " + text
+				}
+				return client.SendNotification(deviceID, text)
+			})
+		}
+
+		var err error
+		if ch.Title != "" {
+			if n.title, err = notify.ParseTemplate(ch.Name, ch.Title); err != nil {
+				return nil, err
+			}
+		}
+		if ch.Body != "" {
+			if n.body, err = notify.ParseTemplate(ch.Name, ch.Body); err != nil {
+				return nil, err
+			}
+		}
+		if q := ch.QuietHours; q != nil {
+			quietHours, err := notify.ParseQuietHours(q.Start, q.End)
+			if err != nil {
+				return nil, err
+			}
+			n.quietHours = &quietHours
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// notificationTrigger returns the trigger an event fires, if any. An upstream coming back
+// up fires upstream_down too, so the channels told about the outage hear about its end.
+func notificationTrigger(e events.Event) (string, bool) {
+	switch e.Type {
+	case config.TriggerAccessDenied, config.TriggerHeldOpen, config.TriggerForcedOpen, config.TriggerEmergency, config.TriggerUpstreamDown:
+		return e.Type, true
+	case "upstream_up":
+		return config.TriggerUpstreamDown, true
+	}
+	return "", false
+}
+
+// defaultNotification returns the message sent for an event when the channel has no templates.
+func defaultNotification(e events.Event) notify.Message {
+	door := e.Door
+	if door == "" {
+		door = "A door"
+	}
+	var msg notify.Message
+	switch e.Type {
+	case config.TriggerAccessDenied:
+		actor := e.Actor
+		if actor == "" {
+			actor = "Someone"
+		}
+		msg.Title = "Access denied at " + door
+		msg.Body = fmt.Sprintf("%s was denied access at %s", actor, door)
+		if e.Result != "" {
+			msg.Body += ": " + e.Result
+		}
+	case config.TriggerHeldOpen:
+		msg.Title = door + " held open"
+		msg.Body = fmt.Sprintf("%s has been open for more than %s", door, e.Value)
+	case config.TriggerForcedOpen:
+		msg.Title = door + " forced open"
+		msg.Body = door + " opened while it was locked"
+		msg.Priority = "high"
+	case config.TriggerEmergency:
+		msg.Title = "Emergency status: " + e.Value
+		msg.Body = "UniFi Access reported the emergency status " + e.Value
+		if e.Door != "" {
+			msg.Body += " at " + e.Door
+		}
+		msg.Priority = "high"
+	case config.TriggerUpstreamDown:
+		msg.Title = e.Value + " is down"
+		msg.Body = "The " + e.Value + " check failed"
+		if e.Result != "" {
+			msg.Body += ": " + e.Result
+		}
+	case "upstream_up":
+		msg.Title = e.Value + " is back up"
+		msg.Body = "The " + e.Value + " check passed again"
+	}
+	msg.Body += " (" + e.Time.Local().Format("Jan 2 15:04:05") + ")."
+	return msg
+}
+
+// notificationData is the data of the title and body templates.
+type notificationData struct {
+	events.Event
+	Trigger string
+	// Title and Message are the default title and body.
+	Title   string
+	Message string
+}
+
+// message renders the notification of an event for the channel.
+func (n *notifier) message(e events.Event, trigger string) (notify.Message, error) {
+	msg := defaultNotification(e)
+	data := notificationData{Event: e, Trigger: trigger, Title: msg.Title, Message: msg.Body}
+	render := func(tmpl *template.Template, dst *string) error {
+		if tmpl == nil {
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		*dst = strings.TrimSpace(buf.String())
+		return nil
+	}
+	if err := render(n.title, &msg.Title); err != nil {
+		return msg, fmt.Errorf("failed to render title: %w", err)
+	}
+	if err := render(n.body, &msg.Body); err != nil {
+		return msg, fmt.Errorf("failed to render body: %w", err)
+	}
+	return msg, nil
+}
+
+// quiet reports whether a trigger is dropped at time t by the quiet hours of the channel.
+func (n *notifier) quiet(trigger string, t time.Time) bool {
+	if n.quietHours == nil || slices.Contains(n.cfg.QuietHours.Except, trigger) {
+		return false
+	}
+	return n.quietHours.Contains(t.Local())
+}
+
+// notifiersWant reports whether any channel is sent notifications for a trigger.
+func notifiersWant(list []*notifier, trigger string) bool {
+	return slices.ContainsFunc(list, func(n *notifier) bool { return n.cfg.Wants(trigger) })
+}
+
+// runNotifications queues the events firing the triggers of each channel until ctx is
+// cancelled. Events during the quiet hours of a channel are not sent to it.
+func runNotifications(ctx context.Context, wg *sync.WaitGroup, list []*notifier) {
+	defer wg.Done()
+	evts, cancel := eventBus.Subscribe(notifyEventBuffer)
+	defer cancel()
+
+	queues := make([]chan events.Event, len(list))
+	for i, n := range list {
+		queues[i] = make(chan events.Event, notifyQueueSize)
+		wg.Add(1)
+		go sendNotifications(ctx, wg, n, queues[i])
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evts:
+			trigger, ok := notificationTrigger(e)
+			if !ok {
+				continue
+			}
+			for i, n := range list {
+				if !n.cfg.Wants(trigger) {
+					continue
+				}
+				if n.quiet(trigger, e.Time) {
+					notificationsSent.Inc(n.cfg.Name, "quiet")
+					logger.Info("Notification dropped during quiet hours",
+						slog.String("channel", n.cfg.Name), slog.String("trigger", trigger), slog.Uint64("event_id", e.ID))
+					continue
+				}
+				select {
+				case queues[i] <- e:
+				default:
+					notificationsSent.Inc(n.cfg.Name, "dropped")
+					logger.Warn("Notification queue is full, dropping notification",
+						slog.String("channel", n.cfg.Name), slog.Uint64("event_id", e.ID))
+				}
+			}
+		}
+	}
+}
+
+// sendNotifications sends the queued notifications of a channel in order.
+func sendNotifications(ctx context.Context, wg *sync.WaitGroup, n *notifier, queue <-chan events.Event) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-queue:
+			trigger, _ := notificationTrigger(e)
+			msg, err := n.message(e, trigger)
+			if err == nil {
+				err = n.channel.Send(ctx, msg)
+			}
+			if err != nil {
+				notificationsSent.Inc(n.cfg.Name, "failed")
+				logger.Error("Failed to send notification",
+					slog.String("channel", n.cfg.Name),
+					slog.Uint64("event_id", e.ID),
+					slog.String("err", err.Error()))
+				continue
+			}
+			notificationsSent.Inc(n.cfg.Name, "sent")
+			logger.Info("Notification sent", slog.String("channel", n.cfg.Name), slog.String("trigger", trigger))
+		}
+	}
+}
+
+// monitorUpstreams runs the readiness checks every interval until ctx is cancelled and publishes
+// an upstream_down event when a check starts failing and an upstream_up event when it recovers.
+func monitorUpstreams(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	defer wg.Done()
+	up := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, r := range runReadyChecks(getAppConfig()) {
+				wasUp, seen := up[r.Name]
+				up[r.Name] = r.OK
+				switch {
+				case !r.OK && (wasUp || !seen):
+					logger.Warn("Upstream is down", slog.String("check", r.Name), slog.String("err", r.Error))
+					publishAppEvent("app", "upstream_down", r.Name, r.Error)
+				case r.OK && seen && !wasUp:
+					logger.Info("Upstream is back up", slog.String("check", r.Name))
+					publishAppEvent("app", "upstream_up", r.Name, "")
+				}
+			}
+		}
+	}
+}
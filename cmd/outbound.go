@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/outbound"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+const (
+	// outboundEventBuffer is how many events may queue up before they are filtered.
+	outboundEventBuffer = 256
+	// outboundQueueSize is how many deliveries may wait for a slow or failing target before new ones are dropped.
+	outboundQueueSize = 100
+)
+
+var outboundDeliveries = metricsRegistry.NewCounter("uahm_outbound_webhooks_total",
+	"Outbound webhook deliveries by target and result: ok, failed or dropped.", "target", "result")
+
+// outboundTargets are the configured outbound webhooks.
+var outboundTargets []*outbound.Target
+
+// newOutboundTargets creates the targets of the configured outbound webhooks.
+func newOutboundTargets(cfgs []config.OutboundWebhook, store *state.Store) ([]*outbound.Target, error) {
+	var targets []*outbound.Target
+	for _, cfg := range cfgs {
+		tlsConfig, err := clientTLSConfig("outbound_webhook_"+cfg.Name, cfg.TLS, store)
+		if err != nil {
+			return nil, err
+		}
+		opts := outbound.Options{
+			Name:      cfg.Name,
+			URL:       cfg.URL,
+			Method:    cfg.Method,
+			Headers:   cfg.Headers,
+			Secret:    cfg.Secret,
+			Timeout:   cfg.Timeout,
+			Attempts:  cfg.Attempts,
+			Backoff:   cfg.Backoff,
+			TLSConfig: tlsConfig,
+			Filter: outbound.Filter{
+				Doors:   cfg.Filter.Doors,
+				Types:   cfg.Filter.Types,
+				Actors:  cfg.Filter.Actors,
+				Sources: cfg.Filter.Sources,
+				Results: cfg.Filter.Results,
+			},
+		}
+		if cfg.Body != "" {
+			if opts.Body, err = outbound.ParseBody(cfg.Name, cfg.Body); err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, outbound.NewTarget(opts))
+	}
+	return targets, nil
+}
+
+// runOutboundWebhooks queues the events passing the filter of each target until ctx is
+// cancelled. Every target has its own queue, so a slow one doesn't hold up the others.
+func runOutboundWebhooks(ctx context.Context, wg *sync.WaitGroup, targets []*outbound.Target) {
+	defer wg.Done()
+	evts, cancel := eventBus.Subscribe(outboundEventBuffer)
+	defer cancel()
+
+	queues := make([]chan events.Event, len(targets))
+	for i, target := range targets {
+		queues[i] = make(chan events.Event, outboundQueueSize)
+		wg.Add(1)
+		go deliverOutbound(ctx, wg, target, queues[i])
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evts:
+			for i, target := range targets {
+				if !target.Wants(e) {
+					continue
+				}
+				select {
+				case queues[i] <- e:
+				default:
+					outboundDeliveries.Inc(target.Name(), "dropped")
+					logger.Warn("Outbound webhook queue is full, dropping event",
+						slog.String("webhook", target.Name()), slog.Uint64("event_id", e.ID))
+				}
+			}
+		}
+	}
+}
+
+// deliverOutbound delivers the queued events of a target in order.
+func deliverOutbound(ctx context.Context, wg *sync.WaitGroup, target *outbound.Target, queue <-chan events.Event) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-queue:
+			if err := target.Deliver(ctx, e); err != nil {
+				outboundDeliveries.Inc(target.Name(), "failed")
+				logger.Error("Failed to deliver outbound webhook",
+					slog.String("webhook", target.Name()),
+					slog.Uint64("event_id", e.ID),
+					slog.String("err", err.Error()))
+				continue
+			}
+			outboundDeliveries.Inc(target.Name(), "ok")
+		}
+	}
+}
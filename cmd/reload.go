@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+// configWatchInterval is how often the config file is checked for modifications.
+const configWatchInterval = 5 * time.Second
+
+// runtimeState is the configuration in effect together with its door index. It is
+// replaced as a whole on reload so an event is always handled with one consistent config.
+type runtimeState struct {
+	config *config.Config
+	doors  *doorIndex
+}
+
+var (
+	appState atomic.Pointer[runtimeState]
+
+	// reloadMu serializes reloads triggered by SIGHUP and by the file watcher.
+	reloadMu sync.Mutex
+
+	// reloadStats counts the configuration reloads by result.
+	reloadStats struct {
+		success     atomic.Int64
+		failure     atomic.Int64
+		lastSuccess atomic.Int64 // unix time of the last successful reload
+	}
+)
+
+// setAppConfig makes cfg the configuration in effect.
+func setAppConfig(cfg *config.Config) {
+	appState.Store(&runtimeState{config: cfg, doors: newDoorIndex(cfg.Doors, cfg.DoorGroups)})
+}
+
+// getAppConfig returns the configuration in effect.
+func getAppConfig() *config.Config {
+	return appState.Load().config
+}
+
+// prepareConfig resolves and verifies the doors of a statically valid config against UAC and
+// the backends. Invalid doors are disabled when the config allows starting degraded.
+func prepareConfig(cfg *config.Config) error {
+	clients := &upstreamClients{uac: uacClients, hubitat: hubitatClients, homeAssistant: homeAssistantClient}
+	problems, unreachable, err := validateUpstream(cfg, clients)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(unreachable)) {
+		logger.Warn("UAC controller is unreachable, its doors are kept unverified",
+			slog.String("controller", uacUpstreamName(name)), slog.String("err", unreachable[name].Error()))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	for _, p := range problems {
+		logger.Error("Invalid door config", slog.String("err", p.Error()))
+	}
+	if !cfg.Server.StartDegraded {
+		return fmt.Errorf("%d doors are invalid, set server.start_degraded to run without them", len(problems))
+	}
+	disableDoors(cfg, problems)
+	logger.Warn("Running degraded, invalid doors are disabled",
+		slog.Int("disabled", len(problems)), slog.Int("enabled", len(cfg.Doors)))
+	return nil
+}
+
+// keepRestartSettings copies the settings that only take effect on restart from the running
+// config into the reloaded one, logging the ones that were changed in the file.
+func keepRestartSettings(running, reloaded *config.Config) {
+	server := *running.Server
+	server.StartDegraded = reloaded.Server.StartDegraded
+	server.ReadyMaxLatency = reloaded.Server.ReadyMaxLatency
+	server.AuthToken = reloaded.Server.AuthToken
+	server.AuthTokenFile = reloaded.Server.AuthTokenFile
+	server.Tokens = reloaded.Server.Tokens
+	if running.Server.UACTokens()[0] != reloaded.Server.UACTokens()[0] {
+		logger.Warn("The first UAC token changed, UniFi Access keeps sending the previous one until restart, keep it in server.tokens.uac until then")
+	}
+
+	var changed []string
+	changed = append(changed, changedFields("server", &server, reloaded.Server)...)
+	changed = append(changed, changedFields("uac", running.UAC, reloaded.UAC)...)
+	if !reflect.DeepEqual(running.UACControllers, reloaded.UACControllers) {
+		changed = append(changed, "uac_controllers")
+	}
+	changed = append(changed, changedFields("hubitat", running.Hubitat, reloaded.Hubitat)...)
+	if !reflect.DeepEqual(running.HubitatHubs, reloaded.HubitatHubs) {
+		changed = append(changed, "hubitat_hubs")
+	}
+	changed = append(changed, changedFields("home_assistant", running.HomeAssistant, reloaded.HomeAssistant)...)
+	changed = append(changed, changedFields("mqtt", running.MQTT, reloaded.MQTT)...)
+	changed = append(changed, changedFields("homekit", running.HomeKit, reloaded.HomeKit)...)
+	if !reflect.DeepEqual(running.OutboundWebhooks, reloaded.OutboundWebhooks) {
+		changed = append(changed, "outbound_webhooks")
+	}
+	changed = append(changed, changedFields("notifications", running.Notifications, reloaded.Notifications)...)
+
+	if reloaded.StateDir != running.StateDir {
+		changed = append(changed, "state_dir")
+	}
+	if reloaded.Journal.Disabled != running.Journal.Disabled {
+		changed = append(changed, "journal.disabled")
+	}
+	for _, field := range changed {
+		logger.Warn("Config change requires a restart to take effect", slog.String("field", field))
+	}
+
+	reloaded.Server = &server
+	reloaded.StateDir = running.StateDir
+	reloaded.Journal.Disabled = running.Journal.Disabled
+	reloaded.UAC = running.UAC
+	reloaded.UACControllers = running.UACControllers
+	reloaded.Hubitat = running.Hubitat
+	reloaded.HubitatHubs = running.HubitatHubs
+	reloaded.HomeAssistant = running.HomeAssistant
+	reloaded.MQTT = running.MQTT
+	reloaded.HomeKit = running.HomeKit
+	reloaded.OutboundWebhooks = running.OutboundWebhooks
+	reloaded.Notifications = running.Notifications
+}
+
+// changedFields returns the YAML paths of the top level fields that differ between two structs of the same type.
+// When only one of two struct pointers is nil, the section was added or removed and prefix is returned.
+func changedFields(prefix string, a, b any) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() == reflect.Pointer {
+		if va.IsNil() || vb.IsNil() {
+			if va.IsNil() != vb.IsNil() {
+				return []string{prefix}
+			}
+			return nil
+		}
+		va, vb = va.Elem(), vb.Elem()
+	}
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("yaml"), ",")
+			changed = append(changed, prefix+"."+name)
+		}
+	}
+	return changed
+}
+
+// reloadConfig loads, validates and applies the config file. The running config is kept
+// when anything is wrong with the new one.
+func reloadConfig(configPath string, trigger string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	err := func() error {
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		keepRestartSettings(getAppConfig(), cfg)
+		if err := prepareConfig(cfg); err != nil {
+			return err
+		}
+		// catching up on an unchanged schedule would undo the lock rules set by hand since
+		schedulesChanged := !reflect.DeepEqual(getAppConfig().Schedules, cfg.Schedules)
+		setAppConfig(cfg)
+		requestStatusRefresh()
+		if schedulesChanged {
+			requestScheduleCatchUp()
+		}
+		return nil
+	}()
+
+	if err != nil {
+		reloadStats.failure.Add(1)
+		logConfigErrors("Failed to reload config", err)
+		logger.Error("Config reload failed, keeping the running config",
+			slog.String("trigger", trigger),
+			slog.Int64("reload_failures", reloadStats.failure.Load()))
+		return err
+	}
+
+	reloadStats.success.Add(1)
+	reloadStats.lastSuccess.Store(time.Now().Unix())
+	logger.Info("Config reloaded", slog.String("trigger", trigger),
+		slog.Int("doors", len(getAppConfig().Doors)),
+		slog.Int64("reload_successes", reloadStats.success.Load()))
+
+	// drop the door gauges of removed or renamed doors, they are set again by the sync and the next poll
+	doorOpen.Reset()
+	doorLocked.Reset()
+
+	// bring newly added doors in sync
+	for name := range uacClients {
+		go syncDoorPositions(name)
+	}
+	return nil
+}
+
+// watchConfig reloads the config whenever the config file is modified.
+func watchConfig(ctx context.Context, wg *sync.WaitGroup, configPath string) {
+	defer wg.Done()
+
+	lastMod := func() time.Time {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	seen := lastMod()
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if mod := lastMod(); !mod.IsZero() && !mod.Equal(seen) {
+				seen = mod
+				_ = reloadConfig(configPath, "file")
+			}
+		}
+	}
+}
+
+// authorizer returns a function that checks a token against the tokens of a source in the
+// config in effect, so tokens can be rotated by reloading the config.
+func authorizer(tokens func(*config.Server) []string) func(token string) bool {
+	return func(token string) bool {
+		return utils.TokenMatches(token, tokens(getAppConfig().Server))
+	}
+}
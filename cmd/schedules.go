@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	// the time zones of the schedules are found without the tzdata of the system
+	_ "time/tzdata"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/schedule"
+)
+
+const (
+	// scheduleCheckInterval is how often the schedules are checked when no transition is due
+	// sooner, so schedules changed by a reload are picked up.
+	scheduleCheckInterval = time.Minute
+	// defaultTransitionsLimit is how many upcoming transitions are listed by default.
+	defaultTransitionsLimit = 10
+)
+
+// schedulePlan is a schedule with its parsed days, time zone and transition times.
+type schedulePlan struct {
+	entry *config.Schedule
+	days  schedule.Days
+	loc   *time.Location
+	at    []time.Duration // the time of day of each transition
+}
+
+// newSchedulePlans parses the configured schedules.
+func newSchedulePlans(s *config.Schedules) ([]schedulePlan, error) {
+	if s == nil {
+		return nil, nil
+	}
+	plans := make([]schedulePlan, 0, len(s.Entries))
+	for i := range s.Entries {
+		p := schedulePlan{entry: &s.Entries[i]}
+		var err error
+		if p.days, err = s.Days(p.entry); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", p.entry.Name, err)
+		}
+		if p.loc, err = s.Location(p.entry); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", p.entry.Name, err)
+		}
+		for _, t := range p.entry.Transitions {
+			at, err := schedule.ParseClock(t.At)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", p.entry.Name, err)
+			}
+			p.at = append(p.at, at)
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// plannedTransition is a transition of a schedule at a point in time.
+type plannedTransition struct {
+	Time       time.Time `json:"time"`
+	Schedule   string    `json:"schedule"`
+	Rule       string    `json:"rule"`
+	Minutes    int       `json:"minutes,omitempty"`
+	Doors      []string  `json:"doors,omitempty"`
+	DoorGroups []string  `json:"door_groups,omitempty"`
+}
+
+// transitions returns the transitions of the plan after t and up to until, or without an end when
+// until is zero, at most limit of each.
+func (p *schedulePlan) transitions(after, until time.Time, limit int) []plannedTransition {
+	var result []plannedTransition
+	for i, at := range p.at {
+		t := after
+		for n := 0; n < limit; n++ {
+			next, ok := schedule.Next(t, at, p.days, p.loc)
+			if !ok || (!until.IsZero() && next.After(until)) {
+				break
+			}
+			tr := p.entry.Transitions[i]
+			result = append(result, plannedTransition{
+				Time:       next,
+				Schedule:   p.entry.Name,
+				Rule:       tr.Rule,
+				Minutes:    tr.Minutes,
+				Doors:      p.entry.Doors,
+				DoorGroups: p.entry.DoorGroups,
+			})
+			t = next
+		}
+	}
+	return result
+}
+
+// upcomingTransitions returns the next limit transitions of all schedules after t, in time order.
+func upcomingTransitions(plans []schedulePlan, after time.Time, limit int) []plannedTransition {
+	var result []plannedTransition
+	for i := range plans {
+		result = append(result, plans[i].transitions(after, time.Time{}, limit)...)
+	}
+	slices.SortStableFunc(result, func(a, b plannedTransition) int { return a.Time.Compare(b.Time) })
+	return result[:min(limit, len(result))]
+}
+
+// inEffect returns the last transition of the plan up to now, which is in effect until the next
+// one. A custom rule is returned with the minutes it has left, and not at all once they are over.
+func (p *schedulePlan) inEffect(now time.Time) (plannedTransition, bool) {
+	var latest plannedTransition
+	found := false
+	for i, at := range p.at {
+		prev, ok := schedule.Previous(now, at, p.days, p.loc)
+		if !ok || (found && !prev.After(latest.Time)) {
+			continue
+		}
+		tr := p.entry.Transitions[i]
+		latest = plannedTransition{
+			Time:       prev,
+			Schedule:   p.entry.Name,
+			Rule:       tr.Rule,
+			Minutes:    tr.Minutes,
+			Doors:      p.entry.Doors,
+			DoorGroups: p.entry.DoorGroups,
+		}
+		found = true
+	}
+	if found && latest.Rule == "custom" {
+		left := latest.Time.Add(time.Duration(latest.Minutes) * time.Minute).Sub(now)
+		if left <= 0 {
+			return plannedTransition{}, false
+		}
+		latest.Minutes = int((left + time.Minute - 1) / time.Minute)
+	}
+	return latest, found
+}
+
+// applySchedulesInEffect applies the transition in effect at now of every schedule, so the doors
+// are in the state of their schedules after a start or reload.
+func applySchedulesInEffect(now time.Time) {
+	plans, err := newSchedulePlans(getAppConfig().Schedules)
+	if err != nil {
+		logger.Error("Invalid schedules", slog.String("err", err.Error()))
+	}
+	for i := range plans {
+		if t, ok := plans[i].inEffect(now); ok {
+			logger.Info("Applying the schedule transition in effect",
+				slog.String("schedule", t.Schedule), slog.Time("since", t.Time))
+			applyTransition(t)
+		}
+	}
+}
+
+// schedulesReloaded asks runSchedules to apply the transitions in effect of the changed schedules.
+var schedulesReloaded = make(chan struct{}, 1)
+
+// requestScheduleCatchUp makes runSchedules apply the transitions in effect, e.g. after a reload
+// changed the schedules.
+func requestScheduleCatchUp() {
+	select {
+	case schedulesReloaded <- struct{}{}:
+	default:
+	}
+}
+
+// runSchedules applies the transitions of the schedules in effect until ctx is cancelled. At
+// startup and after a reload that changed the schedules or holidays, the transition in effect of
+// every schedule is applied, which covers the transitions due while the middleware was not
+// running. When several transitions of a schedule are due at once, e.g. after the clock jumped,
+// only the last one is applied.
+func runSchedules(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	last := time.Now()
+	applySchedulesInEffect(last)
+	for {
+		plans, err := newSchedulePlans(getAppConfig().Schedules)
+		if err != nil {
+			logger.Error("Invalid schedules", slog.String("err", err.Error()))
+		}
+		wait := scheduleCheckInterval
+		if next := upcomingTransitions(plans, last, 1); len(next) > 0 {
+			wait = min(wait, time.Until(next[0].Time))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-schedulesReloaded:
+			timer.Stop()
+			last = time.Now()
+			applySchedulesInEffect(last)
+			continue
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for i := range plans {
+			due := plans[i].transitions(last, now, 1<<10)
+			if len(due) == 0 {
+				continue
+			}
+			slices.SortStableFunc(due, func(a, b plannedTransition) int { return a.Time.Compare(b.Time) })
+			applyTransition(due[len(due)-1])
+		}
+		last = now
+	}
+}
+
+// scheduleDoors returns the doors in effect of a schedule, the members of its door groups included.
+func scheduleDoors(entry *config.Schedule) ([]*config.Door, []error) {
+	var doors []*config.Door
+	var errs []error
+	add := func(door *config.Door) {
+		if !slices.ContainsFunc(doors, func(d *config.Door) bool { return d.UacID == door.UacID }) {
+			doors = append(doors, door)
+		}
+	}
+	for _, ref := range entry.Doors {
+		door, found := findDoor(ref)
+		if !found {
+			errs = append(errs, fmt.Errorf("door %q is not configured", ref))
+			continue
+		}
+		add(door)
+	}
+	groups := getAppConfig().DoorGroups
+	for _, name := range entry.DoorGroups {
+		i := slices.IndexFunc(groups, func(g config.DoorGroup) bool { return g.Name == name })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("door group %q is not configured", name))
+			continue
+		}
+		for _, id := range groups[i].Members {
+			door, found := getDoorByUacID(id)
+			if !found {
+				errs = append(errs, fmt.Errorf("door %s of group %s is not configured", id, name))
+				continue
+			}
+			add(door)
+		}
+	}
+	return doors, errs
+}
+
+// applyTransition sets the lock rule of a transition on every door of its schedule. Each door is
+// audited with the source "schedule" and the schedule as the actor.
+func applyTransition(t plannedTransition) {
+	entry := findSchedule(t.Schedule)
+	if entry == nil {
+		return // removed by a reload
+	}
+	doors, errs := scheduleDoors(entry)
+	trigger := fmt.Sprintf("schedule %s at %s", t.Schedule, t.Time.Format("Mon 15:04 MST"))
+	failed := 0
+	for _, door := range doors {
+		err := uacFor(door).SetDoorLockRule(door.UacID, t.Rule, t.Minutes)
+		recordAudit(doorAudit(door, t.Rule, "schedule", t.Schedule, trigger), err)
+		if err != nil {
+			failed++
+			logger.Error("Failed to apply schedule to door",
+				slog.String("schedule", t.Schedule),
+				slog.String("rule", t.Rule),
+				slog.String("door_id", door.UacID),
+				slog.String("err", err.Error()))
+			recordDoorError(door, err)
+			continue
+		}
+		publishDoorEvent(door, "schedule", "command", t.Rule, t.Schedule)
+	}
+	for _, err := range errs {
+		failed++
+		logger.Error("Failed to apply schedule", slog.String("schedule", t.Schedule), slog.String("err", err.Error()))
+	}
+	logger.Info("Schedule transition applied",
+		slog.String("schedule", t.Schedule),
+		slog.String("rule", t.Rule),
+		slog.Int("doors", len(doors)),
+		slog.Int("failed", failed))
+}
+
+// findSchedule returns the schedule in effect with the given name.
+func findSchedule(name string) *config.Schedule {
+	s := getAppConfig().Schedules
+	if s == nil {
+		return nil
+	}
+	for i := range s.Entries {
+		if s.Entries[i].Name == name {
+			return &s.Entries[i]
+		}
+	}
+	return nil
+}
+
+// checkScheduleDoors checks that the doors of the schedules are configured, once the door names are resolved.
+func checkScheduleDoors(cfg *config.Config) error {
+	if cfg.Schedules == nil {
+		return nil
+	}
+	var errs []error
+	for i, e := range cfg.Schedules.Entries {
+		for _, ref := range e.Doors {
+			if _, found := lookupDoor(cfg.Doors, ref); !found {
+				errs = append(errs, fmt.Errorf("schedules.entries[%d] (%s): doors: %q is not a configured door", i, e.Name, ref))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleListTransitions returns the next transitions of the schedules, in number limited with ?limit=.
+func handleListTransitions(w http.ResponseWriter, r *http.Request) {
+	limit := defaultTransitionsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative number")
+			return
+		}
+		limit = n
+	}
+	plans, err := newSchedulePlans(getAppConfig().Schedules)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	transitions := upcomingTransitions(plans, time.Now(), limit)
+	if transitions == nil {
+		transitions = []plannedTransition{}
+	}
+	writeJSON(w, http.StatusOK, transitions)
+}
+
+// runSchedulesCommand implements the "schedules" command which prints the next transitions of
+// the schedules in the config file.
+func runSchedulesCommand(configPath string, args []string) error {
+	fs := flag.NewFlagSet("schedules", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", configPath, "path of the config file")
+	limit := fs.Int("n", defaultTransitionsLimit, "number of transitions to print")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	plans, err := newSchedulePlans(cfg.Schedules)
+	if err != nil {
+		return err
+	}
+	transitions := upcomingTransitions(plans, time.Now(), *limit)
+	if len(transitions) == 0 {
+		fmt.Fprintln(os.Stdout, "No transitions planned")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSCHEDULE\tRULE\tDOORS")
+	for _, t := range transitions {
+		rule := t.Rule
+		if t.Minutes > 0 {
+			rule += fmt.Sprintf(" (%d min)", t.Minutes)
+		}
+		targets := slices.Clone(t.Doors)
+		for _, g := range t.DoorGroups {
+			targets = append(targets, "group "+g)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Time.Format("Mon 2006-01-02 15:04 MST"), t.Schedule, rule, strings.Join(targets, ", "))
+	}
+	return tw.Flush()
+}
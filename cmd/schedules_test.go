@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+func TestScheduleInEffect(t *testing.T) {
+	plan := schedulePlan{
+		entry: &config.Schedule{Name: "office", Transitions: []config.ScheduleTransition{
+			{At: "08:00", Rule: "keep_unlock"},
+			{At: "12:00", Rule: "custom", Minutes: 60},
+			{At: "18:00", Rule: "reset"},
+		}},
+		loc: time.UTC,
+		at:  []time.Duration{8 * time.Hour, 12 * time.Hour, 18 * time.Hour},
+	}
+	day := func(hour, minute int) time.Time { return time.Date(2026, 10, 14, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		now     time.Time
+		rule    string
+		minutes int
+		since   time.Time
+	}{
+		{day(7, 0), "reset", 0, day(-6, 0)},
+		{day(8, 0), "keep_unlock", 0, day(8, 0)},
+		{day(11, 59), "keep_unlock", 0, day(8, 0)},
+		// a custom rule is applied for the minutes it has left
+		{day(12, 0), "custom", 60, day(12, 0)},
+		{day(12, 30), "custom", 30, day(12, 0)},
+		{day(12, 59).Add(30 * time.Second), "custom", 1, day(12, 0)},
+		// and is over after them, so nothing is applied
+		{day(13, 0), "", 0, time.Time{}},
+		{day(18, 0), "reset", 0, day(18, 0)},
+	}
+	for _, tt := range tests {
+		got, ok := plan.inEffect(tt.now)
+		if tt.rule == "" {
+			if ok {
+				t.Errorf("inEffect(%s) = %+v, want none", tt.now.Format("15:04:05"), got)
+			}
+			continue
+		}
+		if !ok || got.Rule != tt.rule || got.Minutes != tt.minutes || !got.Time.Equal(tt.since) {
+			t.Errorf("inEffect(%s) = %s %d since %s, %t, want %s %d since %s", tt.now.Format("15:04:05"),
+				got.Rule, got.Minutes, got.Time, ok, tt.rule, tt.minutes, tt.since)
+		}
+	}
+}
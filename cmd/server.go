@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+)
+
+// newHTTPServer creates the HTTP server for the given routes using the server settings.
+func newHTTPServer(cfg *config.Server, mux *http.ServeMux) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	if cfg.TLS != nil {
+		cert, err := loadServerCertificate(cfg)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+
+		if cfg.TLS.ClientCAFile != "" {
+			pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read client CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.TLS.ClientCAFile)
+			}
+			// certificates are verified when given, routes decide whether one is required
+			srv.TLSConfig.ClientCAs = pool
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return srv, nil
+}
+
+// startHTTPServer binds the listen address and serves requests in the background.
+// Binding errors are returned right away instead of surfacing from the goroutine.
+func startHTTPServer(srv *http.Server) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		ln = tls.NewListener(ln, srv.TLSConfig)
+	}
+
+	go func() {
+		logger.Info("Starting Server", slog.String("listen", srv.Addr), slog.Bool("tls", srv.TLSConfig != nil))
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Server failed", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}()
+	return nil
+}
+
+// shutdownHTTPServer stops accepting requests, drains the open connections and then waits for
+// the queued events and background workers tracked by wg, all within the shutdown timeout.
+func shutdownHTTPServer(srv *http.Server, wg *sync.WaitGroup, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("Failed to shutdown server gracefully", slog.String("err", err.Error()))
+	} else {
+		logger.Info("Server shutdown gracefully")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Info("Queued events drained")
+	case <-ctx.Done():
+		logger.Warn("Timed out waiting for queued events to finish")
+	}
+}
+
+// loadServerCertificate loads the configured certificate, or generates a self-signed one.
+func loadServerCertificate(cfg *config.Server) (tls.Certificate, error) {
+	t := cfg.TLS
+	if t.CertFile != "" {
+		_, certErr := os.Stat(t.CertFile)
+		_, keyErr := os.Stat(t.KeyFile)
+		if !t.SelfSigned || (certErr == nil && keyErr == nil) {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("failed to load server certificate: %w", err)
+			}
+			return cert, nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCertificate(cfg.BaseURL)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	if t.CertFile != "" {
+		if err := os.WriteFile(t.CertFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to save self-signed certificate: %w", err)
+		}
+		if err := os.WriteFile(t.KeyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to save self-signed certificate key: %w", err)
+		}
+		logger.Info("Generated self-signed certificate", slog.String("cert_file", t.CertFile))
+	} else {
+		logger.Warn("Generated a temporary self-signed certificate, set server.tls.cert_file and key_file to keep it across restarts")
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// generateSelfSignedCertificate creates a certificate valid for the host of baseURL and localhost.
+func generateSelfSignedCertificate(baseURL string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "unifi-access-hubitat-middleware"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		if ip := net.ParseIP(u.Hostname()); ip != nil {
+			if !ip.IsLoopback() {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if u.Hostname() != "localhost" {
+			template.DNSNames = append(template.DNSNames, u.Hostname())
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
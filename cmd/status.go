@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// doorActivity is the last event handled and the last error seen for a door.
+type doorActivity struct {
+	LastEvent     *time.Time `json:"last_event,omitempty"`
+	LastEventType string     `json:"last_event_type,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// activity holds the activity of each door by UAC ID.
+var activity = struct {
+	sync.Mutex
+	doors map[string]doorActivity
+}{doors: make(map[string]doorActivity)}
+
+// recordDoorEvent records that an event was handled for a door.
+func recordDoorEvent(door *config.Door, eventType string) {
+	activity.Lock()
+	defer activity.Unlock()
+	a := activity.doors[door.UacID]
+	now := time.Now()
+	a.LastEvent, a.LastEventType = &now, eventType
+	activity.doors[door.UacID] = a
+}
+
+// recordDoorError records the last error while handling or syncing a door.
+func recordDoorError(door *config.Door, err error) {
+	activity.Lock()
+	defer activity.Unlock()
+	a := activity.doors[door.UacID]
+	now := time.Now()
+	a.LastError, a.LastErrorTime = err.Error(), &now
+	activity.doors[door.UacID] = a
+}
+
+func doorActivityOf(uacID string) doorActivity {
+	activity.Lock()
+	defer activity.Unlock()
+	return activity.doors[uacID]
+}
+
+// uacDoorStatus is the state of a door in UAC.
+type uacDoorStatus struct {
+	Position     string `json:"position,omitempty"`
+	Relay        string `json:"relay,omitempty"`
+	LockRule     string `json:"lock_rule,omitempty"`
+	LockRuleEnds int64  `json:"lock_rule_ends,omitempty"`
+}
+
+// deviceStatus is the state of a backend device mapped to a door.
+type deviceStatus struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	State string `json:"state,omitempty"`
+	// Expected is the state mirroring UAC, empty for devices that don't mirror UAC.
+	Expected string `json:"expected,omitempty"`
+	InSync   *bool  `json:"in_sync,omitempty"`
+}
+
+// doorStatus is the state of a configured door on both sides.
+type doorStatus struct {
+	Name  string `json:"name"`
+	UacID string `json:"uac_id"`
+	// Controller is the name of the UAC controller of the door.
+	Controller string        `json:"controller"`
+	UAC        uacDoorStatus `json:"uac"`
+	Backend    string        `json:"backend"`
+	// Hub is the name of the Hubitat hub of the devices, empty for other backends.
+	Hub     string                   `json:"hub,omitempty"`
+	Devices map[string]*deviceStatus `json:"devices"`
+	doorActivity
+	// Errors are the problems querying the current state.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// collectDoorStatus queries UAC and the backends for the state of every configured door. It
+// fails only when no UAC controller answers; the doors of the others report the error.
+func collectDoorStatus(cfg *config.Config) ([]doorStatus, error) {
+	byID := make(map[string]uac.Door)
+	fetchErrs := make(map[string]error)
+	for name, client := range uacClients {
+		uacDoors, err := client.FetchAllDoors()
+		if err != nil {
+			fetchErrs[name] = fmt.Errorf("failed to fetch UAC doors of %s: %w", uacUpstreamName(name), err)
+			continue
+		}
+		for _, d := range uacDoors {
+			byID[d.ID] = d
+		}
+	}
+	if len(fetchErrs) == len(uacClients) {
+		return nil, errors.Join(slices.Collect(maps.Values(fetchErrs))...)
+	}
+
+	statuses := make([]doorStatus, len(cfg.Doors))
+	var wg sync.WaitGroup
+	for i := range cfg.Doors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = queryDoorStatus(&cfg.Doors[i], byID, fetchErrs[cfg.Doors[i].Controller()])
+		}()
+	}
+	wg.Wait()
+	return statuses, nil
+}
+
+// queryDoorStatus queries the lock rule and backend devices of a door. fetchErr is the error
+// fetching the doors of its controller.
+func queryDoorStatus(door *config.Door, uacDoors map[string]uac.Door, fetchErr error) doorStatus {
+	s := doorStatus{
+		Name:         door.Name(),
+		UacID:        door.UacID,
+		Controller:   door.Controller(),
+		Backend:      door.BackendName(),
+		Devices:      make(map[string]*deviceStatus),
+		doorActivity: doorActivityOf(door.UacID),
+	}
+	if s.Backend == config.BackendHubitat {
+		s.Hub = door.Hub()
+	}
+
+	if d, ok := uacDoors[door.UacID]; ok {
+		s.UAC.Position = d.DoorPositionStatus
+		s.UAC.Relay = d.DoorLockRelayStatus
+	} else if fetchErr != nil {
+		s.Errors = append(s.Errors, fetchErr.Error())
+	} else {
+		s.Errors = append(s.Errors, "door not found in UAC")
+	}
+	lockRuleFetched := false
+	if rule, err := uacFor(door).GetDoorLockRule(door.UacID); err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("lock rule: %s", err))
+	} else {
+		s.UAC.LockRule = rule.Type
+		s.UAC.LockRuleEnds = int64(rule.EndedTime)
+		lockRuleFetched = true
+	}
+
+	type device struct {
+		kind, id, expected string
+	}
+	contactExpected, _ := contactState(s.UAC.Position)
+	devices := []device{{"contact", door.ContactID(), contactExpected}, {"switch", door.SwitchID(), ""}}
+	if lockID, ok := door.LockID(); ok {
+		var lockExpected string
+		if door.Options.MirrorsLockRule() && lockRuleFetched {
+			lockExpected, _ = lockRuleState(s.UAC.LockRule)
+		}
+		devices = append(devices, device{"lock", lockID, lockExpected})
+	}
+	backend, err := backendOf(door)
+	if err != nil {
+		s.Errors = append(s.Errors, err.Error())
+		return s
+	}
+	for _, dev := range devices {
+		name, state, err := backend.DeviceState(dev.kind, dev.id)
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("%s: %s", dev.kind, err))
+			s.Devices[dev.kind] = &deviceStatus{ID: dev.id}
+			continue
+		}
+		status := &deviceStatus{ID: dev.id, Name: name, State: state, Expected: dev.expected}
+		if dev.expected != "" {
+			inSync := status.State == dev.expected
+			status.InSync = &inSync
+		}
+		s.Devices[dev.kind] = status
+	}
+	return s
+}
+
+// statusRefreshInterval is how often the status snapshot is collected.
+const statusRefreshInterval = 5 * time.Second
+
+// statusSnapshot is the state of the doors and door groups last collected by pollStatus.
+type statusSnapshot struct {
+	Doors  []doorStatus  `json:"doors"`
+	Groups []groupStatus `json:"groups,omitempty"`
+	// Updated is when the state was collected.
+	Updated time.Time `json:"updated"`
+	err     error
+}
+
+var (
+	// lastStatus is the snapshot served by /status and the admin API, nil until the first collection.
+	lastStatus atomic.Pointer[statusSnapshot]
+	// statusRefresh asks pollStatus to collect the state now, e.g. after a door was controlled.
+	statusRefresh = make(chan struct{}, 1)
+)
+
+// refreshStatus collects the state of every door and door group into the snapshot.
+func refreshStatus() {
+	cfg := getAppConfig()
+	doors, err := collectDoorStatus(cfg)
+	if err != nil {
+		logger.Error("Failed to collect door status", slog.String("err", err.Error()))
+		lastStatus.Store(&statusSnapshot{Updated: time.Now(), err: err})
+		return
+	}
+	lastStatus.Store(&statusSnapshot{Doors: doors, Groups: collectGroupStatus(cfg, doors), Updated: time.Now()})
+}
+
+// requestStatusRefresh makes pollStatus collect the state without waiting for the next interval.
+func requestStatusRefresh() {
+	select {
+	case statusRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// pollStatus keeps the status snapshot up to date until ctx is cancelled, so the number of
+// clients watching the status does not add load on UAC and the backends.
+func pollStatus(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	refreshStatus()
+	ticker := time.NewTicker(statusRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-statusRefresh:
+		}
+		refreshStatus()
+	}
+}
+
+// currentStatus returns the last snapshot with the activity of the doors as of now, or an error
+// when none was collected yet or UAC could not be queried.
+func currentStatus() (*statusSnapshot, error) {
+	snapshot := lastStatus.Load()
+	if snapshot == nil {
+		return nil, errors.New("the door status has not been collected yet")
+	}
+	if snapshot.err != nil {
+		return nil, snapshot.err
+	}
+	current := *snapshot
+	current.Doors = slices.Clone(snapshot.Doors)
+	for i := range current.Doors {
+		current.Doors[i].doorActivity = doorActivityOf(current.Doors[i].UacID)
+	}
+	return &current, nil
+}
+
+// handleStatus reports the state of every door and door group as JSON.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := currentStatus()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+const (
+	// streamBuffer is the number of events queued for a slow stream client before events are dropped.
+	streamBuffer = 64
+	// streamKeepAlive is how often a comment is sent on an idle stream so proxies keep it open.
+	streamKeepAlive = 15 * time.Second
+	// streamTicketTTL is how long a stream ticket can be used to connect.
+	streamTicketTTL = 30 * time.Second
+)
+
+// streamsClosed is closed when the server shuts down to end the open event streams.
+var (
+	streamsClosed    = make(chan struct{})
+	closeStreamsOnce sync.Once
+)
+
+// closeEventStreams ends the open event streams, which would otherwise hold up the server shutdown.
+func closeEventStreams() {
+	closeStreamsOnce.Do(func() { close(streamsClosed) })
+}
+
+// streamTickets are the unused stream tickets by ticket with their expiry. Browsers can't set
+// headers on an EventSource, so instead of the admin token, which would end up in proxy and
+// access logs, they connect with a ticket that can be used once within streamTicketTTL.
+var streamTickets = struct {
+	sync.Mutex
+	expires map[string]time.Time
+}{expires: make(map[string]time.Time)}
+
+// issueStreamTicket returns a new stream ticket and when it expires.
+func issueStreamTicket() (string, time.Time, error) {
+	ticket, err := utils.RandomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires := now.Add(streamTicketTTL)
+	streamTickets.Lock()
+	defer streamTickets.Unlock()
+	for t, exp := range streamTickets.expires {
+		if now.After(exp) {
+			delete(streamTickets.expires, t)
+		}
+	}
+	streamTickets.expires[ticket] = expires
+	return ticket, expires, nil
+}
+
+// redeemStreamTicket reports whether ticket is valid, and invalidates it.
+func redeemStreamTicket(ticket string) bool {
+	streamTickets.Lock()
+	defer streamTickets.Unlock()
+	expires, ok := streamTickets.expires[ticket]
+	delete(streamTickets.expires, ticket)
+	return ok && time.Now().Before(expires)
+}
+
+// handleStreamTicket issues a ticket to connect to the event stream with ?ticket=.
+func handleStreamTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, expires, err := issueStreamTicket()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Ticket  string    `json:"ticket"`
+		Expires time.Time `json:"expires"`
+	}{ticket, expires})
+}
+
+// newEventStream creates the handler of the Server-Sent Events stream. It takes the admin token
+// from the Authorization header, or a stream ticket from the ticket query parameter.
+func newEventStream() http.Handler {
+	withToken := requireAdminToken(http.HandlerFunc(handleEventStream), bearerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		if ticket == "" {
+			withToken.ServeHTTP(w, r)
+			return
+		}
+		if !redeemStreamTicket(ticket) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid or expired stream ticket")
+			return
+		}
+		handleEventStream(w, r)
+	})
+}
+
+// eventFilter selects the events sent on a stream. Empty sets match everything.
+type eventFilter struct {
+	doors map[string]bool // UAC IDs
+	kinds map[string]bool
+}
+
+func (f eventFilter) matches(e events.Event) bool {
+	return (len(f.doors) == 0 || f.doors[e.DoorID]) && (len(f.kinds) == 0 || f.kinds[e.Type])
+}
+
+// parseEventFilter reads the comma separated door and kind query parameters.
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	f := eventFilter{doors: make(map[string]bool), kinds: make(map[string]bool)}
+	for _, ref := range splitList(r.URL.Query()["door"]) {
+		door, found := findDoor(ref)
+		if !found {
+			return f, fmt.Errorf("door %q not found", ref)
+		}
+		f.doors[door.UacID] = true
+	}
+	for _, kind := range splitList(r.URL.Query()["kind"]) {
+		f.kinds[kind] = true
+	}
+	return f, nil
+}
+
+// splitList splits repeated and comma separated query values.
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// handleEventStream sends the door events as they are published. A client reconnecting with
+// Last-Event-ID first receives the events it missed that are still kept in memory.
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	var lastID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	// streams outlive the server write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// subscribe before replaying so no event falls in between
+	ch, cancel := eventBus.Subscribe(streamBuffer)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e events.Event) error {
+		if e.ID <= lastID || !filter.matches(e) {
+			return nil
+		}
+		lastID = e.ID
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if lastID > 0 {
+		recent := eventBus.Recent(0)
+		if len(recent) == 0 || recent[len(recent)-1].ID < lastID {
+			// the IDs started over after a restart without a journal
+			lastID = 0
+		}
+		for _, e := range recent {
+			if err := send(e); err != nil {
+				return
+			}
+		}
+	}
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-ch:
+			if err := send(e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-streamsClosed:
+			return
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/homeassistant"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// stateFileName is the name of the state store in the state directory.
+const stateFileName = "state.json"
+
+// openStateStore opens the state store in the configured state directory, creating the directory if needed.
+func openStateStore(cfg *config.Config) (*state.Store, error) {
+	if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return state.Open(filepath.Join(cfg.StateDir, stateFileName))
+}
+
+// upstreamClients are the clients of UAC and the configured backends. The backend clients
+// are nil when the backend is not configured.
+type upstreamClients struct {
+	// uac are the clients of the UAC controllers by name.
+	uac map[string]*uac.Client
+	// hubitat are the clients of the Hubitat hubs by name.
+	hubitat       map[string]*hubitat.Client
+	homeAssistant *homeassistant.Client
+}
+
+// newUpstreamClients creates the UAC and backend clients with the TLS options of the config.
+func newUpstreamClients(cfg *config.Config, store *state.Store) (*upstreamClients, error) {
+	clients := &upstreamClients{uac: make(map[string]*uac.Client), hubitat: make(map[string]*hubitat.Client)}
+	for name, controller := range cfg.Controllers() {
+		uacTLS, err := clientTLSConfig(uacUpstreamName(name), controller.TLS, store)
+		if err != nil {
+			return nil, err
+		}
+		clients.uac[name] = uac.NewClient(controller.BaseURL, controller.APIKey, uacTLS)
+	}
+	for name, hub := range cfg.Hubs() {
+		hubitatTLS, err := clientTLSConfig(config.HubitatInstance(name), hub.TLS, store)
+		if err != nil {
+			return nil, err
+		}
+		clients.hubitat[name] = hubitat.NewClient(hub.BaseURL, hub.AccessToken, hubitatTLS)
+	}
+	if cfg.HomeAssistant != nil {
+		haTLS, err := clientTLSConfig("home_assistant", cfg.HomeAssistant.TLS, store)
+		if err != nil {
+			return nil, err
+		}
+		clients.homeAssistant = homeassistant.NewClient(cfg.HomeAssistant.BaseURL, cfg.HomeAssistant.Token, haTLS)
+	}
+	return clients, nil
+}
+
+// uacUpstreamName returns the name of a UAC controller in metrics, readiness checks and
+// pinned keys: "uac" for the default controller and uac_<name> for the others.
+func uacUpstreamName(controller string) string {
+	if controller == config.DefaultUACController {
+		return "uac"
+	}
+	return "uac_" + controller
+}
+
+// explainCertificateError adds how to trust the certificate of an upstream to err when it
+// failed the certificate verification. section names the config section of the upstream.
+// Earlier versions accepted any certificate, so an upgraded install using the self-signed
+// certificate of UniFi Access fails here until one of the tls options is set.
+func explainCertificateError(section string, err error) error {
+	var verifyErr *tls.CertificateVerificationError
+	if !errors.As(err, &verifyErr) {
+		return err
+	}
+	return fmt.Errorf("%w; the certificate is not trusted, which is expected for the self-signed certificate of "+
+		"UniFi Access: set tls.pin_sha256, tls.trust_on_first_use or tls.ca_file in %s, or tls.insecure_skip_verify "+
+		"to accept any certificate as earlier versions did (see Upstream Certificates in the README)", err, section)
+}
+
+// uacSection names the config section of a UAC controller.
+func uacSection(controller string) string {
+	if controller == config.DefaultUACController {
+		return "the uac section"
+	}
+	return fmt.Sprintf("uac_controllers entry %s", controller)
+}
+
+// hubitatSection names the config section of a Hubitat hub.
+func hubitatSection(hub string) string {
+	if hub == config.DefaultHubitatHub {
+		return "the hubitat section"
+	}
+	return fmt.Sprintf("hubitat_hubs entry %s", hub)
+}
+
+// pinStateKey is the state store key of the public key trusted on first use for an upstream.
+func pinStateKey(upstream string) string {
+	return "tls_pin/" + upstream
+}
+
+// fingerprint returns the SHA-256 of data in hex.
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// matchesPin reports whether the certificate or its public key has one of the pinned fingerprints.
+func matchesPin(cert *x509.Certificate, pins [][]byte) bool {
+	certSum := sha256.Sum256(cert.Raw)
+	keySum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(pin, certSum[:]) || bytes.Equal(pin, keySum[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientTLSConfig builds the TLS config used to connect to an upstream. Without options the
+// certificate is verified against the system CAs. Pinned certificates replace the CA check
+// unless a CA file is given too, in which case both have to pass. The public key trusted on
+// first use is recorded in store under the upstream name.
+func clientTLSConfig(upstream string, opts *config.ClientTLS, store *state.Store) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts == nil {
+		return tlsConfig, nil
+	}
+
+	if opts.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification is disabled, the connection can be intercepted",
+			slog.String("upstream", upstream))
+		tlsConfig.InsecureSkipVerify = true
+		return tlsConfig, nil
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s CA file: %w", upstream, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s CA file %s", upstream, opts.CAFile)
+		}
+	}
+
+	var pins [][]byte
+	for _, pin := range opts.PinSHA256 {
+		fp, err := config.ParseFingerprint(pin)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, fp)
+	}
+
+	var tofu *firstUseTrust
+	if opts.TrustOnFirstUse {
+		if store == nil {
+			return nil, fmt.Errorf("%s: trust on first use requires a state directory", upstream)
+		}
+		tofu = &firstUseTrust{upstream: upstream, store: store}
+		var pin string
+		if found, err := store.Get(pinStateKey(upstream), &pin); err != nil {
+			return nil, err
+		} else if found {
+			fp, err := config.ParseFingerprint(pin)
+			if err != nil {
+				return nil, fmt.Errorf("state %s: %w", pinStateKey(upstream), err)
+			}
+			tofu.pin = fp
+		}
+	}
+
+	if len(pins) == 0 && tofu == nil {
+		return tlsConfig, nil
+	}
+
+	// the default verification is replaced by VerifyConnection, which checks the CA chain
+	// itself when a CA file is given
+	roots := tlsConfig.RootCAs
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no certificate presented")
+		}
+		if roots != nil {
+			if err := verifyChain(cs, roots); err != nil {
+				return err
+			}
+		}
+		if len(pins) > 0 && !chainMatchesPin(cs, pins) {
+			return fmt.Errorf("certificate %s does not match any pinned fingerprint",
+				fingerprint(cs.PeerCertificates[0].RawSubjectPublicKeyInfo))
+		}
+		if tofu != nil {
+			return tofu.verify(cs.PeerCertificates[0])
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
+// chainMatchesPin reports whether the server certificate matches a pin, or was issued by a pinned
+// CA the server presented. Presenting a pinned CA is not enough: the server certificate has to
+// verify with that CA as the only root, otherwise any certificate could be sent along with the
+// public certificate of the CA.
+func chainMatchesPin(cs tls.ConnectionState, pins [][]byte) bool {
+	if matchesPin(cs.PeerCertificates[0], pins) {
+		return true
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		if !matchesPin(cert, pins) {
+			continue
+		}
+		root := x509.NewCertPool()
+		root.AddCert(cert)
+		if verifyChain(cs, root) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyChain verifies the peer certificate chain against roots and the server name.
+func verifyChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// firstUseTrust pins the public key of the first certificate seen for an upstream.
+type firstUseTrust struct {
+	mu       sync.Mutex
+	upstream string
+	store    *state.Store
+	pin      []byte
+}
+
+// verify checks the certificate against the recorded public key, recording it when none is yet.
+func (t *firstUseTrust) verify(cert *x509.Certificate) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pin != nil {
+		if !matchesPin(cert, [][]byte{t.pin}) {
+			return fmt.Errorf("certificate public key %s differs from the one trusted on first use, remove %s from the state file if the change is expected",
+				fingerprint(cert.RawSubjectPublicKeyInfo), pinStateKey(t.upstream))
+		}
+		return nil
+	}
+
+	pin := fingerprint(cert.RawSubjectPublicKeyInfo)
+	if err := t.store.Set(pinStateKey(t.upstream), pin); err != nil {
+		return fmt.Errorf("failed to record trusted certificate: %w", err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	t.pin = sum[:]
+	msg := "Trusting certificate on first use"
+	if t.store.ReadOnly() {
+		msg = "Certificate would be trusted on first use, it is not recorded"
+	}
+	logger.Warn(msg,
+		slog.String("upstream", t.upstream),
+		slog.String("subject", cert.Subject.String()),
+		slog.String("pin_sha256", pin))
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+)
+
+// testCert is a certificate with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for 127.0.0.1, issued by parent or self-signed when parent is nil.
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	issuer, signer := tmpl, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// serveTLS starts a server presenting leaf followed by chain.
+func serveTLS(t *testing.T, leaf *testCert, chain ...*testCert) *httptest.Server {
+	t.Helper()
+	presented := tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	for _, c := range chain {
+		presented.Certificate = append(presented.Certificate, c.cert.Raw)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{presented}}
+	// rejected handshakes are expected
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// connect makes a request to srv with the TLS config of opts.
+func connect(t *testing.T, srv *httptest.Server, opts *config.ClientTLS, store *state.Store) error {
+	t.Helper()
+	tlsConfig, err := clientTLSConfig("uac", opts, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestPinnedCertificates(t *testing.T) {
+	logger = slog.New(slog.DiscardHandler)
+	ca := newTestCert(t, "Pinned CA", true, nil)
+	leaf := newTestCert(t, "uac", false, ca)
+	foreign := newTestCert(t, "attacker", false, nil)
+	keyPin := fingerprint(leaf.cert.RawSubjectPublicKeyInfo)
+	caPin := fingerprint(ca.cert.Raw)
+
+	tests := []struct {
+		name    string
+		srv     *httptest.Server
+		pins    []string
+		trusted bool
+	}{
+		{"public key of the leaf", serveTLS(t, leaf), []string{keyPin}, true},
+		{"certificate of the leaf", serveTLS(t, leaf), []string{fingerprint(leaf.cert.Raw)}, true},
+		{"mismatch", serveTLS(t, foreign), []string{keyPin}, false},
+		{"CA that issued the leaf", serveTLS(t, leaf, ca), []string{caPin}, true},
+		// the public CA certificate sent along with any certificate must not pass
+		{"CA appended after a foreign leaf", serveTLS(t, foreign, ca), []string{caPin}, false},
+		{"CA that is not sent", serveTLS(t, leaf), []string{caPin}, false},
+	}
+	for _, tt := range tests {
+		err := connect(t, tt.srv, &config.ClientTLS{PinSHA256: tt.pins}, nil)
+		if tt.trusted && err != nil {
+			t.Errorf("%s: connection failed: %v", tt.name, err)
+		}
+		if !tt.trusted && (err == nil || !strings.Contains(err.Error(), "does not match any pinned fingerprint")) {
+			t.Errorf("%s: connection = %v, want the certificate rejected", tt.name, err)
+		}
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	logger = slog.New(slog.DiscardHandler)
+	store, err := state.Open(filepath.Join(t.TempDir(), stateFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := newTestCert(t, "uac", false, nil)
+	opts := &config.ClientTLS{TrustOnFirstUse: true}
+
+	if err := connect(t, serveTLS(t, first), opts, store); err != nil {
+		t.Fatalf("first connection failed: %v", err)
+	}
+	var pin string
+	if found, err := store.Get(pinStateKey("uac"), &pin); !found || err != nil || pin != fingerprint(first.cert.RawSubjectPublicKeyInfo) {
+		t.Fatalf("recorded pin = %q, %t, %v, want the public key of the first certificate", pin, found, err)
+	}
+
+	// a new client reads the recorded pin
+	if err := connect(t, serveTLS(t, first), opts, store); err != nil {
+		t.Errorf("connection with the recorded certificate failed: %v", err)
+	}
+	changed := newTestCert(t, "uac", false, nil)
+	if err := connect(t, serveTLS(t, changed), opts, store); err == nil || !strings.Contains(err.Error(), "differs from the one trusted on first use") {
+		t.Errorf("connection with a changed certificate = %v, want it rejected", err)
+	}
+	if _, err := store.Get(pinStateKey("uac"), &pin); err != nil || pin != fingerprint(first.cert.RawSubjectPublicKeyInfo) {
+		t.Errorf("recorded pin = %q after a changed certificate, want the first one kept", pin)
+	}
+}
+
+func TestTrustOnFirstUseReadOnly(t *testing.T) {
+	logger = slog.New(slog.DiscardHandler)
+	path := filepath.Join(t.TempDir(), stateFileName)
+	store, err := state.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := newTestCert(t, "uac", false, nil)
+	opts := &config.ClientTLS{TrustOnFirstUse: true}
+
+	// validate accepts the certificate for the run without pinning it
+	if err := connect(t, serveTLS(t, first), opts, store); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state file written by a read-only store: %v", err)
+	}
+	changed := newTestCert(t, "uac", false, nil)
+	if err := connect(t, serveTLS(t, changed), opts, store); err == nil {
+		t.Error("connection with a changed certificate succeeded in the same run")
+	}
+
+	// a recorded pin is still enforced
+	saved, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.Set(pinStateKey("uac"), fingerprint(changed.cert.RawSubjectPublicKeyInfo)); err != nil {
+		t.Fatal(err)
+	}
+	store, err = state.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := connect(t, serveTLS(t, first), opts, store); err == nil || !strings.Contains(err.Error(), "differs from the one trusted on first use") {
+		t.Errorf("connection with a certificate other than the recorded one = %v, want it rejected", err)
+	}
+}
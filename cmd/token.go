@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+// tokenBytes is the number of random bytes in a generated token.
+const tokenBytes = 32
+
+// generateTokens returns n new random tokens.
+func generateTokens(n int) ([]string, error) {
+	tokens := make([]string, n)
+	for i := range tokens {
+		token, err := utils.RandomToken(tokenBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token: %w", err)
+		}
+		tokens[i] = token
+	}
+	return tokens, nil
+}
+
+// runToken implements the "token" command which prints new random tokens, one per line.
+func runToken(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	count := fs.Int("n", 1, "number of tokens to generate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tokens, err := generateTokens(*count)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		fmt.Fprintln(os.Stdout, token)
+	}
+	return nil
+}
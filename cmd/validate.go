@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/cmd/config"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/hubitat"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/state"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/uac"
+)
+
+// doorProblems holds the validation errors of a single configured door.
+type doorProblems struct {
+	index  int
+	door   config.Door
+	errors []error
+}
+
+func (p doorProblems) Error() string {
+	return fmt.Sprintf("doors[%d] (%s): %s", p.index, p.door.Name(), errors.Join(p.errors...))
+}
+
+// validateUpstream resolves door names to IDs and verifies every configured door against the
+// live UAC API and the API of its backend. It returns the problems of each invalid door, the
+// errors of the UAC controllers that could not be queried, whose doors referenced by ID are kept
+// unverified, and an error when Hubitat could not be queried at all, a door group is invalid or
+// the resolved config maps a door or device twice.
+func validateUpstream(cfg *config.Config, clients *upstreamClients) ([]doorProblems, map[string]error, error) {
+	uacDoors := make(map[string][]uac.Door, len(clients.uac))
+	known := make(map[string]map[string]bool, len(clients.uac))
+	unreachable := make(map[string]error)
+	for name, client := range clients.uac {
+		doors, err := client.FetchAllDoors()
+		if err != nil {
+			unreachable[name] = fmt.Errorf("failed to fetch UAC doors of %s: %w", uacUpstreamName(name), explainCertificateError(uacSection(name), err))
+			continue
+		}
+		uacDoors[name] = doors
+		known[name] = make(map[string]bool, len(doors))
+		for _, d := range doors {
+			known[name][d.ID] = true
+		}
+	}
+
+	uacGroups := make(map[string][]uac.DoorGroup)
+	for _, g := range cfg.DoorGroups {
+		client, running := clients.uac[g.Controller()]
+		if g.UACGroup == "" || !running || uacGroups[g.Controller()] != nil || unreachable[g.Controller()] != nil {
+			continue
+		}
+		groups, err := client.FetchDoorGroups()
+		if err != nil {
+			unreachable[g.Controller()] = fmt.Errorf("failed to fetch UAC door groups of %s: %w", uacUpstreamName(g.Controller()), err)
+			continue
+		}
+		uacGroups[g.Controller()] = groups
+	}
+
+	devices := make(map[string][]hubitat.Device, len(clients.hubitat))
+	for name, client := range clients.hubitat {
+		if !usesDeviceLabels(cfg, name) {
+			continue
+		}
+		list, err := client.ListDevices()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch Hubitat devices of %s: %w", config.HubitatInstance(name), explainCertificateError(hubitatSection(name), err))
+		}
+		devices[name] = list
+	}
+
+	doorBackends := newBackends(clients)
+	var problems []doorProblems
+	for i := range cfg.Doors {
+		door := &cfg.Doors[i]
+		var errs []error
+		if _, running := clients.uac[door.Controller()]; !running {
+			// controllers are only added on restart
+			errs = append(errs, fmt.Errorf("uac_controller %s is not running, restart to add it", door.Controller()))
+		} else if known[door.Controller()] == nil {
+			// the controller is unreachable, a door referenced by ID is kept until it can be verified
+			if door.UacID == "" {
+				errs = append(errs, fmt.Errorf("uac_name %q can't be resolved while UAC controller %s is unreachable", door.UacName, door.Controller()))
+			}
+			errs = append(errs, resolveDeviceLabels(door, devices[door.Hub()])...)
+		} else {
+			errs = resolveDoor(door, uacDoors[door.Controller()], devices[door.Hub()])
+			if door.UacID != "" && !known[door.Controller()][door.UacID] {
+				errs = append(errs, fmt.Errorf("uac_id %s does not exist in UniFi Access controller %s", door.UacID, door.Controller()))
+			}
+		}
+		if backend, ok := doorBackends[door.BackendInstance()]; ok {
+			errs = append(errs, verifyDevices(door, backend)...)
+		} else if door.BackendName() == config.BackendHubitat {
+			// hubs are only added on restart
+			errs = append(errs, fmt.Errorf("hubitat_hub %s is not running, restart to add it", door.Hub()))
+		} else {
+			errs = append(errs, fmt.Errorf("backend %s is not configured", door.BackendName()))
+		}
+		if len(errs) > 0 {
+			problems = append(problems, doorProblems{index: i, door: *door, errors: errs})
+		}
+	}
+
+	// groups are resolved against the doors, an invalid group is never skipped
+	var errs []error
+	for i := range cfg.DoorGroups {
+		g := &cfg.DoorGroups[i]
+		var groupErrs []error
+		if _, running := clients.uac[g.Controller()]; g.UACGroup != "" && !running {
+			groupErrs = append(groupErrs, fmt.Errorf("uac_controller %s is not running, restart to add it", g.Controller()))
+		}
+		groupErrs = append(groupErrs, resolveGroup(cfg, g, uacGroups[g.Controller()], unreachable[g.Controller()] == nil, devices[g.Hub()])...)
+		if backend, ok := doorBackends[config.HubitatInstance(g.Hub())]; ok {
+			for _, dev := range []struct{ kind, field, id string }{
+				{"lock", "hubitat_lock_id", g.HubitatLockID}, {"switch", "hubitat_switch_id", g.HubitatSwitchID},
+			} {
+				if dev.id == "" {
+					continue
+				}
+				if err := backend.VerifyDevice(dev.kind, dev.id); err != nil {
+					groupErrs = append(groupErrs, fmt.Errorf("%s: %w", dev.field, err))
+				}
+			}
+		} else {
+			groupErrs = append(groupErrs, fmt.Errorf("hubitat_hub %s is not running, restart to add it", g.Hub()))
+		}
+		if len(groupErrs) > 0 {
+			errs = append(errs, fmt.Errorf("door_groups[%d] (%s): %w", i, g.Name, errors.Join(groupErrs...)))
+		}
+	}
+
+	errs = append(errs, checkScheduleDoors(cfg), cfg.CheckDuplicates())
+	return problems, unreachable, errors.Join(errs...)
+}
+
+// verifyDevices checks the devices of a door in its backend.
+func verifyDevices(door *config.Door, backend deviceBackend) []error {
+	fields := map[string]string{"contact": "hubitat_contact_id", "lock": "hubitat_lock_id", "switch": "hubitat_switch_id"}
+	if door.BackendName() == config.BackendHomeAssistant {
+		fields = map[string]string{"contact": "ha_contact_entity", "lock": "ha_lock_entity", "switch": "ha_switch_entity"}
+	}
+	lockID, _ := door.LockID()
+
+	var errs []error
+	for _, dev := range []struct{ kind, id string }{{"contact", door.ContactID()}, {"lock", lockID}, {"switch", door.SwitchID()}} {
+		if dev.id == "" {
+			continue // unresolved label or no lock
+		}
+		if err := backend.VerifyDevice(dev.kind, dev.id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", fields[dev.kind], err))
+		}
+	}
+	return errs
+}
+
+// usesDeviceLabels reports whether any door or door group on a Hubitat hub references a device by label.
+func usesDeviceLabels(cfg *config.Config, hub string) bool {
+	for _, g := range cfg.DoorGroups {
+		if g.Hub() == hub && (g.HubitatLockLabel != "" || g.HubitatSwitchLabel != "") {
+			return true
+		}
+	}
+	for _, d := range cfg.Doors {
+		if d.BackendName() != config.BackendHubitat || d.Hub() != hub {
+			continue
+		}
+		if d.HubitatContactLabel != "" || d.HubitatLockLabel != "" || d.HubitatSwitchLabel != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDoor fills in the IDs of a door that references the UAC door or Hubitat devices by name.
+func resolveDoor(door *config.Door, uacDoors []uac.Door, devices []hubitat.Device) []error {
+	var errs []error
+
+	if door.UacID == "" && door.UacName != "" {
+		var matches []string
+		for _, d := range uacDoors {
+			if strings.EqualFold(d.Name, door.UacName) || strings.EqualFold(d.FullName, door.UacName) {
+				matches = append(matches, d.ID)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			errs = append(errs, fmt.Errorf("uac_name %q does not match any UniFi Access door", door.UacName))
+		case 1:
+			door.UacID = matches[0]
+		default:
+			errs = append(errs, fmt.Errorf("uac_name %q matches %d UniFi Access doors", door.UacName, len(matches)))
+		}
+	}
+
+	return append(errs, resolveDeviceLabels(door, devices)...)
+}
+
+// resolveDeviceLabels fills in the IDs of the Hubitat devices a door references by label.
+func resolveDeviceLabels(door *config.Door, devices []hubitat.Device) []error {
+	var errs []error
+	resolveLabel := func(field, label string) string {
+		id, err := resolveDeviceLabel(devices, field, label)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return id
+	}
+
+	if door.HubitatContactID == "" && door.HubitatContactLabel != "" {
+		door.HubitatContactID = resolveLabel("hubitat_contact_label", door.HubitatContactLabel)
+	}
+	if door.HubitatLockID == nil && door.HubitatLockLabel != "" {
+		if id := resolveLabel("hubitat_lock_label", door.HubitatLockLabel); id != "" {
+			door.HubitatLockID = &id
+		}
+	}
+	if door.HubitatSwitchID == "" && door.HubitatSwitchLabel != "" {
+		door.HubitatSwitchID = resolveLabel("hubitat_switch_label", door.HubitatSwitchLabel)
+	}
+
+	return errs
+}
+
+// resolveDeviceLabel returns the ID of the only Hubitat device with the label, field is the config field naming it.
+func resolveDeviceLabel(devices []hubitat.Device, field, label string) (string, error) {
+	var matches []string
+	for _, dev := range devices {
+		if strings.EqualFold(dev.DisplayName(), label) {
+			matches = append(matches, dev.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%s %q does not match any Hubitat device", field, label)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s %q matches %d Hubitat devices", field, label, len(matches))
+	}
+}
+
+// disableDoors removes the doors with problems from the config.
+func disableDoors(cfg *config.Config, problems []doorProblems) {
+	bad := make(map[int]bool, len(problems))
+	for _, p := range problems {
+		bad[p.index] = true
+	}
+	doors := make([]config.Door, 0, len(cfg.Doors))
+	for i, d := range cfg.Doors {
+		if !bad[i] {
+			doors = append(doors, d)
+		}
+	}
+	cfg.Doors = doors
+}
+
+// runValidate implements the "validate" command which checks the config file
+// and the configured doors and devices, printing every problem found.
+func runValidate(configPath string) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// certificates trusted on first use are checked against the recorded ones, but a
+	// certificate seen for the first time is only pinned once the app connects
+	store, err := state.OpenReadOnly(filepath.Join(cfg.StateDir, stateFileName))
+	if err != nil {
+		return err
+	}
+	clients, err := newUpstreamClients(cfg, store)
+	if err != nil {
+		return err
+	}
+
+	problems, unreachable, err := validateUpstream(cfg, clients)
+	errs := []error{err}
+	for _, name := range slices.Sorted(maps.Keys(unreachable)) {
+		errs = append(errs, unreachable[name])
+	}
+	for _, p := range problems {
+		errs = append(errs, p)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s is valid, %d doors verified\n", configPath, len(cfg.Doors))
+	return nil
+}
+
+// logConfigErrors logs each error joined in err as a separate entry.
+func logConfigErrors(msg string, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			logger.Error(msg, slog.String("err", e.Error()))
+		}
+		return
+	}
+	logger.Error(msg, slog.String("err", err.Error()))
+}
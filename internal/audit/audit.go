@@ -0,0 +1,246 @@
+// Package audit keeps a tamper-evident log of door control actions. Every record holds the
+// hash of the record before it, so editing, removing or reordering records breaks the chain.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ActionTamperDetected is the action of a record noting that the log did not end with the head
+// recorded elsewhere when it was opened. It stays in the chain after the head moved on.
+const ActionTamperDetected = "tamper_detected"
+
+// Record is an action that affected a door, or the doors as a whole.
+type Record struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Action is what was done, e.g. "unlock", "lock" or "webhook_update".
+	Action string `json:"action"`
+	DoorID string `json:"door_id,omitempty"`
+	Door   string `json:"door,omitempty"`
+	// Source is what triggered the action: "hubitat", "api", "schedule" or "app".
+	Source string `json:"source"`
+	// Actor is who triggered the action, e.g. the API client IP.
+	Actor string `json:"actor,omitempty"`
+	// Trigger describes the event or request that caused the action.
+	Trigger string `json:"trigger,omitempty"`
+	// Result is "ok" or "error", with the error in Error.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Prev is the hash of the previous record, empty for the first one.
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// hash returns the hash of the record without its own hash.
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Head identifies the last record of the log.
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log appends records to the audit log file.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	head Head
+}
+
+// Open opens the audit log at path, creating it when it does not exist. New records are
+// chained to the last record in the file, which is not verified, see Verify. A partial record
+// left by a crash is kept, and new records start on the next line.
+func Open(path string) (*Log, error) {
+	head, err := lastHead(path)
+	if err != nil {
+		return nil, err
+	}
+	partial, err := endsPartial(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log failed: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log failed: %w", err)
+	}
+	if partial {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("writing audit log failed: %w", err)
+		}
+	}
+	return &Log{file: f, head: head}, nil
+}
+
+// endsPartial reports whether the file at path ends with a line that is not terminated.
+func endsPartial(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// lastHead returns the head of the last complete record in the file.
+func lastHead(path string) (Head, error) {
+	var head Head
+	err := scan(path, func(_ int, r Record) error {
+		head = Head{Seq: r.Seq, Hash: r.Hash}
+		return nil
+	}, true)
+	if errors.Is(err, os.ErrNotExist) {
+		return Head{}, nil
+	}
+	return head, err
+}
+
+// Head returns the head of the log.
+func (l *Log) Head() Head {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Append chains the record to the log, filling in its sequence number, time and hashes,
+// and syncs it to disk.
+func (l *Log) Append(r Record) (Head, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.Seq = l.head.Seq + 1
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	r.Prev = l.head.Hash
+	hash, err := r.hash()
+	if err != nil {
+		return l.head, err
+	}
+	r.Hash = hash
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return l.head, err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return l.head, fmt.Errorf("writing audit log failed: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return l.head, fmt.Errorf("syncing audit log failed: %w", err)
+	}
+	l.head = Head{Seq: r.Seq, Hash: r.Hash}
+	return l.head, nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the chain of the audit log at path and returns its head. Records that were
+// changed, removed, added or reordered are reported with their line number. Records removed
+// from the end can only be detected by comparing the head with one kept elsewhere.
+func Verify(path string) (Head, error) {
+	var head Head
+	err := scan(path, func(line int, r Record) error {
+		if r.Seq != head.Seq+1 {
+			return fmt.Errorf("line %d: sequence number %d follows %d", line, r.Seq, head.Seq)
+		}
+		if r.Prev != head.Hash {
+			return fmt.Errorf("line %d: record %d does not follow the previous record", line, r.Seq)
+		}
+		hash, err := r.hash()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != r.Hash {
+			return fmt.Errorf("line %d: record %d was modified", line, r.Seq)
+		}
+		head = Head{Seq: r.Seq, Hash: r.Hash}
+		return nil
+	}, false)
+	return head, err
+}
+
+// TamperRecords returns the records of the log at path noting that it was found modified, see
+// ActionTamperDetected.
+func TamperRecords(path string) ([]Record, error) {
+	var records []Record
+	err := scan(path, func(_ int, r Record) error {
+		if r.Action == ActionTamperDetected {
+			records = append(records, r)
+		}
+		return nil
+	}, true)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return records, err
+}
+
+// scan calls fn with every record of the log. With lenient, lines that can't be decoded are
+// skipped instead of failing.
+func scan(path string, fn func(line int, r Record) error, lenient bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 && !lenient {
+				return fmt.Errorf("line %d: incomplete record", line)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var r Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			if lenient {
+				continue
+			}
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(line, r); err != nil {
+			return err
+		}
+	}
+}
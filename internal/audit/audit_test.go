@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog appends n records to a new log and returns its path and lines.
+func writeLog(t *testing.T, n int) (string, [][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := l.Append(Record{Action: "unlock", DoorID: "d1", Source: "api", Result: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, bytes.SplitAfter(data, []byte("\n"))[:n]
+}
+
+func TestVerify(t *testing.T) {
+	path, lines := writeLog(t, 3)
+	head, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify of an intact log failed: %v", err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("head = %+v, want record 3", head)
+	}
+
+	edited := bytes.Replace(lines[1], []byte(`"unlock"`), []byte(`"lock"`), 1)
+	tests := []struct {
+		name  string
+		lines [][]byte
+		err   string
+	}{
+		{"edited", [][]byte{lines[0], edited, lines[2]}, "line 2: record 2 was modified"},
+		{"removed", [][]byte{lines[0], lines[2]}, "line 2: sequence number 3 follows 1"},
+		{"reordered", [][]byte{lines[1], lines[0], lines[2]}, "line 1: sequence number 2 follows 0"},
+		{"half-written", [][]byte{lines[0], lines[1], lines[2][:len(lines[2])/2]}, "line 3: incomplete record"},
+		{"not a record", [][]byte{lines[0], []byte("garbage\n"), lines[2]}, "line 2:"},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, bytes.Join(tt.lines, nil), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Verify = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestOpenAfterPartialRecord(t *testing.T) {
+	path, lines := writeLog(t, 2)
+	// a crash while writing the third record leaves half of it
+	partial := append(bytes.Join(lines, nil), lines[1][:len(lines[1])/2]...)
+	if err := os.WriteFile(path, partial, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want, err := lastHead(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Seq != 2 {
+		t.Fatalf("lastHead = %+v, want record 2", want)
+	}
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Head() != want {
+		t.Errorf("Head = %+v, want %+v", l.Head(), want)
+	}
+	head, err := l.Append(Record{Action: "lock", Source: "api", Result: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("appended head = %+v, want record 3", head)
+	}
+	// the record appended after the partial one is found again
+	if got, err := lastHead(path); err != nil || got != head {
+		t.Errorf("lastHead after appending = %+v, %v, want %+v", got, err, head)
+	}
+}
+
+func TestLastHeadMissingLog(t *testing.T) {
+	head, err := lastHead(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil || head != (Head{}) {
+		t.Errorf("lastHead = %+v, %v, want an empty head", head, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package events distributes door events to the parts of the app that record or forward
+// them, and keeps the most recent ones in memory.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened to a door, reported by UAC or Hubitat or done by the app.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// Source is where the event came from: "uac", "hubitat", "home_assistant", "api", "mqtt", "homekit",
+	// "schedule" or "app".
+	Source string `json:"source"`
+	// Type is what happened, e.g. "unlock", "access_denied", "position", "lock_rule", "command" or
+	// "upstream_down", which like "emergency" may not be about a door.
+	Type string `json:"type"`
+	// DoorID is the UAC ID of the door and Door its name.
+	DoorID string `json:"door_id,omitempty"`
+	Door   string `json:"door,omitempty"`
+	// Value is the new state, e.g. "open" for a position event.
+	Value string `json:"value,omitempty"`
+	// Actor is who caused the event, e.g. the UAC user or the API client IP.
+	Actor string `json:"actor,omitempty"`
+	// Result is the outcome UAC reported for an access attempt, e.g. "Access Granted".
+	Result string `json:"result,omitempty"`
+}
+
+// Bus publishes events to its subscribers and keeps the most recent ones.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	recent      []Event
+	start       int // index of the oldest event in recent once it is full
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates a bus that keeps the last size events.
+func NewBus(size int) *Bus {
+	return &Bus{
+		recent:      make([]Event, 0, size),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// ResumeAfter makes the IDs of the events published from now on continue after lastID, e.g.
+// the last event recorded before a restart, so that IDs are not reused.
+func (b *Bus) ResumeAfter(lastID uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID = max(b.nextID, lastID)
+}
+
+// Publish assigns the event an ID, and a time when it has none, and sends it to every
+// subscriber. Subscribers that are not keeping up miss the event instead of blocking.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if len(b.recent) < cap(b.recent) {
+		b.recent = append(b.recent, e)
+	} else if cap(b.recent) > 0 {
+		b.recent[b.start] = e
+		b.start = (b.start + 1) % len(b.recent)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return e
+}
+
+// Recent returns up to limit of the most recent events, oldest first. A limit of 0 returns all kept events.
+func (b *Bus) Recent(limit int) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	all := make([]Event, 0, len(b.recent))
+	all = append(all, b.recent[b.start:]...)
+	all = append(all, b.recent[:b.start]...)
+	if limit > 0 && limit < len(all) {
+		all = all[len(all)-limit:]
+	}
+	return all
+}
+
+// Subscribe returns a channel receiving every event published from now on, and a function
+// to cancel the subscription. buffer is how many events may queue up before they are dropped.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package homeassistant drives the Home Assistant entities mirroring the doors through the
+// REST API, and follows their state changes through the WebSocket API.
+package homeassistant
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// State is the state of an entity.
+type State struct {
+	EntityID   string         `json:"entity_id"`
+	State      string         `json:"state"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// FriendlyName returns the name of the entity shown in Home Assistant, falling back to its ID.
+func (s *State) FriendlyName() string {
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return s.EntityID
+}
+
+// errNotFound is returned for entities that don't exist.
+var errNotFound = errors.New("entity not found")
+
+// RequestObserver is called after every API request with the endpoint path, where entity IDs
+// are replaced by ":id", the response status (0 when no response was received) and the duration.
+type RequestObserver func(method, endpoint string, status int, duration time.Duration)
+
+type Client struct {
+	baseURL   string
+	token     string
+	tlsConfig *tls.Config
+	client    *http.Client
+	observe   RequestObserver
+	connected atomic.Bool
+}
+
+// NewClient creates a Home Assistant API client for a long-lived access token. tlsConfig
+// controls how the certificate of Home Assistant is verified, nil verifies it against the system CAs.
+func NewClient(baseUrl string, token string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		baseURL:   strings.TrimSuffix(baseUrl, "/"),
+		token:     token,
+		tlsConfig: tlsConfig,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// SetRequestObserver sets the function called after every API request.
+func (c *Client) SetRequestObserver(observe RequestObserver) {
+	c.observe = observe
+}
+
+// request sends a request to the REST API and decodes the JSON response into out, if not nil.
+func (c *Client) request(method, path, endpoint string, body, out any) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if c.observe != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		c.observe(method, endpoint, status, time.Since(start))
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	case out != nil:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// Ping checks that the API is running and the token is accepted.
+func (c *Client) Ping() error {
+	return c.request(http.MethodGet, "/api/", "/api/", nil, nil)
+}
+
+// GetState fetches the state of an entity.
+func (c *Client) GetState(entityID string) (*State, error) {
+	var state State
+	if err := c.request(http.MethodGet, "/api/states/"+url.PathEscape(entityID), "/api/states/:id", nil, &state); err != nil {
+		return nil, fmt.Errorf("failed to get state of %s: %w", entityID, err)
+	}
+	return &state, nil
+}
+
+// callService calls a service of a domain on an entity.
+func (c *Client) callService(domain, service, entityID string) error {
+	body := map[string]string{"entity_id": entityID}
+	path := "/api/services/" + url.PathEscape(domain) + "/" + url.PathEscape(service)
+	if err := c.request(http.MethodPost, path, path, body, nil); err != nil {
+		return fmt.Errorf("failed to call %s.%s on %s: %w", domain, service, entityID, err)
+	}
+	return nil
+}
+
+// setState sets the state of an entity that is not backed by an integration, creating it
+// if needed. The attributes it already has are kept.
+func (c *Client) setState(entityID, state string, attributes map[string]any) error {
+	body := struct {
+		State      string         `json:"state"`
+		Attributes map[string]any `json:"attributes,omitempty"`
+	}{state, attributes}
+	if err := c.request(http.MethodPost, "/api/states/"+url.PathEscape(entityID), "/api/states/:id", body, nil); err != nil {
+		return fmt.Errorf("failed to set state of %s: %w", entityID, err)
+	}
+	return nil
+}
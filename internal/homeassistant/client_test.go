@@ -0,0 +1,206 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// fakeREST is a Home Assistant REST API with a set of entities that records the service calls.
+type fakeREST struct {
+	mu     sync.Mutex
+	states map[string]*State
+	calls  []string // "domain.service entity_id"
+}
+
+func newFakeREST(t *testing.T, states ...State) (*fakeREST, *Client) {
+	t.Helper()
+	f := &fakeREST{states: make(map[string]*State)}
+	for _, s := range states {
+		f.states[s.EntityID] = &s
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewClient(srv.URL+"/", testToken, nil)
+}
+
+func (f *fakeREST) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch rest, _ := strings.CutPrefix(r.URL.Path, "/api/"); {
+	case r.URL.Path == "/api/":
+		json.NewEncoder(w).Encode(map[string]string{"message": "API running."})
+	case strings.HasPrefix(rest, "states/") && r.Method == http.MethodGet:
+		s, ok := f.states[strings.TrimPrefix(rest, "states/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s)
+	case strings.HasPrefix(rest, "states/") && r.Method == http.MethodPost:
+		var s State
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.EntityID = strings.TrimPrefix(rest, "states/")
+		status := http.StatusOK
+		if _, ok := f.states[s.EntityID]; !ok {
+			status = http.StatusCreated
+		}
+		f.states[s.EntityID] = &s
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(s)
+	case strings.HasPrefix(rest, "services/") && r.Method == http.MethodPost:
+		var body struct {
+			EntityID string `json:"entity_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		domain, service, _ := strings.Cut(strings.TrimPrefix(rest, "services/"), "/")
+		f.calls = append(f.calls, domain+"."+service+" "+body.EntityID)
+		json.NewEncoder(w).Encode([]State{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeREST) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeREST) state(entityID string) *State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.states[entityID]
+}
+
+func TestAssertCallsServiceOnlyWhenStateDiffers(t *testing.T) {
+	tests := []struct {
+		name   string
+		entity State
+		assert func(c *Client, entityID string) error
+		want   string // the service call, empty for none
+	}{
+		{"lock already locked", State{EntityID: "lock.front", State: "locked"},
+			func(c *Client, id string) error { return c.AssertLock(id, true) }, ""},
+		{"lock unlocked", State{EntityID: "lock.front", State: "locked"},
+			func(c *Client, id string) error { return c.AssertLock(id, false) }, "lock.unlock lock.front"},
+		{"lock locked", State{EntityID: "lock.front", State: "unlocked"},
+			func(c *Client, id string) error { return c.AssertLock(id, true) }, "lock.lock lock.front"},
+		{"input_boolean lock already locked", State{EntityID: "input_boolean.front_lock", State: "on"},
+			func(c *Client, id string) error { return c.AssertLock(id, true) }, ""},
+		{"input_boolean lock unlocked", State{EntityID: "input_boolean.front_lock", State: "on"},
+			func(c *Client, id string) error { return c.AssertLock(id, false) }, "input_boolean.turn_off input_boolean.front_lock"},
+		{"switch on", State{EntityID: "switch.front", State: "off"},
+			func(c *Client, id string) error { return c.AssertSwitch(id, true) }, "switch.turn_on switch.front"},
+		{"switch already off", State{EntityID: "switch.front", State: "off"},
+			func(c *Client, id string) error { return c.AssertSwitch(id, false) }, ""},
+		{"input_boolean contact open", State{EntityID: "input_boolean.front_contact", State: "off"},
+			func(c *Client, id string) error { return c.AssertContact(id, true) }, "input_boolean.turn_on input_boolean.front_contact"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := newFakeREST(t, tt.entity)
+			if err := tt.assert(c, tt.entity.EntityID); err != nil {
+				t.Fatalf("assert: %v", err)
+			}
+			calls := f.takeCalls()
+			switch {
+			case tt.want == "" && len(calls) > 0:
+				t.Errorf("calls = %q, want none", calls)
+			case tt.want != "" && (len(calls) != 1 || calls[0] != tt.want):
+				t.Errorf("calls = %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertContactCreatesBinarySensor(t *testing.T) {
+	f, c := newFakeREST(t)
+	if err := c.AssertContact("binary_sensor.front_door", true); err != nil {
+		t.Fatalf("AssertContact() of a new binary_sensor: %v", err)
+	}
+	s := f.state("binary_sensor.front_door")
+	if s == nil || s.State != "on" || s.Attributes["device_class"] != "door" {
+		t.Fatalf("created state = %+v, want on with device_class door", s)
+	}
+
+	// the attributes set in Home Assistant since are kept
+	s.Attributes["friendly_name"] = "Front Door"
+	if err := c.AssertContact("binary_sensor.front_door", false); err != nil {
+		t.Fatalf("AssertContact(): %v", err)
+	}
+	s = f.state("binary_sensor.front_door")
+	if s.State != "off" || s.Attributes["friendly_name"] != "Front Door" || s.Attributes["device_class"] != "door" {
+		t.Errorf("updated state = %+v, want off with the attributes kept", s)
+	}
+	if calls := f.takeCalls(); len(calls) > 0 {
+		t.Errorf("calls = %q, want the state set directly", calls)
+	}
+}
+
+func TestAssertMissingEntity(t *testing.T) {
+	_, c := newFakeREST(t)
+	if err := c.AssertLock("lock.missing", true); !errors.Is(err, errNotFound) {
+		t.Errorf("AssertLock() of a missing lock = %v, want %v", err, errNotFound)
+	}
+}
+
+func TestVerifyEntity(t *testing.T) {
+	_, c := newFakeREST(t, State{EntityID: "lock.front", State: "locked"})
+	tests := []struct {
+		kind, entityID string
+		wantErr        string
+	}{
+		{"lock", "lock.front", ""},
+		{"lock", "lock.missing", "entity not found"},
+		{"lock", "switch.front", "must be one of lock, input_boolean"},
+		// created on the first update
+		{"contact", "binary_sensor.missing", ""},
+		{"contact", "sensor.front", "must be one of binary_sensor, input_boolean"},
+	}
+	for _, tt := range tests {
+		err := c.VerifyEntity(tt.kind, tt.entityID)
+		if (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("VerifyEntity(%s, %s) = %v, want %q", tt.kind, tt.entityID, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEntityState(t *testing.T) {
+	_, c := newFakeREST(t,
+		State{EntityID: "binary_sensor.front", State: "on", Attributes: map[string]any{"friendly_name": "Front Door"}},
+		State{EntityID: "input_boolean.front_lock", State: "off"},
+	)
+	name, state, err := c.EntityState("contact", "binary_sensor.front")
+	if err != nil || name != "Front Door" || state != "open" {
+		t.Errorf("EntityState(contact) = %q, %q, %v, want Front Door, open", name, state, err)
+	}
+	name, state, err = c.EntityState("lock", "input_boolean.front_lock")
+	if err != nil || name != "input_boolean.front_lock" || state != "unlocked" {
+		t.Errorf("EntityState(lock) = %q, %q, %v, want the entity ID and unlocked", name, state, err)
+	}
+}
+
+func TestWrongToken(t *testing.T) {
+	_, c := newFakeREST(t)
+	c.token = "wrong"
+	if err := c.Ping(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Ping() with a wrong token = %v, want status 401", err)
+	}
+}
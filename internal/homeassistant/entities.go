@@ -0,0 +1,113 @@
+package homeassistant
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// kindDomains are the entity domains that can mirror each kind of door device. Helpers
+// (input_boolean) work for every kind, a binary_sensor contact is created and updated by the
+// middleware itself.
+var kindDomains = map[string][]string{
+	"contact": {"binary_sensor", "input_boolean"},
+	"lock":    {"lock", "input_boolean"},
+	"switch":  {"switch", "input_boolean"},
+}
+
+// domain returns the domain of an entity, e.g. "lock" for "lock.front_door".
+func domain(entityID string) string {
+	d, _, _ := strings.Cut(entityID, ".")
+	return d
+}
+
+// NormalizeState returns the state of an entity the way the doors are described: "open" or
+// "closed" for a contact, "locked" or "unlocked" for a lock and "on" or "off" for a switch.
+// Other states, such as "unavailable" or "jammed", are returned as they are.
+func NormalizeState(kind, entityID, state string) string {
+	switch {
+	case kind == "contact" && state == "on":
+		return "open"
+	case kind == "contact" && state == "off":
+		return "closed"
+	case kind == "lock" && domain(entityID) == "input_boolean" && state == "on":
+		return "locked"
+	case kind == "lock" && domain(entityID) == "input_boolean" && state == "off":
+		return "unlocked"
+	}
+	return state
+}
+
+// VerifyEntity checks that an entity can be used as the given kind of door device.
+func (c *Client) VerifyEntity(kind, entityID string) error {
+	if !slices.Contains(kindDomains[kind], domain(entityID)) {
+		return fmt.Errorf("entity %s must be one of %s", entityID, strings.Join(kindDomains[kind], ", "))
+	}
+	_, err := c.GetState(entityID)
+	if errors.Is(err, errNotFound) && domain(entityID) == "binary_sensor" {
+		// created on the first update
+		return nil
+	}
+	return err
+}
+
+// EntityState returns the friendly name and the normalized state of an entity.
+func (c *Client) EntityState(kind, entityID string) (name, state string, err error) {
+	s, err := c.GetState(entityID)
+	if err != nil {
+		return "", "", err
+	}
+	return s.FriendlyName(), NormalizeState(kind, entityID, s.State), nil
+}
+
+// AssertContact sets a contact entity to open or closed unless it already is.
+func (c *Client) AssertContact(entityID string, open bool) error {
+	return c.assertState("contact", entityID, pick(open, "open", "closed"))
+}
+
+// AssertLock sets a lock entity to locked or unlocked unless it already is.
+func (c *Client) AssertLock(entityID string, locked bool) error {
+	return c.assertState("lock", entityID, pick(locked, "locked", "unlocked"))
+}
+
+// AssertSwitch turns a switch entity on or off unless it already is.
+func (c *Client) AssertSwitch(entityID string, on bool) error {
+	return c.assertState("switch", entityID, pick(on, "on", "off"))
+}
+
+// assertState brings an entity into the normalized state want.
+func (c *Client) assertState(kind, entityID, want string) error {
+	current, err := c.GetState(entityID)
+	isNew := errors.Is(err, errNotFound) && domain(entityID) == "binary_sensor"
+	if err != nil && !isNew {
+		return err
+	}
+	if !isNew && NormalizeState(kind, entityID, current.State) == want {
+		return nil // Already in desired state
+	}
+
+	on := want == "open" || want == "locked" || want == "on"
+	switch domain(entityID) {
+	case "binary_sensor":
+		attributes := map[string]any{"device_class": "door"}
+		if !isNew {
+			attributes = current.Attributes
+		}
+		return c.setState(entityID, pick(on, "on", "off"), attributes)
+	case "lock":
+		return c.callService("lock", pick(on, "lock", "unlock"), entityID)
+	case "input_boolean", "switch":
+		return c.callService(domain(entityID), pick(on, "turn_on", "turn_off"), entityID)
+	default:
+		return fmt.Errorf("entity %s can't be used as a %s", entityID, kind)
+	}
+}
+
+// pick returns yes when cond is true and no otherwise.
+func pick(cond bool, yes, no string) string {
+	if cond {
+		return yes
+	}
+	return no
+}
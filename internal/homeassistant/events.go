@@ -0,0 +1,177 @@
+package homeassistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// pingInterval is how often the connection is checked with a ping.
+	pingInterval = 30 * time.Second
+	// readTimeout closes a connection on which nothing, not even a pong, was received.
+	readTimeout = 2 * pingInterval
+	// maxBackoff bounds the wait between reconnection attempts.
+	maxBackoff = time.Minute
+)
+
+// StateChange is a change of the state of an entity.
+type StateChange struct {
+	EntityID string
+	OldState string
+	NewState string
+	// UserID is the Home Assistant user who caused the change, if any.
+	UserID string
+}
+
+// wsMessage is a message of the WebSocket API, only the fields used are decoded.
+type wsMessage struct {
+	ID      int    `json:"id"`
+	Type    string `json:"type"`
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Event struct {
+		EventType string `json:"event_type"`
+		Data      struct {
+			EntityID string `json:"entity_id"`
+			OldState *State `json:"old_state"`
+			NewState *State `json:"new_state"`
+		} `json:"data"`
+		Context struct {
+			UserID string `json:"user_id"`
+		} `json:"context"`
+	} `json:"event"`
+}
+
+// Connected reports whether the client is currently subscribed to state changes.
+func (c *Client) Connected() bool {
+	return c.connected.Load()
+}
+
+// Listen calls onChange for every change of the state of an entity until ctx is cancelled.
+// Changes of the attributes only are left out. The connection is re-established whenever it
+// drops, changes in the meantime are missed.
+func (c *Client) Listen(ctx context.Context, onChange func(StateChange)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := c.listen(ctx, onChange)
+		c.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Home Assistant event connection lost, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// listen subscribes to the state changes over a single connection.
+func (c *Client) listen(ctx context.Context, onChange func(StateChange)) error {
+	wsURL := "ws" + strings.TrimPrefix(c.baseURL, "http") + "/api/websocket"
+	conn, err := dialWebSocket(ctx, wsURL, c.tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	// unblock the reads when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.close() })
+	defer stop()
+
+	var msg wsMessage
+	read := func() error {
+		msg = wsMessage{}
+		conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return conn.readJSON(&msg)
+	}
+
+	if err := read(); err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("unexpected message %q", msg.Type)
+	}
+	if err := conn.writeJSON(map[string]string{"type": "auth", "access_token": c.token}); err != nil {
+		return err
+	}
+	if err := read(); err != nil {
+		return err
+	}
+	if msg.Type != "auth_ok" {
+		return fmt.Errorf("authentication failed: %s", msg.Message)
+	}
+
+	const subscriptionID = 1
+	nextID := subscriptionID
+	if err := conn.writeJSON(map[string]any{"id": subscriptionID, "type": "subscribe_events", "event_type": "state_changed"}); err != nil {
+		return err
+	}
+
+	pings := time.NewTicker(pingInterval)
+	defer pings.Stop()
+	pingErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-pings.C:
+				nextID++
+				if err := conn.writeJSON(map[string]any{"id": nextID, "type": "ping"}); err != nil {
+					pingErr <- err
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		if err := read(); err != nil {
+			select {
+			case pingErr := <-pingErr:
+				return pingErr
+			default:
+				return err
+			}
+		}
+		switch {
+		case msg.Type == "result" && msg.ID == subscriptionID:
+			if !msg.Success {
+				if msg.Error != nil {
+					return fmt.Errorf("subscribing to state changes failed: %s", msg.Error.Message)
+				}
+				return errors.New("subscribing to state changes failed")
+			}
+			c.connected.Store(true)
+			log.Printf("Subscribed to Home Assistant state changes")
+		case msg.Type == "event" && msg.Event.EventType == "state_changed":
+			data := msg.Event.Data
+			if data.NewState == nil {
+				// the entity was removed
+				continue
+			}
+			change := StateChange{EntityID: data.EntityID, NewState: data.NewState.State, UserID: msg.Event.Context.UserID}
+			if data.OldState != nil {
+				change.OldState = data.OldState.State
+			}
+			if change.OldState != change.NewState {
+				onChange(change)
+			}
+		}
+	}
+}
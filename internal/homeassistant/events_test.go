@@ -0,0 +1,323 @@
+package homeassistant
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serverConn is the server side of a WebSocket connection of the fake Home Assistant.
+type serverConn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// newWebSocketServer starts a fake Home Assistant whose /api/websocket hands every connection to handle.
+func newWebSocketServer(t *testing.T, handle func(c *serverConn)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/websocket" || r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+		rw.Flush()
+		handle(&serverConn{t: t, conn: conn, br: rw.Reader})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// writeFrame sends an unmasked frame, as servers do.
+func (c *serverConn) writeFrame(opcode byte, fin bool, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	header := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		c.t.Errorf("writing frame: %v", err)
+	}
+}
+
+func (c *serverConn) writeJSON(v any) {
+	data, _ := json.Marshal(v)
+	c.writeFrame(opText, true, data)
+}
+
+// readFrame reads a frame from the client, which must be masked.
+func (c *serverConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frame is not masked")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0F, payload, nil
+}
+
+// readJSON reads the next text message of the client into a map.
+func (c *serverConn) readJSON() map[string]any {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			c.t.Errorf("reading client message: %v", err)
+			return nil
+		}
+		if opcode != opText {
+			continue
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			c.t.Errorf("client message %q: %v", payload, err)
+		}
+		return msg
+	}
+}
+
+// handshake authenticates the client and accepts its subscription to the state changes.
+func (c *serverConn) handshake() bool {
+	c.writeJSON(map[string]any{"type": "auth_required", "ha_version": "2026.10.0"})
+	auth := c.readJSON()
+	if auth["type"] != "auth" || auth["access_token"] != testToken {
+		c.t.Errorf("auth message = %v", auth)
+		c.writeJSON(map[string]any{"type": "auth_invalid", "message": "Invalid access token"})
+		return false
+	}
+	c.writeJSON(map[string]any{"type": "auth_ok"})
+	sub := c.readJSON()
+	if sub["type"] != "subscribe_events" || sub["event_type"] != "state_changed" {
+		c.t.Errorf("subscribe message = %v", sub)
+		return false
+	}
+	c.writeJSON(map[string]any{"id": sub["id"], "type": "result", "success": true, "result": nil})
+	return true
+}
+
+// stateChanged returns a state_changed event of the subscription.
+func stateChanged(entityID, oldState, newState, userID string) map[string]any {
+	return map[string]any{
+		"id":   1,
+		"type": "event",
+		"event": map[string]any{
+			"event_type": "state_changed",
+			"data": map[string]any{
+				"entity_id": entityID,
+				"old_state": map[string]any{"entity_id": entityID, "state": oldState},
+				"new_state": map[string]any{"entity_id": entityID, "state": newState},
+			},
+			"context": map[string]any{"user_id": userID},
+		},
+	}
+}
+
+// collect listens with a client of srv and returns the channel receiving the changes.
+func collect(t *testing.T, srv *httptest.Server, token string) (*Client, <-chan StateChange) {
+	t.Helper()
+	c := NewClient(srv.URL, token, nil)
+	changes := make(chan StateChange, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Listen(ctx, func(change StateChange) { changes <- change })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return c, changes
+}
+
+func receive(t *testing.T, changes <-chan StateChange) StateChange {
+	t.Helper()
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("no state change received")
+		return StateChange{}
+	}
+}
+
+func TestListenReceivesStateChanges(t *testing.T) {
+	pong := make(chan []byte, 1)
+	srv := newWebSocketServer(t, func(c *serverConn) {
+		if !c.handshake() {
+			return
+		}
+		// only the attributes changed
+		c.writeJSON(stateChanged("lock.front", "locked", "locked", ""))
+		// the entity was removed
+		c.writeJSON(map[string]any{"id": 1, "type": "event", "event": map[string]any{
+			"event_type": "state_changed", "data": map[string]any{"entity_id": "lock.old", "new_state": nil},
+		}})
+		// a ping in between is answered with the same payload
+		c.writeFrame(opPing, true, []byte("are you there"))
+		if opcode, payload, err := c.readFrame(); err != nil || opcode != opPong {
+			t.Errorf("answer to ping = %d, %v, want a pong", opcode, err)
+		} else {
+			pong <- payload
+		}
+		c.writeJSON(stateChanged("lock.front", "locked", "unlocked", "user-1"))
+
+		// a message of 16 bit length, fragmented in three frames
+		data, _ := json.Marshal(stateChanged("binary_sensor.front", "off", "on", strings.Repeat("u", 300)))
+		c.writeFrame(opText, false, data[:100])
+		c.writeFrame(opContinuation, false, data[100:200])
+		c.writeFrame(opContinuation, true, data[200:])
+
+		// a message of 64 bit length
+		c.writeJSON(stateChanged("switch.front", "off", "on", strings.Repeat("u", 70000)))
+
+		// wait for the client to go away
+		c.readFrame()
+	})
+	c, changes := collect(t, srv, testToken)
+
+	want := StateChange{EntityID: "lock.front", OldState: "locked", NewState: "unlocked", UserID: "user-1"}
+	if got := receive(t, changes); got != want {
+		t.Errorf("first change = %+v, want %+v", got, want)
+	}
+	if got := <-pong; string(got) != "are you there" {
+		t.Errorf("pong payload = %q", got)
+	}
+	if !c.Connected() {
+		t.Error("Connected() = false after the subscription succeeded")
+	}
+	if got := receive(t, changes); got.EntityID != "binary_sensor.front" || got.NewState != "on" || len(got.UserID) != 300 {
+		t.Errorf("fragmented change = %s %s with a user ID of %d bytes", got.EntityID, got.NewState, len(got.UserID))
+	}
+	if got := receive(t, changes); got.EntityID != "switch.front" || len(got.UserID) != 70000 {
+		t.Errorf("large change = %s with a user ID of %d bytes", got.EntityID, len(got.UserID))
+	}
+}
+
+func TestListenAuthenticationFails(t *testing.T) {
+	srv := newWebSocketServer(t, func(c *serverConn) {
+		c.writeJSON(map[string]any{"type": "auth_required"})
+		c.readJSON()
+		c.writeJSON(map[string]any{"type": "auth_invalid", "message": "Invalid access token"})
+	})
+	c := NewClient(srv.URL, "wrong", nil)
+	err := c.listen(context.Background(), func(StateChange) { t.Error("unexpected change") })
+	if err == nil || !strings.Contains(err.Error(), "authentication failed: Invalid access token") {
+		t.Errorf("listen() = %v, want the authentication to fail", err)
+	}
+	if c.Connected() {
+		t.Error("Connected() = true after the authentication failed")
+	}
+}
+
+func TestListenSubscriptionFails(t *testing.T) {
+	srv := newWebSocketServer(t, func(c *serverConn) {
+		c.writeJSON(map[string]any{"type": "auth_required"})
+		c.readJSON()
+		c.writeJSON(map[string]any{"type": "auth_ok"})
+		sub := c.readJSON()
+		c.writeJSON(map[string]any{"id": sub["id"], "type": "result", "success": false,
+			"error": map[string]any{"code": "unauthorized", "message": "Unauthorized"}})
+	})
+	c := NewClient(srv.URL, testToken, nil)
+	err := c.listen(context.Background(), func(StateChange) {})
+	if err == nil || !strings.Contains(err.Error(), "subscribing to state changes failed: Unauthorized") {
+		t.Errorf("listen() = %v, want the subscription to fail", err)
+	}
+}
+
+func TestListenReconnects(t *testing.T) {
+	var connections atomic.Int32
+	srv := newWebSocketServer(t, func(c *serverConn) {
+		n := connections.Add(1)
+		if !c.handshake() {
+			return
+		}
+		c.writeJSON(stateChanged("lock.front", "locked", fmt.Sprintf("state-%d", n), ""))
+		if n == 1 {
+			// the first connection drops with a close frame
+			c.writeFrame(opClose, true, nil)
+			c.readFrame()
+			return
+		}
+		c.readFrame()
+	})
+	_, changes := collect(t, srv, testToken)
+
+	if got := receive(t, changes); got.NewState != "state-1" {
+		t.Errorf("change on the first connection = %q, want state-1", got.NewState)
+	}
+	if got := receive(t, changes); got.NewState != "state-2" {
+		t.Errorf("change after reconnecting = %q, want state-2", got.NewState)
+	}
+	if n := connections.Load(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
+
+func TestDialRejectsWrongAccept(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", "d3Jvbmc=")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer srv.Close()
+	_, err := dialWebSocket(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/websocket", nil)
+	if err == nil || !strings.Contains(err.Error(), "Sec-WebSocket-Accept") {
+		t.Errorf("dialWebSocket() = %v, want the accept header rejected", err)
+	}
+}
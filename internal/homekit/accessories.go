@@ -0,0 +1,257 @@
+package homekit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// categoryBridge is the accessory category advertised by the bridge.
+const categoryBridge = 2
+
+// Service and characteristic types, in the short form of the Apple defined UUIDs.
+const (
+	typeAccessoryInformation = "3E"
+	typeProtocolInformation  = "A2"
+	typeLockMechanism        = "45"
+	typeContactSensor        = "80"
+
+	typeIdentify           = "14"
+	typeManufacturer       = "20"
+	typeModel              = "21"
+	typeName               = "23"
+	typeSerialNumber       = "30"
+	typeFirmwareRevision   = "52"
+	typeVersion            = "37"
+	typeLockCurrentState   = "1D"
+	typeLockTargetState    = "1E"
+	typeContactSensorState = "6A"
+)
+
+// Values of the lock and contact sensor characteristics.
+const (
+	lockUnsecured = 0
+	lockSecured   = 1
+	lockUnknown   = 3
+
+	contactDetected    = 0 // closed
+	contactNotDetected = 1 // open
+)
+
+// HAP status codes.
+const (
+	statusSuccess             = 0
+	statusInsufficientPrivs   = -70401
+	statusCommunicationFailed = -70402
+	statusReadOnly            = -70404
+	statusWriteOnly           = -70405
+	statusNotifyNotSupported  = -70406
+	statusNotFound            = -70409
+	statusInvalidValue        = -70410
+)
+
+// charID identifies a characteristic by accessory and instance ID.
+type charID struct {
+	aid, iid uint64
+}
+
+// characteristic is a single value of an accessory.
+type characteristic struct {
+	iid      uint64
+	typ      string
+	perms    []string
+	format   string
+	value    any
+	minValue *int
+	maxValue *int
+	// write carries out a write from a controller, nil for characteristics that are only stored.
+	write func(value any) error
+}
+
+func (c *characteristic) can(perm string) bool {
+	return slices.Contains(c.perms, perm)
+}
+
+// service groups the characteristics of a function of an accessory.
+type service struct {
+	iid     uint64
+	typ     string
+	primary bool
+	chars   []*characteristic
+}
+
+// accessory is the bridge itself or a door.
+type accessory struct {
+	aid      uint64
+	doorID   string
+	services []*service
+}
+
+// find returns the characteristic with the given instance ID or type.
+func (a *accessory) find(match func(c *characteristic) bool) *characteristic {
+	for _, s := range a.services {
+		for _, c := range s.chars {
+			if match(c) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *accessory) byIID(iid uint64) *characteristic {
+	return a.find(func(c *characteristic) bool { return c.iid == iid })
+}
+
+func (a *accessory) byType(typ string) *characteristic {
+	return a.find(func(c *characteristic) bool { return c.typ == typ })
+}
+
+func intPtr(v int) *int { return &v }
+
+// accessoryBuilder assigns the instance IDs of an accessory in order.
+type accessoryBuilder struct {
+	acc     *accessory
+	nextIID uint64
+}
+
+func newAccessory(aid uint64, doorID string) *accessoryBuilder {
+	return &accessoryBuilder{acc: &accessory{aid: aid, doorID: doorID}, nextIID: 1}
+}
+
+func (b *accessoryBuilder) service(typ string, primary bool, chars ...*characteristic) {
+	s := &service{iid: b.nextIID, typ: typ, primary: primary}
+	b.nextIID++
+	for _, c := range chars {
+		c.iid = b.nextIID
+		b.nextIID++
+		s.chars = append(s.chars, c)
+	}
+	b.acc.services = append(b.acc.services, s)
+}
+
+func readOnly(typ, format string, value any) *characteristic {
+	return &characteristic{typ: typ, perms: []string{"pr"}, format: format, value: value}
+}
+
+// information adds the accessory information service.
+func (b *accessoryBuilder) information(name, model, serial, firmware string, identify func()) {
+	b.service(typeAccessoryInformation, false,
+		&characteristic{typ: typeIdentify, perms: []string{"pw"}, format: "bool", write: func(any) error {
+			identify()
+			return nil
+		}},
+		readOnly(typeManufacturer, "string", "Ubiquiti"),
+		readOnly(typeModel, "string", model),
+		readOnly(typeName, "string", name),
+		readOnly(typeSerialNumber, "string", serial),
+		readOnly(typeFirmwareRevision, "string", firmware),
+	)
+}
+
+// state returns a notifying uint8 state characteristic.
+func state(typ string, value, maxValue int, perms ...string) *characteristic {
+	return &characteristic{
+		typ:      typ,
+		perms:    append([]string{"pr", "ev"}, perms...),
+		format:   "uint8",
+		value:    value,
+		minValue: intPtr(0),
+		maxValue: intPtr(maxValue),
+	}
+}
+
+// characteristicJSON is a characteristic as described to controllers.
+type characteristicJSON struct {
+	AID      uint64   `json:"aid,omitempty"`
+	IID      uint64   `json:"iid"`
+	Type     string   `json:"type,omitempty"`
+	Perms    []string `json:"perms,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Value    any      `json:"value,omitempty"` // only omitted when nil
+	MinValue *int     `json:"minValue,omitempty"`
+	MaxValue *int     `json:"maxValue,omitempty"`
+	MinStep  *int     `json:"minStep,omitempty"`
+	Events   *bool    `json:"ev,omitempty"`
+	Status   *int     `json:"status,omitempty"`
+}
+
+// describe returns the full description of a characteristic. Write only characteristics have no value.
+func (c *characteristic) describe() characteristicJSON {
+	j := characteristicJSON{IID: c.iid, Type: c.typ, Perms: c.perms, Format: c.format, MinValue: c.minValue, MaxValue: c.maxValue}
+	if c.minValue != nil {
+		j.MinStep = intPtr(1)
+	}
+	if c.can("pr") {
+		j.Value = c.value
+	}
+	return j
+}
+
+// accessoriesJSON returns the attribute database served on /accessories.
+func accessoriesJSON(accs []*accessory) ([]byte, error) {
+	type serviceJSON struct {
+		IID             uint64               `json:"iid"`
+		Type            string               `json:"type"`
+		Primary         bool                 `json:"primary,omitempty"`
+		Characteristics []characteristicJSON `json:"characteristics"`
+	}
+	type accessoryJSON struct {
+		AID      uint64        `json:"aid"`
+		Services []serviceJSON `json:"services"`
+	}
+	out := struct {
+		Accessories []accessoryJSON `json:"accessories"`
+	}{}
+	for _, a := range accs {
+		aj := accessoryJSON{AID: a.aid}
+		for _, s := range a.services {
+			sj := serviceJSON{IID: s.iid, Type: s.typ, Primary: s.primary}
+			for _, c := range s.chars {
+				sj.Characteristics = append(sj.Characteristics, c.describe())
+			}
+			aj.Services = append(aj.Services, sj)
+		}
+		out.Accessories = append(out.Accessories, aj)
+	}
+	return json.Marshal(out)
+}
+
+// configHash identifies the structure of the accessories, ignoring their values, so the
+// configuration number only changes when accessories or names change.
+func configHash(accs []*accessory) string {
+	h := sha256.New()
+	for _, a := range accs {
+		fmt.Fprintf(h, "a%d;", a.aid)
+		for _, s := range a.services {
+			fmt.Fprintf(h, "s%d:%s;", s.iid, s.typ)
+			for _, c := range s.chars {
+				fmt.Fprintf(h, "c%d:%s", c.iid, c.typ)
+				if !c.can("ev") {
+					fmt.Fprintf(h, "=%v", c.value)
+				}
+				h.Write([]byte(";"))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// toInt converts a JSON value to an integer, accepting booleans as 0 and 1.
+func toInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), v == float64(int(v))
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	}
+	return 0, false
+}
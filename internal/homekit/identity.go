@@ -0,0 +1,277 @@
+package homekit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// storageKey is the key the identity and pairings are kept under.
+const storageKey = "homekit"
+
+// Storage persists the identity of the bridge and its pairings, e.g. a *state.Store.
+type Storage interface {
+	Get(key string, v any) (bool, error)
+	Set(key string, v any) error
+}
+
+// Pairing is a controller, i.e. a user's Apple device, allowed to control the bridge.
+type Pairing struct {
+	ID        string            `json:"id"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Admin     bool              `json:"admin"`
+}
+
+// identityData is what is persisted.
+type identityData struct {
+	// DeviceID is the pairing identifier of the bridge, formatted like a MAC address.
+	DeviceID   string             `json:"device_id"`
+	PrivateKey ed25519.PrivateKey `json:"private_key"`
+	// SetupCode is generated when none is configured.
+	SetupCode string `json:"setup_code"`
+	SetupID   string `json:"setup_id"`
+	// ConfigNumber is increased whenever the accessories change, so controllers reload them.
+	ConfigNumber int    `json:"config_number"`
+	ConfigHash   string `json:"config_hash,omitempty"`
+	// AIDs are the accessory IDs of the doors, which must stay the same across restarts.
+	AIDs     map[string]uint64 `json:"aids"`
+	NextAID  uint64            `json:"next_aid"`
+	Pairings []Pairing         `json:"pairings"`
+}
+
+// identity is the persistent state of the bridge.
+type identity struct {
+	mu      sync.Mutex
+	storage Storage
+	data    identityData
+}
+
+// loadIdentity loads the identity from storage, creating a new one on first use.
+func loadIdentity(storage Storage) (*identity, error) {
+	i := &identity{storage: storage}
+	found, err := storage.Get(storageKey, &i.data)
+	if err != nil {
+		return nil, err
+	}
+	if found && len(i.data.PrivateKey) == ed25519.PrivateKeySize {
+		if i.data.AIDs == nil {
+			i.data.AIDs = make(map[string]uint64)
+		}
+		return i, nil
+	}
+
+	mac := make([]byte, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	code, err := randomSetupCode()
+	if err != nil {
+		return nil, err
+	}
+	i.data = identityData{
+		DeviceID:     fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", mac[0], mac[1], mac[2], mac[3], mac[4], mac[5]),
+		PrivateKey:   key,
+		SetupCode:    code,
+		SetupID:      randomSetupID(),
+		ConfigNumber: 1,
+		AIDs:         make(map[string]uint64),
+		NextAID:      2, // 1 is the bridge
+	}
+	return i, i.saveLocked()
+}
+
+// ErrNoIdentity is returned by ReadSetup before the bridge created its identity on its first start.
+var ErrNoIdentity = errors.New("the HomeKit bridge has not been started yet")
+
+// Setup is what pairing the bridge in the Home app takes.
+type Setup struct {
+	Code string
+	// URI is the payload of the setup QR code.
+	URI    string
+	Paired bool
+}
+
+// ReadSetup returns the setup of the bridge kept in storage, without creating or changing its
+// identity. setupCode is the configured code, empty for the generated one.
+func ReadSetup(storage Storage, setupCode string) (Setup, error) {
+	i := &identity{storage: storage}
+	found, err := storage.Get(storageKey, &i.data)
+	if err != nil {
+		return Setup{}, err
+	}
+	if !found || len(i.data.PrivateKey) != ed25519.PrivateKeySize {
+		return Setup{}, ErrNoIdentity
+	}
+	if setupCode == "" {
+		setupCode = i.data.SetupCode
+	}
+	return Setup{Code: setupCode, URI: i.setupURI(setupCode), Paired: i.paired()}, nil
+}
+
+func (i *identity) saveLocked() error {
+	return i.storage.Set(storageKey, i.data)
+}
+
+func (i *identity) deviceID() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.data.DeviceID
+}
+
+func (i *identity) privateKey() ed25519.PrivateKey {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.data.PrivateKey
+}
+
+func (i *identity) paired() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.data.Pairings) > 0
+}
+
+// pairing returns the pairing of a controller.
+func (i *identity) pairing(id string) (Pairing, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, p := range i.data.Pairings {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Pairing{}, false
+}
+
+func (i *identity) pairings() []Pairing {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return slices.Clone(i.data.Pairings)
+}
+
+// addPairing adds or updates the pairing of a controller.
+func (i *identity) addPairing(p Pairing) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.data.Pairings = slices.DeleteFunc(i.data.Pairings, func(q Pairing) bool { return q.ID == p.ID })
+	i.data.Pairings = append(i.data.Pairings, p)
+	return i.saveLocked()
+}
+
+// removePairing removes the pairing of a controller. When no admin is left, every pairing is
+// removed, and the IDs of the removed controllers are returned.
+func (i *identity) removePairing(id string) ([]string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	removed := []string{id}
+	i.data.Pairings = slices.DeleteFunc(i.data.Pairings, func(p Pairing) bool { return p.ID == id })
+	if !slices.ContainsFunc(i.data.Pairings, func(p Pairing) bool { return p.Admin }) {
+		for _, p := range i.data.Pairings {
+			removed = append(removed, p.ID)
+		}
+		i.data.Pairings = nil
+	}
+	return removed, i.saveLocked()
+}
+
+// aid returns the accessory ID of a door, assigning a new one on first use.
+func (i *identity) aid(doorID string) (uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if aid, ok := i.data.AIDs[doorID]; ok {
+		return aid, nil
+	}
+	aid := i.data.NextAID
+	i.data.AIDs[doorID] = aid
+	i.data.NextAID++
+	return aid, i.saveLocked()
+}
+
+// updateConfig increases the configuration number when the hash of the accessories changed.
+func (i *identity) updateConfig(hash string) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if hash == i.data.ConfigHash {
+		return i.data.ConfigNumber, nil
+	}
+	if i.data.ConfigHash != "" {
+		i.data.ConfigNumber = i.data.ConfigNumber%65535 + 1
+	}
+	i.data.ConfigHash = hash
+	return i.data.ConfigNumber, i.saveLocked()
+}
+
+// setupHash is the sh TXT record, which lets the Home app find the bridge of a setup QR code.
+func (i *identity) setupHash() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	sum := sha512.Sum512([]byte(i.data.SetupID + i.data.DeviceID))
+	return base64.StdEncoding.EncodeToString(sum[:4])
+}
+
+// setupURI returns the X-HM:// payload of the setup QR code of a bridge with this setup code.
+func (i *identity) setupURI(setupCode string) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	code, _ := new(big.Int).SetString(strings.ReplaceAll(setupCode, "-", ""), 10)
+	var payload uint64
+	payload |= uint64(categoryBridge) << 31
+	payload |= 2 << 27 // supports IP
+	payload |= code.Uint64() & 0x7FFFFFF
+	encoded := strings.ToUpper(new(big.Int).SetUint64(payload).Text(36))
+	return "X-HM://" + strings.Repeat("0", max(0, 9-len(encoded))) + encoded + i.data.SetupID
+}
+
+// invalidSetupCodes are the codes HomeKit does not accept.
+var invalidSetupCodes = []string{
+	"000-00-000", "111-11-111", "222-22-222", "333-33-333", "444-44-444",
+	"555-55-555", "666-66-666", "777-77-777", "888-88-888", "999-99-999",
+	"123-45-678", "876-54-321",
+}
+
+// ValidSetupCode reports whether code is a setup code HomeKit accepts, formatted as XXX-XX-XXX.
+func ValidSetupCode(code string) bool {
+	if len(code) != 10 || code[3] != '-' || code[6] != '-' {
+		return false
+	}
+	for i, c := range code {
+		if i != 3 && i != 6 && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return !slices.Contains(invalidSetupCodes, code)
+}
+
+func randomSetupCode() (string, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(100000000))
+		if err != nil {
+			return "", err
+		}
+		s := fmt.Sprintf("%08d", n.Int64())
+		code := s[:3] + "-" + s[3:5] + "-" + s[5:]
+		if ValidSetupCode(code) {
+			return code, nil
+		}
+	}
+}
+
+func randomSetupID() string {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, 4)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
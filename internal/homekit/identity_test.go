@@ -0,0 +1,53 @@
+package homekit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memoryStorage keeps the identity in memory, encoded as the state store does.
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Get(key string, v any) (bool, error) {
+	data, ok := m[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (m memoryStorage) Set(key string, v any) error {
+	data, err := json.Marshal(v)
+	m[key] = data
+	return err
+}
+
+func TestReadSetup(t *testing.T) {
+	storage := memoryStorage{}
+	if _, err := ReadSetup(storage, ""); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("ReadSetup() before the first start = %v, want %v", err, ErrNoIdentity)
+	}
+	if len(storage) > 0 {
+		t.Fatal("ReadSetup() created an identity")
+	}
+
+	server, err := NewServer(Options{Name: "Test", Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup, err := ReadSetup(storage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.Code != server.SetupCode() || setup.URI != server.SetupURI() || setup.Paired {
+		t.Errorf("ReadSetup() = %+v, want the generated code %s of the unpaired bridge", setup, server.SetupCode())
+	}
+
+	// a configured code replaces the generated one
+	setup, err = ReadSetup(storage, "031-45-154")
+	if err != nil || setup.Code != "031-45-154" || !strings.HasPrefix(setup.URI, "X-HM://") {
+		t.Errorf("ReadSetup() with a configured code = %+v, %v", setup, err)
+	}
+}
@@ -0,0 +1,260 @@
+package homekit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// A minimal mDNS responder (RFC 6762) advertising the bridge as a _hap._tcp DNS-SD service,
+// so that it is found without a system daemon such as Avahi.
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN         = 1
+	dnsCacheFlush      = 0x8000
+	dnsUnicastResponse = 0x8000
+
+	mdnsPort       = 5353
+	hapService     = "_hap._tcp.local."
+	servicesListed = "_services._dns-sd._udp.local."
+
+	// TTLs recommended by RFC 6762 for records with and without host names.
+	hostTTL  = 120
+	otherTTL = 4500
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// dnsRecord is a resource record.
+type dnsRecord struct {
+	name  string
+	typ   uint16
+	flush bool
+	ttl   uint32
+	data  []byte
+}
+
+// responder answers mDNS queries for the bridge.
+type responder struct {
+	conn     *net.UDPConn
+	instance string
+	host     string
+	port     int
+	ips      []net.IP
+	txt      func() []string
+}
+
+func newResponder(name, deviceID string, port int, ips []net.IP, txt func() []string) (*responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &responder{
+		conn:     conn,
+		instance: strings.ReplaceAll(name, ".", "-") + "." + hapService,
+		host:     "uahm-" + strings.ReplaceAll(deviceID, ":", "") + ".local.",
+		port:     port,
+		ips:      ips,
+		txt:      txt,
+	}, nil
+}
+
+// run answers queries until ctx is cancelled, then says goodbye so controllers forget the bridge.
+func (r *responder) run(ctx context.Context) {
+	r.announce()
+	stop := context.AfterFunc(ctx, func() {
+		r.send(r.records(0), mdnsGroup, 0, nil, 0)
+		r.conn.Close()
+	})
+	defer stop()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("mDNS responder stopped: %v", err)
+			}
+			return
+		}
+		r.handle(buf[:n], from)
+	}
+}
+
+// announce sends every record unsolicited, twice one second apart as RFC 6762 recommends.
+func (r *responder) announce() {
+	r.send(r.records(1), mdnsGroup, 0, nil, 0)
+	time.AfterFunc(time.Second, func() { r.send(r.records(1), mdnsGroup, 0, nil, 0) })
+}
+
+// addresses returns the IPv4 addresses to advertise.
+func (r *responder) addresses() []net.IP {
+	if len(r.ips) > 0 {
+		return r.ips
+	}
+	var ips []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			ips = append(ips, ipnet.IP.To4())
+		}
+	}
+	return ips
+}
+
+// records returns every record of the bridge. ttlScale 0 returns them with a zero TTL, for goodbyes.
+func (r *responder) records(ttlScale uint32) []dnsRecord {
+	srv := binary.BigEndian.AppendUint16(make([]byte, 4), uint16(r.port)) // priority and weight 0
+	srv = appendName(srv, r.host)
+	var txt []byte
+	for _, entry := range r.txt() {
+		txt = append(txt, byte(len(entry)))
+		txt = append(txt, entry...)
+	}
+	records := []dnsRecord{
+		{name: servicesListed, typ: dnsTypePTR, ttl: otherTTL, data: appendName(nil, hapService)},
+		{name: hapService, typ: dnsTypePTR, ttl: otherTTL, data: appendName(nil, r.instance)},
+		{name: r.instance, typ: dnsTypeSRV, flush: true, ttl: hostTTL, data: srv},
+		{name: r.instance, typ: dnsTypeTXT, flush: true, ttl: otherTTL, data: txt},
+	}
+	for _, ip := range r.addresses() {
+		records = append(records, dnsRecord{name: r.host, typ: dnsTypeA, flush: true, ttl: hostTTL, data: ip.To4()})
+	}
+	for i := range records {
+		records[i].ttl *= ttlScale
+	}
+	return records
+}
+
+// handle answers the questions of a query about the bridge.
+func (r *responder) handle(msg []byte, from *net.UDPAddr) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 { // responses
+		return
+	}
+	id := binary.BigEndian.Uint16(msg)
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	offset := 12
+	all := r.records(1)
+	var answers []dnsRecord
+	answered := make([]bool, len(all))
+	var asked []byte
+	unicast := from.Port != mdnsPort
+	for range questions {
+		name, next, err := readName(msg, offset)
+		if err != nil || next+4 > len(msg) {
+			return
+		}
+		qtype := binary.BigEndian.Uint16(msg[next:])
+		qclass := binary.BigEndian.Uint16(msg[next+2:])
+		asked = append(asked, msg[offset:next+4]...)
+		offset = next + 4
+		if qclass&dnsUnicastResponse != 0 {
+			unicast = true
+		}
+		for i, rec := range all {
+			if !answered[i] && strings.EqualFold(rec.name, name) && (qtype == rec.typ || qtype == dnsTypeANY) {
+				answers = append(answers, rec)
+				answered[i] = true
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	// Include the rest of the records as additional ones, which saves controllers further queries.
+	var additional []dnsRecord
+	for i, rec := range all {
+		if !answered[i] && rec.name != servicesListed {
+			additional = append(additional, rec)
+		}
+	}
+
+	to := mdnsGroup
+	if unicast {
+		to = from
+	}
+	if from.Port != mdnsPort {
+		// Legacy unicast queries expect the ID and question back (RFC 6762 section 6.7).
+		r.send(append(answers, additional...), to, id, asked, questions)
+		return
+	}
+	r.send(append(answers, additional...), to, 0, nil, 0)
+}
+
+// send sends a response with the records, repeating the questions of legacy unicast queries.
+func (r *responder) send(records []dnsRecord, to *net.UDPAddr, id uint16, question []byte, questions int) {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x84, 0) // authoritative response
+	msg = binary.BigEndian.AppendUint16(msg, uint16(questions))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(records)))
+	msg = append(msg, 0, 0, 0, 0)
+	msg = append(msg, question...)
+	for _, rec := range records {
+		msg = appendName(msg, rec.name)
+		class := uint16(dnsClassIN)
+		if rec.flush && id == 0 {
+			class |= dnsCacheFlush
+		}
+		msg = binary.BigEndian.AppendUint16(msg, rec.typ)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+		msg = binary.BigEndian.AppendUint32(msg, rec.ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rec.data)))
+		msg = append(msg, rec.data...)
+	}
+	if _, err := r.conn.WriteToUDP(msg, to); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Failed to send mDNS response: %v", err)
+	}
+}
+
+// appendName appends a domain name in DNS wire format, without compression.
+func appendName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readName reads a possibly compressed domain name, returning it and the offset after it.
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(msg[offset])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xC0 == 0xC0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+n > len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+n]))
+			offset += 1 + n
+		}
+	}
+}
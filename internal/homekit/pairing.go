@@ -0,0 +1,319 @@
+package homekit
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// pairSetup is the pair setup in progress. Only one controller may pair at a time.
+type pairSetup struct {
+	owner *session
+	srp   *srpServer
+}
+
+// pairVerify is the pair verify in progress on a session.
+type pairVerify struct {
+	sharedSecret     []byte
+	sessionKey       []byte
+	accessoryPublic  []byte
+	controllerPublic []byte
+}
+
+// tlvResponse answers a pairing request.
+func tlvResponse(items ...tlvItem) response {
+	return response{status: http.StatusOK, contentType: contentTypeTLV8, body: tlv8(items).encode()}
+}
+
+// tlvFailure answers a pairing request with an error in the given state.
+func tlvFailure(state, code byte) response {
+	return tlvResponse(tlvItem{tlvState, []byte{state}}, tlvItem{tlvError, []byte{code}})
+}
+
+// pairSetup handles the messages of pair setup, which adds the first admin controller with the setup code.
+func (s *Server) pairSetup(sess *session, body []byte) response {
+	in, err := decodeTLV8(body)
+	if err != nil {
+		return response{status: http.StatusBadRequest}
+	}
+	switch in.byte(tlvState) {
+	case 1:
+		return s.pairSetupStart(sess)
+	case 3:
+		return s.pairSetupVerify(sess, in)
+	case 5:
+		return s.pairSetupExchange(sess, in)
+	}
+	return tlvFailure(in.byte(tlvState)+1, tlvErrorUnknown)
+}
+
+// pairSetupStart answers M1 with the SRP salt and public key.
+func (s *Server) pairSetupStart(sess *session) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.id.paired():
+		return tlvFailure(2, tlvErrorUnavailable)
+	case s.failedSetups >= maxSetupAttempts:
+		return tlvFailure(2, tlvErrorMaxTries)
+	case s.setup != nil && s.setup.owner != sess:
+		return tlvFailure(2, tlvErrorBusy)
+	}
+	srp, err := newSRPServer(s.setupCode)
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	s.setup = &pairSetup{owner: sess, srp: srp}
+	return tlvResponse(
+		tlvItem{tlvState, []byte{2}},
+		tlvItem{tlvSalt, srp.salt},
+		tlvItem{tlvPublicKey, srp.publicKey()},
+	)
+}
+
+// pairSetupVerify answers M3 by checking the proof of the setup code.
+func (s *Server) pairSetupVerify(sess *session, in tlv8) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.setup == nil || s.setup.owner != sess {
+		return tlvFailure(4, tlvErrorUnknown)
+	}
+	publicKey, _ := in.get(tlvPublicKey)
+	proof, _ := in.get(tlvProof)
+	serverProof, err := s.setup.srp.verify(publicKey, proof)
+	if err != nil {
+		s.failedSetups++
+		s.setup = nil
+		log.Printf("HomeKit pairing from %s failed: %v", sess.conn.RemoteAddr(), err)
+		return tlvFailure(4, tlvErrorAuthentication)
+	}
+	return tlvResponse(tlvItem{tlvState, []byte{4}}, tlvItem{tlvProof, serverProof})
+}
+
+// pairSetupExchange answers M5: it stores the long-term key of the controller and returns that of the bridge.
+func (s *Server) pairSetupExchange(sess *session, in tlv8) response {
+	s.mu.Lock()
+	setup := s.setup
+	s.mu.Unlock()
+	if setup == nil || setup.owner != sess || setup.srp.key == nil {
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+	key := setup.srp.key
+
+	sessionKey, err := deriveKey(key, "Pair-Setup-Encrypt-Salt", "Pair-Setup-Encrypt-Info")
+	if err != nil {
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+	encrypted, _ := in.get(tlvEncryptedData)
+	plain, err := open(sessionKey, "PS-Msg05", encrypted)
+	if err != nil {
+		return tlvFailure(6, tlvErrorAuthentication)
+	}
+	sub, err := decodeTLV8(plain)
+	if err != nil {
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+	controllerID, _ := sub.get(tlvIdentifier)
+	controllerKey, _ := sub.get(tlvPublicKey)
+	signature, _ := sub.get(tlvSignature)
+	controllerX, err := deriveKey(key, "Pair-Setup-Controller-Sign-Salt", "Pair-Setup-Controller-Sign-Info")
+	if err != nil || len(controllerKey) != ed25519.PublicKeySize ||
+		!ed25519.Verify(controllerKey, slices.Concat(controllerX, controllerID, controllerKey), signature) {
+		return tlvFailure(6, tlvErrorAuthentication)
+	}
+	if err := s.id.addPairing(Pairing{ID: string(controllerID), PublicKey: controllerKey, Admin: true}); err != nil {
+		log.Printf("Failed to save HomeKit pairing: %v", err)
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+
+	accessoryX, err := deriveKey(key, "Pair-Setup-Accessory-Sign-Salt", "Pair-Setup-Accessory-Sign-Info")
+	if err != nil {
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+	deviceID := []byte(s.id.deviceID())
+	privateKey := s.id.privateKey()
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	accessoryInfo := tlv8{
+		{tlvIdentifier, deviceID},
+		{tlvPublicKey, publicKey},
+		{tlvSignature, ed25519.Sign(privateKey, slices.Concat(accessoryX, deviceID, publicKey))},
+	}
+	out, err := seal(sessionKey, "PS-Msg06", accessoryInfo.encode())
+	if err != nil {
+		return tlvFailure(6, tlvErrorUnknown)
+	}
+
+	s.mu.Lock()
+	s.setup = nil
+	s.mu.Unlock()
+	log.Printf("HomeKit controller %s paired", controllerID)
+	resp := tlvResponse(tlvItem{tlvState, []byte{6}}, tlvItem{tlvEncryptedData, out})
+	resp.after = s.announce
+	return resp
+}
+
+// pairVerify handles the messages of pair verify, which authenticates a paired controller
+// and establishes the keys of the encrypted session.
+func (s *Server) pairVerify(sess *session, body []byte) response {
+	in, err := decodeTLV8(body)
+	if err != nil {
+		return response{status: http.StatusBadRequest}
+	}
+	switch in.byte(tlvState) {
+	case 1:
+		return s.pairVerifyStart(sess, in)
+	case 3:
+		return s.pairVerifyFinish(sess, in)
+	}
+	return tlvFailure(in.byte(tlvState)+1, tlvErrorUnknown)
+}
+
+// pairVerifyStart answers M1 with an ephemeral key and the signed proof of the bridge's identity.
+func (s *Server) pairVerifyStart(sess *session, in tlv8) response {
+	controllerPublic, _ := in.get(tlvPublicKey)
+	peer, err := ecdh.X25519().NewPublicKey(controllerPublic)
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	shared, err := key.ECDH(peer)
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	sessionKey, err := deriveKey(shared, "Pair-Verify-Encrypt-Salt", "Pair-Verify-Encrypt-Info")
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	accessoryPublic := key.PublicKey().Bytes()
+	deviceID := []byte(s.id.deviceID())
+	info := tlv8{
+		{tlvIdentifier, deviceID},
+		{tlvSignature, ed25519.Sign(s.id.privateKey(), slices.Concat(accessoryPublic, deviceID, controllerPublic))},
+	}
+	out, err := seal(sessionKey, "PV-Msg02", info.encode())
+	if err != nil {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	sess.verify = &pairVerify{
+		sharedSecret:     shared,
+		sessionKey:       sessionKey,
+		accessoryPublic:  accessoryPublic,
+		controllerPublic: controllerPublic,
+	}
+	return tlvResponse(
+		tlvItem{tlvState, []byte{2}},
+		tlvItem{tlvPublicKey, accessoryPublic},
+		tlvItem{tlvEncryptedData, out},
+	)
+}
+
+// pairVerifyFinish answers M3 by checking the controller's signature, and encrypts the
+// session once the answer was sent.
+func (s *Server) pairVerifyFinish(sess *session, in tlv8) response {
+	verify := sess.verify
+	sess.verify = nil
+	if verify == nil {
+		return tlvFailure(4, tlvErrorUnknown)
+	}
+	encrypted, _ := in.get(tlvEncryptedData)
+	plain, err := open(verify.sessionKey, "PV-Msg03", encrypted)
+	if err != nil {
+		return tlvFailure(4, tlvErrorAuthentication)
+	}
+	sub, err := decodeTLV8(plain)
+	if err != nil {
+		return tlvFailure(4, tlvErrorUnknown)
+	}
+	controllerID, _ := sub.get(tlvIdentifier)
+	signature, _ := sub.get(tlvSignature)
+	pairing, ok := s.id.pairing(string(controllerID))
+	if !ok || !ed25519.Verify(pairing.PublicKey, slices.Concat(verify.controllerPublic, controllerID, verify.accessoryPublic), signature) {
+		return tlvFailure(4, tlvErrorAuthentication)
+	}
+	resp := tlvResponse(tlvItem{tlvState, []byte{4}})
+	resp.after = func() {
+		if err := sess.encrypt(verify.sharedSecret, pairing.ID); err != nil {
+			sess.conn.Close()
+		}
+	}
+	return resp
+}
+
+// managePairings adds, removes and lists pairings on behalf of an admin controller.
+func (s *Server) managePairings(sess *session, body []byte) response {
+	in, err := decodeTLV8(body)
+	if err != nil {
+		return response{status: http.StatusBadRequest}
+	}
+	if in.byte(tlvState) != 1 {
+		return tlvFailure(2, tlvErrorUnknown)
+	}
+	if p, ok := s.id.pairing(sess.controller); !ok || !p.Admin {
+		return tlvFailure(2, tlvErrorAuthentication)
+	}
+	id, _ := in.get(tlvIdentifier)
+
+	switch in.byte(tlvMethod) {
+	case 3: // add
+		publicKey, _ := in.get(tlvPublicKey)
+		if len(publicKey) != ed25519.PublicKeySize {
+			return tlvFailure(2, tlvErrorUnknown)
+		}
+		if existing, ok := s.id.pairing(string(id)); ok && !bytes.Equal(existing.PublicKey, publicKey) {
+			return tlvFailure(2, tlvErrorUnknown)
+		}
+		pairing := Pairing{ID: string(id), PublicKey: publicKey, Admin: in.byte(tlvPermissions)&1 == 1}
+		if err := s.id.addPairing(pairing); err != nil {
+			log.Printf("Failed to save HomeKit pairing: %v", err)
+			return tlvFailure(2, tlvErrorUnknown)
+		}
+		log.Printf("HomeKit controller %s added by %s", id, sess.controller)
+		return tlvResponse(tlvItem{tlvState, []byte{2}})
+
+	case 4: // remove
+		removed, err := s.id.removePairing(string(id))
+		if err != nil {
+			log.Printf("Failed to save HomeKit pairing: %v", err)
+			return tlvFailure(2, tlvErrorUnknown)
+		}
+		log.Printf("HomeKit controller %s removed by %s", id, sess.controller)
+		resp := tlvResponse(tlvItem{tlvState, []byte{2}})
+		resp.after = func() {
+			s.mu.Lock()
+			for other := range s.sessions {
+				if slices.Contains(removed, other.controllerID()) {
+					other.conn.Close()
+				}
+			}
+			s.mu.Unlock()
+			s.announce()
+		}
+		return resp
+
+	case 5: // list
+		items := tlv8{{tlvState, []byte{2}}}
+		for i, p := range s.id.pairings() {
+			if i > 0 {
+				items = append(items, tlvItem{tlvSeparator, nil})
+			}
+			permissions := byte(0)
+			if p.Admin {
+				permissions = 1
+			}
+			items = append(items,
+				tlvItem{tlvIdentifier, []byte(p.ID)},
+				tlvItem{tlvPublicKey, p.PublicKey},
+				tlvItem{tlvPermissions, []byte{permissions}},
+			)
+		}
+		return tlvResponse(items...)
+	}
+	return tlvFailure(2, tlvErrorUnknown)
+}
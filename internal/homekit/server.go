@@ -0,0 +1,562 @@
+// Package homekit is a HomeKit Accessory Protocol (HAP) bridge over IP, exposing every door as
+// a lock mechanism and a contact sensor so it can be controlled from the Home app and Siri.
+package homekit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAccessories is the number of accessories a bridge may have, including itself.
+	maxAccessories = 150
+	// maxBodySize bounds the size of a request body.
+	maxBodySize = 1 << 20
+	// maxSetupAttempts is how many wrong setup codes are accepted before pairing is refused until restart.
+	maxSetupAttempts = 100
+
+	contentTypeTLV8 = "application/pairing+tlv8"
+	contentTypeJSON = "application/hap+json"
+)
+
+// Door is a door exposed as an accessory.
+type Door struct {
+	ID   string
+	Name string
+}
+
+// Options configure a bridge.
+type Options struct {
+	// Name is the name of the bridge shown while pairing.
+	Name string
+	// SetupCode is the code entered when pairing, formatted as XXX-XX-XXX. A random one is
+	// generated and kept in Storage when it is empty.
+	SetupCode string
+	// Addr is the TCP address the bridge listens on.
+	Addr string
+	// AdvertiseIPs are the addresses announced over mDNS, by default those of every interface.
+	AdvertiseIPs []net.IP
+	Firmware     string
+	Storage      Storage
+	// SetLock locks or unlocks a door when its target state is changed from a controller.
+	SetLock func(doorID string, locked bool) error
+}
+
+// Server is a HomeKit bridge.
+type Server struct {
+	opts      Options
+	id        *identity
+	setupCode string
+
+	mu           sync.Mutex
+	accessories  []*accessory
+	byDoor       map[string]*accessory
+	configNumber int
+	sessions     map[*session]bool
+	setup        *pairSetup
+	failedSetups int
+	mdns         *responder
+}
+
+// NewServer creates a bridge, loading its identity and pairings from storage.
+func NewServer(opts Options) (*Server, error) {
+	id, err := loadIdentity(opts.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load HomeKit identity: %w", err)
+	}
+	if opts.Firmware == "" {
+		opts.Firmware = "1.0.0"
+	}
+	s := &Server{
+		opts:      opts,
+		id:        id,
+		setupCode: opts.SetupCode,
+		byDoor:    make(map[string]*accessory),
+		sessions:  make(map[*session]bool),
+	}
+	if s.setupCode == "" {
+		s.setupCode = id.data.SetupCode
+	}
+	// only the bridge until SetDoors, keeping the configuration number of the last run
+	s.accessories, _, err = s.build(nil)
+	s.configNumber = id.data.ConfigNumber
+	return s, err
+}
+
+// SetupCode returns the code to enter when pairing.
+func (s *Server) SetupCode() string {
+	return s.setupCode
+}
+
+// SetupURI returns the payload of the setup QR code.
+func (s *Server) SetupURI() string {
+	return s.id.setupURI(s.setupCode)
+}
+
+// Paired reports whether any controller is paired.
+func (s *Server) Paired() bool {
+	return s.id.paired()
+}
+
+// SetDoors replaces the door accessories. Doors keep their accessory ID and state, and the
+// configuration number is increased when the accessories changed, so controllers reload them.
+func (s *Server) SetDoors(doors []Door) error {
+	accs, byDoor, err := s.build(doors)
+	if err != nil {
+		return err
+	}
+	configNumber, err := s.id.updateConfig(configHash(accs))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	changed := s.configNumber != configNumber
+	s.accessories, s.byDoor, s.configNumber = accs, byDoor, configNumber
+	s.mu.Unlock()
+	if changed {
+		s.announce()
+	}
+	return nil
+}
+
+// build creates the accessories of the bridge and the doors, keeping the states of the current ones.
+func (s *Server) build(doors []Door) ([]*accessory, map[string]*accessory, error) {
+	bridge := newAccessory(1, "")
+	bridge.information(s.opts.Name, "UniFi Access Hubitat Middleware", s.id.deviceID(), s.opts.Firmware, func() {
+		log.Printf("HomeKit identify requested for the bridge")
+	})
+	bridge.service(typeProtocolInformation, false, readOnly(typeVersion, "string", "1.1.0"))
+	accs := []*accessory{bridge.acc}
+	byDoor := make(map[string]*accessory)
+
+	s.mu.Lock()
+	previous := s.byDoor
+	s.mu.Unlock()
+
+	for _, door := range doors {
+		if len(accs) == maxAccessories {
+			log.Printf("HomeKit bridges are limited to %d accessories, door %s is left out", maxAccessories-1, door.Name)
+			continue
+		}
+		aid, err := s.id.aid(door.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		current, target, contact := lockUnknown, lockSecured, contactDetected
+		if prev, ok := previous[door.ID]; ok {
+			current = prev.byType(typeLockCurrentState).value.(int)
+			target = prev.byType(typeLockTargetState).value.(int)
+			contact = prev.byType(typeContactSensorState).value.(int)
+		}
+
+		b := newAccessory(aid, door.ID)
+		b.information(door.Name, "UniFi Access door", door.ID, s.opts.Firmware, func() {
+			log.Printf("HomeKit identify requested for %s", door.Name)
+		})
+		targetState := state(typeLockTargetState, target, 1, "pw")
+		doorID := door.ID
+		targetState.write = func(value any) error {
+			v, _ := toInt(value)
+			return s.opts.SetLock(doorID, v == lockSecured)
+		}
+		b.service(typeLockMechanism, true,
+			state(typeLockCurrentState, current, 3),
+			targetState,
+			readOnly(typeName, "string", door.Name),
+		)
+		b.service(typeContactSensor, false,
+			state(typeContactSensorState, contact, 1),
+			readOnly(typeName, "string", door.Name),
+		)
+		accs = append(accs, b.acc)
+		byDoor[door.ID] = b.acc
+	}
+	return accs, byDoor, nil
+}
+
+// announce re-advertises the bridge after its configuration number or pairing status changed.
+func (s *Server) announce() {
+	s.mu.Lock()
+	mdns := s.mdns
+	s.mu.Unlock()
+	if mdns != nil {
+		mdns.announce()
+	}
+}
+
+// SetLockState sets the current state of the lock of a door. The target state follows, so a
+// door locked or unlocked outside HomeKit is not shown as locking or unlocking.
+func (s *Server) SetLockState(doorID string, locked bool) {
+	value := lockUnsecured
+	if locked {
+		value = lockSecured
+	}
+	s.setValue(doorID, typeLockCurrentState, value, nil)
+	s.setValue(doorID, typeLockTargetState, value, nil)
+}
+
+// SetContactState sets whether a door is open.
+func (s *Server) SetContactState(doorID string, open bool) {
+	value := contactDetected
+	if open {
+		value = contactNotDetected
+	}
+	s.setValue(doorID, typeContactSensorState, value, nil)
+}
+
+// setValue changes a characteristic of a door and notifies the subscribed controllers, except
+// the one that made the change.
+func (s *Server) setValue(doorID, typ string, value any, origin *session) {
+	s.mu.Lock()
+	acc, ok := s.byDoor[doorID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	c := acc.byType(typ)
+	if c.value == value {
+		s.mu.Unlock()
+		return
+	}
+	c.value = value
+	id := charID{acc.aid, c.iid}
+	var subscribers []*session
+	for sess := range s.sessions {
+		if sess != origin && sess.subscribed(id) {
+			subscribers = append(subscribers, sess)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	body, _ := json.Marshal(map[string]any{"characteristics": []characteristicJSON{{AID: id.aid, IID: id.iid, Value: value}}})
+	event := fmt.Appendf(nil, "EVENT/1.0 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentTypeJSON, len(body), body)
+	for _, sess := range subscribers {
+		if err := sess.write(event); err != nil {
+			sess.conn.Close()
+		}
+	}
+}
+
+// txtRecords returns the Bonjour TXT records of the bridge.
+func (s *Server) txtRecords() []string {
+	s.mu.Lock()
+	configNumber := s.configNumber
+	s.mu.Unlock()
+	statusFlag := "1" // not paired
+	if s.id.paired() {
+		statusFlag = "0"
+	}
+	return []string{
+		"c#=" + strconv.Itoa(configNumber),
+		"ff=0",
+		"id=" + s.id.deviceID(),
+		"md=" + s.opts.Name,
+		"pv=1.1",
+		"s#=1",
+		"sf=" + statusFlag,
+		"ci=" + strconv.Itoa(categoryBridge),
+		"sh=" + s.id.setupHash(),
+	}
+}
+
+// Serve accepts controller connections and advertises the bridge over mDNS until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	mdns, err := newResponder(s.opts.Name, s.id.deviceID(), port, s.opts.AdvertiseIPs, s.txtRecords)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to start mDNS: %w", err)
+	}
+	s.mu.Lock()
+	s.mdns = mdns
+	s.mu.Unlock()
+	go mdns.run(ctx)
+
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for sess := range s.sessions {
+			sess.conn.Close()
+		}
+	})
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// response is the answer to a request. after runs once it was written.
+type response struct {
+	status      int
+	contentType string
+	body        []byte
+	after       func()
+}
+
+// serveConn handles the requests of a connection one at a time.
+func (s *Server) serveConn(conn net.Conn) {
+	sess := newSession(conn)
+	s.mu.Lock()
+	s.sessions[sess] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		if s.setup != nil && s.setup.owner == sess {
+			s.setup = nil
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(sess)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("HomeKit connection from %s closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+		if err != nil {
+			return
+		}
+		resp := s.route(sess, req, body)
+
+		head := fmt.Sprintf("HTTP/1.1 %d %s\r\n", resp.status, http.StatusText(resp.status))
+		if resp.status == 470 {
+			head = "HTTP/1.1 470 Connection Authorization Required\r\n"
+		}
+		if resp.contentType != "" {
+			head += "Content-Type: " + resp.contentType + "\r\n"
+		}
+		if resp.status != http.StatusNoContent {
+			head += "Content-Length: " + strconv.Itoa(len(resp.body)) + "\r\n"
+		}
+		if err := sess.write(append([]byte(head+"\r\n"), resp.body...)); err != nil {
+			return
+		}
+		if resp.after != nil {
+			resp.after()
+		}
+	}
+}
+
+// statusResponse answers with a HAP status.
+func statusResponse(httpStatus, status int) response {
+	body, _ := json.Marshal(map[string]int{"status": status})
+	return response{status: httpStatus, contentType: contentTypeJSON, body: body}
+}
+
+// route dispatches a request. Only pairing and identify are allowed before pair verify.
+func (s *Server) route(sess *session, req *http.Request, body []byte) response {
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/pair-setup":
+		return s.pairSetup(sess, body)
+	case req.Method == http.MethodPost && req.URL.Path == "/pair-verify":
+		return s.pairVerify(sess, body)
+	case req.Method == http.MethodPost && req.URL.Path == "/identify":
+		if s.id.paired() {
+			return statusResponse(http.StatusBadRequest, statusInsufficientPrivs)
+		}
+		log.Printf("HomeKit identify requested for the bridge")
+		return response{status: http.StatusNoContent}
+	case !sess.encrypted():
+		return statusResponse(470, statusInsufficientPrivs)
+	case req.Method == http.MethodGet && req.URL.Path == "/accessories":
+		s.mu.Lock()
+		body, err := accessoriesJSON(s.accessories)
+		s.mu.Unlock()
+		if err != nil {
+			return statusResponse(http.StatusInternalServerError, statusCommunicationFailed)
+		}
+		return response{status: http.StatusOK, contentType: contentTypeJSON, body: body}
+	case req.Method == http.MethodGet && req.URL.Path == "/characteristics":
+		return s.readCharacteristics(req)
+	case req.Method == http.MethodPut && req.URL.Path == "/characteristics":
+		return s.writeCharacteristics(sess, body)
+	case req.Method == http.MethodPut && req.URL.Path == "/prepare":
+		return s.prepareWrite(sess, body)
+	case req.Method == http.MethodPost && req.URL.Path == "/pairings":
+		return s.managePairings(sess, body)
+	}
+	return response{status: http.StatusNotFound}
+}
+
+// find returns an accessory and characteristic.
+func (s *Server) find(id charID) (*accessory, *characteristic) {
+	for _, a := range s.accessories {
+		if a.aid == id.aid {
+			return a, a.byIID(id.iid)
+		}
+	}
+	return nil, nil
+}
+
+// readCharacteristics answers GET /characteristics?id=1.2,1.3 with the values, and with the
+// metadata, permissions, type and event subscription when asked for.
+func (s *Server) readCharacteristics(req *http.Request) response {
+	q := req.URL.Query()
+	flag := func(name string) bool { return q.Get(name) == "1" }
+	var results []characteristicJSON
+	failed := false
+
+	s.mu.Lock()
+	for _, ref := range strings.Split(q.Get("id"), ",") {
+		aid, iid, _ := strings.Cut(ref, ".")
+		a, _ := strconv.ParseUint(aid, 10, 64)
+		i, _ := strconv.ParseUint(iid, 10, 64)
+		result := characteristicJSON{AID: a, IID: i}
+		_, c := s.find(charID{a, i})
+		switch {
+		case c == nil:
+			result.Status = intPtr(statusNotFound)
+		case !c.can("pr"):
+			result.Status = intPtr(statusWriteOnly)
+		default:
+			full := c.describe()
+			result.Value = c.value
+			if flag("meta") {
+				result.Format, result.MinValue, result.MaxValue, result.MinStep = full.Format, full.MinValue, full.MaxValue, full.MinStep
+			}
+			if flag("perms") {
+				result.Perms = c.perms
+			}
+			if flag("type") {
+				result.Type = c.typ
+			}
+			result.Status = intPtr(statusSuccess)
+		}
+		if *result.Status != statusSuccess {
+			failed = true
+		}
+		results = append(results, result)
+	}
+	s.mu.Unlock()
+
+	if !failed {
+		for i := range results {
+			results[i].Status = nil
+		}
+	}
+	body, _ := json.Marshal(map[string]any{"characteristics": results})
+	if failed {
+		return response{status: http.StatusMultiStatus, contentType: contentTypeJSON, body: body}
+	}
+	return response{status: http.StatusOK, contentType: contentTypeJSON, body: body}
+}
+
+// prepareWrite starts a timed write: the following write with the same pid must arrive within ttl milliseconds.
+func (s *Server) prepareWrite(sess *session, body []byte) response {
+	var prepare struct {
+		TTL int64  `json:"ttl"`
+		PID uint64 `json:"pid"`
+	}
+	if err := json.Unmarshal(body, &prepare); err != nil {
+		return statusResponse(http.StatusBadRequest, statusInvalidValue)
+	}
+	sess.prepared(prepare.PID, time.Duration(prepare.TTL)*time.Millisecond)
+	return statusResponse(http.StatusOK, statusSuccess)
+}
+
+// writeCharacteristics answers PUT /characteristics, which changes values and event subscriptions.
+func (s *Server) writeCharacteristics(sess *session, body []byte) response {
+	var request struct {
+		Characteristics []struct {
+			AID    uint64 `json:"aid"`
+			IID    uint64 `json:"iid"`
+			Value  any    `json:"value"`
+			Events *bool  `json:"ev"`
+		} `json:"characteristics"`
+		PID *uint64 `json:"pid"`
+	}
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	if err := dec.Decode(&request); err != nil {
+		return statusResponse(http.StatusBadRequest, statusInvalidValue)
+	}
+	if request.PID != nil && !sess.takePrepared(*request.PID) {
+		return statusResponse(http.StatusBadRequest, statusInvalidValue)
+	}
+
+	var results []characteristicJSON
+	failed := false
+	for _, w := range request.Characteristics {
+		id := charID{w.AID, w.IID}
+		status := s.writeCharacteristic(sess, id, w.Value, w.Events)
+		if status != statusSuccess {
+			failed = true
+		}
+		results = append(results, characteristicJSON{AID: w.AID, IID: w.IID, Status: intPtr(status)})
+	}
+	if !failed {
+		return response{status: http.StatusNoContent}
+	}
+	out, _ := json.Marshal(map[string]any{"characteristics": results})
+	return response{status: http.StatusMultiStatus, contentType: contentTypeJSON, body: out}
+}
+
+// writeCharacteristic applies a single write and returns its HAP status.
+func (s *Server) writeCharacteristic(sess *session, id charID, value any, events *bool) int {
+	s.mu.Lock()
+	acc, c := s.find(id)
+	s.mu.Unlock()
+	if c == nil {
+		return statusNotFound
+	}
+	if events != nil {
+		if !c.can("ev") {
+			return statusNotifyNotSupported
+		}
+		sess.subscribe(id, *events)
+	}
+	if value == nil {
+		return statusSuccess
+	}
+	if !c.can("pw") {
+		return statusReadOnly
+	}
+	v, ok := toInt(value)
+	if c.format == "uint8" && (!ok || v < *c.minValue || v > *c.maxValue) {
+		return statusInvalidValue
+	}
+	if c.write != nil {
+		if err := c.write(value); err != nil {
+			log.Printf("HomeKit write to %d.%d failed: %v", id.aid, id.iid, err)
+			return statusCommunicationFailed
+		}
+	}
+	if c.format == "uint8" {
+		s.setValue(acc.doorID, c.typ, v, sess)
+	}
+	return statusSuccess
+}
@@ -0,0 +1,206 @@
+package homekit
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// maxFrameLength is the largest plaintext of an encrypted frame.
+const maxFrameLength = 1024
+
+// writeTimeout bounds how long a slow controller can hold up events to the others.
+const writeTimeout = 10 * time.Second
+
+// deriveKey derives a 32 byte key with HKDF-SHA512.
+func deriveKey(secret []byte, salt, info string) ([]byte, error) {
+	return hkdf.Key(sha512.New, secret, []byte(salt), info, 32)
+}
+
+// pairingNonce returns the nonce of a pairing message, e.g. "PS-Msg05", padded to 12 bytes.
+func pairingNonce(label string) []byte {
+	return append(make([]byte, 4), label...)
+}
+
+// seal encrypts a pairing message with ChaCha20-Poly1305.
+func seal(key []byte, nonce string, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, pairingNonce(nonce), plaintext, nil), nil
+}
+
+// open decrypts a pairing message with ChaCha20-Poly1305.
+func open(key []byte, nonce string, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, pairingNonce(nonce), ciphertext, nil)
+}
+
+// session is a connection from a controller. It starts in plain text and is encrypted once
+// pair verify succeeded: every frame is a 2 byte little endian length, which is also the
+// additional data, followed by the ciphertext and tag.
+type session struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+
+	// set once encrypted, writeKey under writeMu
+	readKey, writeKey     cipher.AEAD
+	readCount, writeCount uint64
+	readBuf               []byte
+
+	// controller is the pairing ID of the verified controller.
+	controller string
+	// events are the "aid.iid" characteristics the controller subscribed to.
+	eventsMu sync.Mutex
+	events   map[charID]bool
+
+	// verify is the pair verify in progress on the connection.
+	verify *pairVerify
+	// preparedPID and preparedUntil are the pending timed write.
+	preparedPID   uint64
+	preparedUntil time.Time
+}
+
+func newSession(conn net.Conn) *session {
+	return &session{conn: conn, br: bufio.NewReader(conn), events: make(map[charID]bool)}
+}
+
+// encrypted reports whether pair verify succeeded on the connection.
+func (s *session) encrypted() bool {
+	return s.readKey != nil
+}
+
+// encrypt switches the connection to encryption with the keys derived from the pair verify secret.
+func (s *session) encrypt(sharedSecret []byte, controller string) error {
+	readKey, err := deriveKey(sharedSecret, "Control-Salt", "Control-Write-Encryption-Key")
+	if err != nil {
+		return err
+	}
+	writeKey, err := deriveKey(sharedSecret, "Control-Salt", "Control-Read-Encryption-Key")
+	if err != nil {
+		return err
+	}
+	readAEAD, err := chacha20poly1305.New(readKey)
+	if err != nil {
+		return err
+	}
+	writeAEAD, err := chacha20poly1305.New(writeKey)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.readKey, s.writeKey, s.controller = readAEAD, writeAEAD, controller
+	return nil
+}
+
+// controllerID returns the pairing ID of the verified controller, or "" before pair verify.
+func (s *session) controllerID() string {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.controller
+}
+
+// frameNonce returns the nonce of the nth frame in a direction.
+func frameNonce(n uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// Read reads plain text, decrypting frames once the session is encrypted.
+func (s *session) Read(p []byte) (int, error) {
+	if !s.encrypted() {
+		return s.br.Read(p)
+	}
+	for len(s.readBuf) == 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(s.br, header[:]); err != nil {
+			return 0, err
+		}
+		n := int(binary.LittleEndian.Uint16(header[:]))
+		if n > maxFrameLength {
+			return 0, errors.New("encrypted frame too large")
+		}
+		frame := make([]byte, n+s.readKey.Overhead())
+		if _, err := io.ReadFull(s.br, frame); err != nil {
+			return 0, err
+		}
+		plain, err := s.readKey.Open(frame[:0], frameNonce(s.readCount), frame, header[:])
+		if err != nil {
+			return 0, err
+		}
+		s.readCount++
+		s.readBuf = plain
+	}
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+// write writes a whole message, so responses and events never interleave.
+func (s *session) write(msg []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if s.writeKey == nil {
+		_, err := s.conn.Write(msg)
+		return err
+	}
+	var out []byte
+	for len(msg) > 0 {
+		n := min(len(msg), maxFrameLength)
+		header := binary.LittleEndian.AppendUint16(nil, uint16(n))
+		out = append(out, header...)
+		out = s.writeKey.Seal(out, frameNonce(s.writeCount), msg[:n], header)
+		s.writeCount++
+		msg = msg[n:]
+	}
+	_, err := s.conn.Write(out)
+	return err
+}
+
+// subscribe changes the event subscription of a characteristic.
+func (s *session) subscribe(id charID, on bool) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	if on {
+		s.events[id] = true
+	} else {
+		delete(s.events, id)
+	}
+}
+
+// subscribed reports whether the controller subscribed to the events of a characteristic.
+func (s *session) subscribed(id charID) bool {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	return s.events[id]
+}
+
+// prepared records a timed write which must follow within ttl.
+func (s *session) prepared(pid uint64, ttl time.Duration) {
+	s.preparedPID, s.preparedUntil = pid, time.Now().Add(ttl)
+}
+
+// takePrepared reports whether a timed write with pid was prepared and has not expired.
+func (s *session) takePrepared(pid uint64) bool {
+	ok := pid == s.preparedPID && time.Now().Before(s.preparedUntil)
+	s.preparedUntil = time.Time{}
+	return ok
+}
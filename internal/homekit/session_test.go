@@ -0,0 +1,156 @@
+package homekit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestFrameNonce(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want []byte
+	}{
+		{0, make([]byte, 12)},
+		{1, []byte{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}},
+		{0x0102030405060708, []byte{0, 0, 0, 0, 8, 7, 6, 5, 4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		if got := frameNonce(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("frameNonce(%d) = %X, want %X", tt.n, got, tt.want)
+		}
+	}
+	if got, want := pairingNonce("PS-Msg05"), []byte("\x00\x00\x00\x00PS-Msg05"); !bytes.Equal(got, want) {
+		t.Errorf("pairingNonce() = %q, want %q", got, want)
+	}
+}
+
+// controllerKeys returns the keys of the controller side of a session with sharedSecret:
+// it writes with the key the accessory reads with.
+func controllerKeys(t *testing.T, sharedSecret []byte) (write, read []byte) {
+	t.Helper()
+	write, err := deriveKey(sharedSecret, "Control-Salt", "Control-Write-Encryption-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err = deriveKey(sharedSecret, "Control-Salt", "Control-Read-Encryption-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return write, read
+}
+
+// newEncryptedSession returns an encrypted session and the controller end of its connection.
+func newEncryptedSession(t *testing.T) (*session, net.Conn, []byte, []byte) {
+	t.Helper()
+	accessory, controller := net.Pipe()
+	t.Cleanup(func() {
+		accessory.Close()
+		controller.Close()
+	})
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	s := newSession(accessory)
+	if err := s.encrypt(secret, "controller"); err != nil {
+		t.Fatal(err)
+	}
+	write, read := controllerKeys(t, secret)
+	return s, controller, write, read
+}
+
+func TestSessionWriteFrames(t *testing.T) {
+	s, controller, _, readKey := newEncryptedSession(t)
+	aead, _ := chacha20poly1305.New(readKey)
+
+	msg := bytes.Repeat([]byte("0123456789"), 250)
+	go s.write(msg)
+
+	// 2500 bytes are sent in frames of 1024, 1024 and 452 bytes with the nonces 0, 1 and 2
+	var got []byte
+	for i, want := range []int{1024, 1024, 452} {
+		var header [2]byte
+		if _, err := io.ReadFull(controller, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		if n := int(binary.LittleEndian.Uint16(header[:])); n != want {
+			t.Fatalf("frame %d is %d bytes, want %d", i, n, want)
+		}
+		frame := make([]byte, want+aead.Overhead())
+		if _, err := io.ReadFull(controller, frame); err != nil {
+			t.Fatal(err)
+		}
+		plain, err := aead.Open(nil, frameNonce(uint64(i)), frame, header[:])
+		if err != nil {
+			t.Fatalf("frame %d: %v, want it sealed with nonce %d and its length as additional data", i, err, i)
+		}
+		got = append(got, plain...)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Error("the frames don't add up to the message")
+	}
+}
+
+// sealFrame returns a frame as a controller sends it.
+func sealFrame(t *testing.T, key []byte, n uint64, plain []byte) []byte {
+	t.Helper()
+	aead, _ := chacha20poly1305.New(key)
+	header := binary.LittleEndian.AppendUint16(nil, uint16(len(plain)))
+	return aead.Seal(header, frameNonce(n), plain, header)
+}
+
+func TestSessionReadFrames(t *testing.T) {
+	s, controller, writeKey, _ := newEncryptedSession(t)
+	go func() {
+		controller.Write(sealFrame(t, writeKey, 0, []byte("GET /accessories ")))
+		controller.Write(sealFrame(t, writeKey, 1, []byte("HTTP/1.1\r\n\r\n")))
+	}()
+	got := make([]byte, 29)
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "GET /accessories HTTP/1.1\r\n\r\n" {
+		t.Errorf("read %q", got)
+	}
+	if s.readCount != 2 {
+		t.Errorf("read count = %d, want 2", s.readCount)
+	}
+}
+
+func TestSessionRejectsFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame func(t *testing.T, writeKey []byte) []byte
+	}{
+		{"replayed nonce", func(t *testing.T, key []byte) []byte {
+			return append(sealFrame(t, key, 0, []byte("first")), sealFrame(t, key, 0, []byte("again"))...)
+		}},
+		{"skipped nonce", func(t *testing.T, key []byte) []byte {
+			return append(sealFrame(t, key, 0, []byte("first")), sealFrame(t, key, 2, []byte("third"))...)
+		}},
+		{"changed length", func(t *testing.T, key []byte) []byte {
+			frame := append(sealFrame(t, key, 0, []byte("first")), sealFrame(t, key, 1, []byte("second"))...)
+			// the length is authenticated as additional data
+			frame[len(frame)-6-16-2]--
+			return frame
+		}},
+		{"too large", func(t *testing.T, key []byte) []byte {
+			return append(sealFrame(t, key, 0, []byte("first")), sealFrame(t, key, 1, make([]byte, maxFrameLength+1))...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, controller, writeKey, _ := newEncryptedSession(t)
+			go controller.Write(tt.frame(t, writeKey))
+			first := make([]byte, 5)
+			if _, err := io.ReadFull(s, first); err != nil || string(first) != "first" {
+				t.Fatalf("first frame = %q, %v", first, err)
+			}
+			if n, err := s.Read(make([]byte, 2048)); err == nil {
+				t.Errorf("Read() = %d bytes, want the frame rejected", n)
+			}
+		})
+	}
+}
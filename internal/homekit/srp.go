@@ -0,0 +1,138 @@
+package homekit
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"math/big"
+)
+
+// The SRP-6a server of pair setup: the 3072-bit group of RFC 5054 with SHA-512 and the
+// username "Pair-Setup". Numbers are padded to the length of N wherever they are hashed.
+
+const srpUsername = "Pair-Setup"
+
+// srpGroup is the group and hash function SRP is computed with.
+type srpGroup struct {
+	N, g *big.Int
+	hash func() hash.Hash
+}
+
+// pairSetupGroup is the group of pair setup.
+var pairSetupGroup = srpGroup{
+	N: mustHex("" +
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+		"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33" +
+		"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864" +
+		"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2" +
+		"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"),
+	g:    big.NewInt(5),
+	hash: sha512.New,
+}
+
+func mustHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex number")
+	}
+	return n
+}
+
+// pad returns n as big-endian bytes padded to the length of N.
+func (grp srpGroup) pad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, (grp.N.BitLen()+7)/8))
+}
+
+func (grp srpGroup) digest(parts ...[]byte) []byte {
+	h := grp.hash()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// multiplier returns k = H(N | PAD(g)).
+func (grp srpGroup) multiplier() *big.Int {
+	return new(big.Int).SetBytes(grp.digest(grp.pad(grp.N), grp.pad(grp.g)))
+}
+
+// verifier returns v = g^x with x = H(salt | H(username | ":" | password)).
+func (grp srpGroup) verifier(username, password string, salt []byte) *big.Int {
+	x := new(big.Int).SetBytes(grp.digest(salt, grp.digest([]byte(username+":"+password))))
+	return new(big.Int).Exp(grp.g, x, grp.N)
+}
+
+// srpServer is the accessory side of one pair setup.
+type srpServer struct {
+	group    srpGroup
+	username string
+	salt     []byte
+	v        *big.Int
+	b        *big.Int
+	B        *big.Int
+	// key is the session key K, set once the client proof was verified.
+	key []byte
+}
+
+// newSRPServer creates the verifier for the setup code and the public key B.
+func newSRPServer(setupCode string) (*srpServer, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return pairSetupGroup.newServer(srpUsername, setupCode, salt, secret), nil
+}
+
+// newServer creates the server of a password with the given salt and private key b.
+func (grp srpGroup) newServer(username, password string, salt, secret []byte) *srpServer {
+	s := &srpServer{group: grp, username: username, salt: salt}
+	s.v = grp.verifier(username, password, salt)
+	s.b = new(big.Int).SetBytes(secret)
+	s.B = new(big.Int).Mul(grp.multiplier(), s.v)
+	s.B.Add(s.B, new(big.Int).Exp(grp.g, s.b, grp.N))
+	s.B.Mod(s.B, grp.N)
+	return s
+}
+
+// publicKey returns B.
+func (s *srpServer) publicKey() []byte {
+	return s.group.pad(s.B)
+}
+
+// verify checks the client public key A and proof M1, and returns the server proof M2.
+func (s *srpServer) verify(clientPublic, clientProof []byte) ([]byte, error) {
+	grp := s.group
+	A := new(big.Int).SetBytes(clientPublic)
+	if new(big.Int).Mod(A, grp.N).Sign() == 0 {
+		return nil, errors.New("invalid SRP public key")
+	}
+	u := new(big.Int).SetBytes(grp.digest(grp.pad(A), grp.pad(s.B)))
+	S := new(big.Int).Exp(s.v, u, grp.N)
+	S.Mul(S, A)
+	S.Exp(S, s.b, grp.N)
+	key := grp.digest(grp.pad(S))
+
+	hN, hG := grp.digest(grp.pad(grp.N)), grp.digest(grp.g.Bytes())
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	m1 := grp.digest(hN, grp.digest([]byte(s.username)), s.salt, grp.pad(A), grp.pad(s.B), key)
+	if subtle.ConstantTimeCompare(m1, clientProof) != 1 {
+		return nil, errors.New("invalid setup code")
+	}
+	s.key = key
+	return grp.digest(grp.pad(A), m1, key), nil
+}
@@ -0,0 +1,145 @@
+package homekit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// The test vectors of RFC 5054 appendix B, for the 1024-bit group with SHA-1.
+var (
+	rfc5054Group = srpGroup{
+		N: mustHex("" +
+			"EEAF0AB9ADB38DD69C33F80AFA8FC5E86072618775FF3C0B9EA2314C9C256576" +
+			"D674DF7496EA81D3383B4813D692C6E0E0D5D8E250B98BE48E495C1D6089DAD1" +
+			"5DC7D7B46154D6B6CE8EF4AD69B15D4982559B297BCF1885C529F566660E57EC" +
+			"68EDBC3C05726CC02FD4CBF4976EAA9AFD5138FE8376435B9FC61D2FC0EB06E3"),
+		g:    big.NewInt(2),
+		hash: sha1.New,
+	}
+	rfc5054Salt = "BEB25379D1A8581EB5A727673A2441EE"
+	rfc5054k    = "7556AA045AEF2CDD07ABAF0F665C3E818913186F"
+	rfc5054v    = "" +
+		"7E273DE8696FFC4F4E337D05B4B375BEB0DDE1569E8FA00A9886D8129BADA1F1" +
+		"822223CA1A605B530E379BA4729FDC59F105B4787E5186F5C671085A1447B52A" +
+		"48CF1970B4FB6F8400BBF4CEBFBB168152E08AB5EA53D15C1AFF87B2B9DA6E04" +
+		"E058AD51CC72BFC9033B564E26480D78E955A5E29E7AB245DB2BE315E2099AFB"
+	rfc5054a = "60975527035CF2AD1989806F0407210BC81EDC04E2762A56AFD529DDDA2D4393"
+	rfc5054b = "E487CB59D31AC550471E81F00F6928E01DDA08E974A004F49E61F5D105284D20"
+	rfc5054A = "" +
+		"61D5E490F6F1B79547B0704C436F523DD0E560F0C64115BB72557EC44352E890" +
+		"3211C04692272D8B2D1A5358A2CF1B6E0BFCF99F921530EC8E39356179EAE45E" +
+		"42BA92AEACED825171E1E8B9AF6D9C03E1327F44BE087EF06530E69F66615261" +
+		"EEF54073CA11CF5858F0EDFDFE15EFEAB349EF5D76988A3672FAC47B0769447B"
+	rfc5054B = "" +
+		"BD0C61512C692C0CB6D041FA01BB152D4916A1E77AF46AE105393011BAF38964" +
+		"DC46A0670DD125B95A981652236F99D9B681CBF87837EC996C6DA04453728610" +
+		"D0C6DDB58B318885D7D82C7F8DEB75CE7BD4FBAA37089E6F9C6059F388838E7A" +
+		"00030B331EB76840910440B1B27AAEAEEB4012B7D7665238A8E3FB004B117B58"
+	rfc5054u         = "CE38B9593487DA98554ED47D70A7AE5F462EF019"
+	rfc5054Premaster = "" +
+		"B0DC82BABCF30674AE450C0287745E7990A3381F63B387AAF271A10D233861E3" +
+		"59B48220F7C4693C9AE12B0A6F67809F0876E2D013800D6C41BB59B6D5979B5C" +
+		"00A172B4A2A5903A0BDCAF8A709585EB2AFAFA8F3499B200210DCC1F10EB3394" +
+		"3CD67FC88A2F39A4BE5BEC4EC0A3212DC346D7E474B29EDE8A469FFECA686E5A"
+)
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// clientProof returns M1 of a client with the public key A and the session key K.
+func clientProof(grp srpGroup, username string, salt []byte, A, B *big.Int, key []byte) []byte {
+	hN, hG := grp.digest(grp.pad(grp.N)), grp.digest(grp.g.Bytes())
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	return grp.digest(hN, grp.digest([]byte(username)), salt, grp.pad(A), grp.pad(B), key)
+}
+
+func TestSRPKnownAnswers(t *testing.T) {
+	grp := rfc5054Group
+	salt := mustDecodeHex(rfc5054Salt)
+
+	if got := grp.multiplier(); got.Cmp(mustHex(rfc5054k)) != 0 {
+		t.Errorf("k = %X, want %s", got, rfc5054k)
+	}
+	s := grp.newServer("alice", "password123", salt, mustDecodeHex(rfc5054b))
+	if s.v.Cmp(mustHex(rfc5054v)) != 0 {
+		t.Errorf("v = %X, want %s", s.v, rfc5054v)
+	}
+	if s.B.Cmp(mustHex(rfc5054B)) != 0 {
+		t.Errorf("B = %X, want %s", s.B, rfc5054B)
+	}
+	A := new(big.Int).Exp(grp.g, mustHex(rfc5054a), grp.N)
+	if A.Cmp(mustHex(rfc5054A)) != 0 {
+		t.Fatalf("A = %X, want %s", A, rfc5054A)
+	}
+	if u := grp.digest(grp.pad(A), grp.pad(s.B)); !bytes.Equal(u, mustDecodeHex(rfc5054u)) {
+		t.Errorf("u = %X, want %s", u, rfc5054u)
+	}
+
+	// the proof of a client holding the premaster secret of the RFC is accepted
+	key := grp.digest(grp.pad(mustHex(rfc5054Premaster)))
+	m1 := clientProof(grp, "alice", salt, A, s.B, key)
+	m2, err := s.verify(grp.pad(A), m1)
+	if err != nil {
+		t.Fatalf("verify() = %v, want the client proof accepted", err)
+	}
+	if !bytes.Equal(s.key, key) {
+		t.Errorf("K = %X, want H(premaster secret) %X", s.key, key)
+	}
+	if want := grp.digest(grp.pad(A), m1, key); !bytes.Equal(m2, want) {
+		t.Errorf("M2 = %X, want %X", m2, want)
+	}
+}
+
+func TestSRPPairSetup(t *testing.T) {
+	grp := pairSetupGroup
+	s, err := newSRPServer("031-45-154")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.publicKey()); n != 384 {
+		t.Errorf("B is %d bytes, want it padded to the 384 bytes of N", n)
+	}
+
+	// a client computing the premaster secret (B - k*g^x)^(a + u*x) with the setup code
+	proof := func(code string) (A *big.Int, m1, key []byte) {
+		a := big.NewInt(0x1234567890)
+		A = new(big.Int).Exp(grp.g, a, grp.N)
+		x := new(big.Int).SetBytes(grp.digest(s.salt, grp.digest([]byte(srpUsername+":"+code))))
+		u := new(big.Int).SetBytes(grp.digest(grp.pad(A), grp.pad(s.B)))
+		base := new(big.Int).Mul(grp.multiplier(), new(big.Int).Exp(grp.g, x, grp.N))
+		base.Sub(s.B, base).Mod(base, grp.N)
+		S := new(big.Int).Exp(base, new(big.Int).Add(a, new(big.Int).Mul(u, x)), grp.N)
+		key = grp.digest(grp.pad(S))
+		return A, clientProof(grp, srpUsername, s.salt, A, s.B, key), key
+	}
+
+	A, m1, _ := proof("031-45-154")
+	wrongA, wrongM1, _ := proof("031-45-155")
+	if _, err := s.verify(grp.pad(wrongA), wrongM1); err == nil || !strings.Contains(err.Error(), "invalid setup code") {
+		t.Errorf("verify() with a wrong setup code = %v, want it rejected", err)
+	}
+	if s.key != nil {
+		t.Error("the session key was set by a failed verify")
+	}
+	if _, err := s.verify(grp.pad(A), m1); err != nil {
+		t.Errorf("verify() with the setup code = %v", err)
+	}
+
+	// A = 0 mod N would make the premaster secret 0 whatever the setup code
+	for _, A := range []*big.Int{big.NewInt(0), grp.N, new(big.Int).Lsh(grp.N, 1)} {
+		if _, err := s.verify(A.Bytes(), m1); err == nil || !strings.Contains(err.Error(), "invalid SRP public key") {
+			t.Errorf("verify() with A = %X = 0 mod N returned %v, want it rejected", A, err)
+		}
+	}
+}
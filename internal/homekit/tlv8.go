@@ -0,0 +1,95 @@
+package homekit
+
+import "errors"
+
+// TLV8 types used by pairing.
+const (
+	tlvMethod        = 0x00
+	tlvIdentifier    = 0x01
+	tlvSalt          = 0x02
+	tlvPublicKey     = 0x03
+	tlvProof         = 0x04
+	tlvEncryptedData = 0x05
+	tlvState         = 0x06
+	tlvError         = 0x07
+	tlvSignature     = 0x0A
+	tlvPermissions   = 0x0B
+	tlvSeparator     = 0xFF
+)
+
+// TLV8 error codes.
+const (
+	tlvErrorUnknown        = 0x01
+	tlvErrorAuthentication = 0x02
+	tlvErrorMaxPeers       = 0x04
+	tlvErrorMaxTries       = 0x05
+	tlvErrorUnavailable    = 0x06
+	tlvErrorBusy           = 0x07
+)
+
+// tlvItem is a single type-length-value item.
+type tlvItem struct {
+	typ   byte
+	value []byte
+}
+
+// tlv8 is a list of items, in the order they are encoded.
+type tlv8 []tlvItem
+
+// get returns the value of the first item of a type.
+func (t tlv8) get(typ byte) ([]byte, bool) {
+	for _, item := range t {
+		if item.typ == typ {
+			return item.value, true
+		}
+	}
+	return nil, false
+}
+
+// byte returns the value of a single byte item, or 0.
+func (t tlv8) byte(typ byte) byte {
+	if v, ok := t.get(typ); ok && len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// encode encodes the items, splitting values longer than 255 bytes into fragments.
+func (t tlv8) encode() []byte {
+	var b []byte
+	for _, item := range t {
+		v := item.value
+		if len(v) == 0 {
+			b = append(b, item.typ, 0)
+			continue
+		}
+		for len(v) > 0 {
+			n := min(len(v), 255)
+			b = append(b, item.typ, byte(n))
+			b = append(b, v[:n]...)
+			v = v[n:]
+		}
+	}
+	return b
+}
+
+// decodeTLV8 decodes items, joining the fragments of consecutive items of the same type.
+func decodeTLV8(b []byte) (tlv8, error) {
+	var t tlv8
+	lastFull := false
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, errors.New("truncated TLV8")
+		}
+		typ, n := b[0], int(b[1])
+		value := b[2 : 2+n]
+		if lastFull && len(t) > 0 && t[len(t)-1].typ == typ {
+			t[len(t)-1].value = append(t[len(t)-1].value, value...)
+		} else {
+			t = append(t, tlvItem{typ, append([]byte(nil), value...)})
+		}
+		lastFull = n == 255
+		b = b[2+n:]
+	}
+	return t, nil
+}
@@ -0,0 +1,66 @@
+package homekit
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTLV8Encode(t *testing.T) {
+	long := bytes.Repeat([]byte{0xAB}, 300)
+	tests := []struct {
+		name string
+		tlv  tlv8
+		want []byte
+	}{
+		{"single byte", tlv8{{tlvState, []byte{1}}}, []byte{tlvState, 1, 1}},
+		{"empty value", tlv8{{tlvSeparator, nil}}, []byte{tlvSeparator, 0}},
+		{"exactly 255 bytes", tlv8{{tlvPublicKey, long[:255]}},
+			append([]byte{tlvPublicKey, 255}, long[:255]...)},
+		{"fragmented", tlv8{{tlvState, []byte{2}}, {tlvPublicKey, long}},
+			bytes.Join([][]byte{{tlvState, 1, 2}, {tlvPublicKey, 255}, long[:255], {tlvPublicKey, 45}, long[255:]}, nil)},
+		{"two fragments of 255", tlv8{{tlvEncryptedData, bytes.Repeat([]byte{1}, 510)}},
+			bytes.Join([][]byte{{tlvEncryptedData, 255}, bytes.Repeat([]byte{1}, 255), {tlvEncryptedData, 255}, bytes.Repeat([]byte{1}, 255)}, nil)},
+	}
+	for _, tt := range tests {
+		if got := tt.tlv.encode(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: encode() = %X, want %X", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTLV8Decode(t *testing.T) {
+	long := bytes.Repeat([]byte{0xCD}, 400)
+	encoded := tlv8{
+		{tlvState, []byte{3}},
+		{tlvPublicKey, long},
+		{tlvProof, []byte("proof")},
+	}.encode()
+	got, err := decodeTLV8(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("decoded %d items, want the fragments joined into 3", len(got))
+	}
+	if got.byte(tlvState) != 3 {
+		t.Errorf("state = %d, want 3", got.byte(tlvState))
+	}
+	if v, _ := got.get(tlvPublicKey); !bytes.Equal(v, long) {
+		t.Errorf("public key is %d bytes, want the 400 bytes joined", len(v))
+	}
+	if v, _ := got.get(tlvProof); string(v) != "proof" {
+		t.Errorf("proof = %q", v)
+	}
+
+	// items of the same type after a short fragment are separate, as in the list of pairings
+	list, err := decodeTLV8([]byte{tlvIdentifier, 1, 'a', tlvIdentifier, 1, 'b'})
+	if err != nil || len(list) != 2 {
+		t.Errorf("decodeTLV8() = %v, %v, want two items", list, err)
+	}
+
+	for _, truncated := range [][]byte{{tlvState}, {tlvState, 2, 1}, append([]byte{tlvPublicKey, 255}, long[:254]...)} {
+		if _, err := decodeTLV8(truncated); err == nil {
+			t.Errorf("decodeTLV8(%X) accepted a truncated item", truncated)
+		}
+	}
+}
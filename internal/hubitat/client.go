@@ -3,33 +3,108 @@ package hubitat
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type DeviceInfo struct {
 	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Label        string           `json:"label"`
 	Type         string           `json:"type"`
 	Attributes   []map[string]any `json:"attributes"`
 	Capabilities []any            `json:"capabilities"`
 	Commands     []string         `json:"commands"`
 }
 
+// AttributeValue returns the current value of an attribute, or "" when the device has no such attribute.
+func (d *DeviceInfo) AttributeValue(name string) string {
+	for _, attr := range d.Attributes {
+		if attr["name"] == name {
+			if value, ok := attr["currentValue"]; ok && value != nil {
+				return fmt.Sprint(value)
+			}
+		}
+	}
+	return ""
+}
+
+// Device represents a device summary as returned by the Maker API device list.
+type Device struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Label        string   `json:"label"`
+	Type         string   `json:"type"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// DisplayName returns the device label, falling back to its name.
+func (d Device) DisplayName() string {
+	if d.Label != "" {
+		return d.Label
+	}
+	return d.Name
+}
+
+// HasCapability checks if the device has a given capability.
+func (d Device) HasCapability(capabilityName string) bool {
+	for _, capability := range d.Capabilities {
+		if capability == capabilityName {
+			return true
+		}
+	}
+	return false
+}
+
 type Client struct {
 	baseURL     string
 	accessToken string
 	client      *http.Client
+	observe     RequestObserver
+}
+
+// RequestObserver is called after every API request with the endpoint path, where device IDs
+// are replaced by ":id", the response status (0 when no response was received) and the duration.
+type RequestObserver func(method, endpoint string, status int, duration time.Duration)
+
+// SetRequestObserver sets the function called after every API request.
+func (c *Client) SetRequestObserver(observe RequestObserver) {
+	c.observe = observe
 }
 
-func NewClient(baseUrl string, accessToken string) *Client {
+// do sends a request, reporting it to the request observer under endpoint.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if c.observe != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		c.observe(req.Method, endpoint, status, time.Since(start))
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// keep the access token out of logs and notifications
+		urlErr.URL, _, _ = strings.Cut(urlErr.URL, "?")
+	}
+	return resp, err
+}
+
+// NewClient creates a Maker API client. tlsConfig controls how the certificate of the
+// hub is verified, nil verifies it against the system CAs.
+func NewClient(baseUrl string, accessToken string, tlsConfig *tls.Config) *Client {
 	return &Client{
 		baseURL:     baseUrl,
 		accessToken: accessToken,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: tlsConfig,
 			},
 		},
 	}
@@ -61,7 +136,7 @@ func (c *Client) GetDeviceInfo(deviceID string) (*DeviceInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req, "/devices/:id")
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +151,28 @@ func (c *Client) GetDeviceInfo(deviceID string) (*DeviceInfo, error) {
 	return &info, nil
 }
 
+// ListDevices fetches all devices exposed through the Maker API instance.
+func (c *Client) ListDevices() ([]Device, error) {
+	url := fmt.Sprintf("%s/devices/all?access_token=%s", c.baseURL, c.accessToken)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, "/devices/all")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var devices []Device
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
 // sendDeviceCommand sends a command to a Hubitat device.
 // deviceID: the device ID as a string
 // command: the command to send (e.g., "on", "off", "lock", "unlock")
@@ -92,13 +189,15 @@ func (c *Client) sendDeviceCommand(deviceID, command, secondaryValue string) err
 		return err
 	}
 
-	if resp, err := c.client.Do(req); err != nil {
+	resp, err := c.do(req, "/devices/:id/"+command)
+	if err != nil {
 		return err
-	} else if resp.StatusCode != http.StatusOK {
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	} else {
-		return nil
 	}
+	return nil
 }
 
 // assertDeviceState checks if a device has a capability, command, and attribute value, and sends a command if needed.
@@ -129,6 +228,54 @@ func (c *Client) assertDeviceState(deviceID, capability, command, attributeName,
 	return nil
 }
 
+// verifyDevice checks that a device exists and has the capability and commands needed to drive it.
+func (c *Client) verifyDevice(deviceID, capability string, commands ...string) error {
+	deviceInfo, err := c.GetDeviceInfo(deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get device info for device %s: %w", deviceID, err)
+	}
+
+	if !hasCapability(deviceInfo, capability) {
+		return fmt.Errorf("device %s does not have %s capability", deviceID, capability)
+	}
+
+	for _, command := range commands {
+		if !hasCommand(deviceInfo, command) {
+			return fmt.Errorf("device %s does not support %s command", deviceID, command)
+		}
+	}
+
+	return nil
+}
+
+// VerifyContactDevice checks that a device can be used as a door contact sensor.
+func (c *Client) VerifyContactDevice(deviceID string) error {
+	return c.verifyDevice(deviceID, "ContactSensor", "open", "close")
+}
+
+// VerifyLockDevice checks that a device can be used as a door lock.
+func (c *Client) VerifyLockDevice(deviceID string) error {
+	return c.verifyDevice(deviceID, "Lock", "lock", "unlock")
+}
+
+// VerifySwitchDevice checks that a device can be used as a door switch.
+func (c *Client) VerifySwitchDevice(deviceID string) error {
+	return c.verifyDevice(deviceID, "Switch", "on", "off")
+}
+
+// VerifyNotificationDevice checks that a device can show notifications.
+func (c *Client) VerifyNotificationDevice(deviceID string) error {
+	return c.verifyDevice(deviceID, "Notification", "deviceNotification")
+}
+
+// SendNotification sends a text to a notification device, e.g. the Hubitat mobile app.
+func (c *Client) SendNotification(deviceID, text string) error {
+	if err := c.sendDeviceCommand(deviceID, "deviceNotification", url.PathEscape(text)); err != nil {
+		return fmt.Errorf("failed to send notification to device %s: %w", deviceID, err)
+	}
+	return nil
+}
+
 func (c *Client) AssertDoorContactOpened(doorID string) error {
 	return c.assertDeviceState(doorID, "ContactSensor", "open", "contact", "open")
 }
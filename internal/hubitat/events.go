@@ -24,20 +24,20 @@ type WebhookEvent struct {
 
 // WebhookHandler handles incoming Hubitat Access webhook requests
 type WebhookHandler struct {
-	authToken string
+	authorize func(token string) bool
 	onEvent   func(WebhookEvent)
 	wg        *sync.WaitGroup
 }
 
-// NewWebhookHandler creates a new handler
-func NewWebhookHandler(authToken string, onEvent func(WebhookEvent), wg *sync.WaitGroup) *WebhookHandler {
-	return &WebhookHandler{authToken: authToken, onEvent: onEvent, wg: wg}
+// NewWebhookHandler creates a new handler. authorize checks the token sent in the authorization query parameter.
+func NewWebhookHandler(authorize func(token string) bool, onEvent func(WebhookEvent), wg *sync.WaitGroup) *WebhookHandler {
+	return &WebhookHandler{authorize: authorize, onEvent: onEvent, wg: wg}
 }
 
 // ServeHTTP implements http.Handler for WebhookHandler
 func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	authToken := r.URL.Query().Get("authorization")
-	if authToken != h.authToken {
+	if !h.authorize(authToken) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		log.Printf("Invalid auth token")
 		return
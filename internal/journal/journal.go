@@ -0,0 +1,263 @@
+// Package journal keeps door events on disk as JSON lines, one file per day, so they can be
+// queried long after they have left memory and the logs.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+)
+
+// dayLayout names the file of each day, the days are in UTC.
+const dayLayout = "2006-01-02"
+
+const fileExt = ".jsonl"
+
+// Journal appends events to the file of the current day.
+type Journal struct {
+	mu   sync.Mutex
+	dir  string
+	day  string
+	file *os.File
+}
+
+// Open opens the journal in dir, creating the directory when needed.
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating journal directory failed: %w", err)
+	}
+	return &Journal{dir: dir}, nil
+}
+
+// Append writes an event to the journal.
+func (j *Journal) Append(e events.Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event failed: %w", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	day := e.Time.UTC().Format(dayLayout)
+	if j.file == nil || day != j.day {
+		if j.file != nil {
+			j.file.Close()
+			j.file = nil
+		}
+		f, err := os.OpenFile(j.path(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("opening journal file failed: %w", err)
+		}
+		j.file, j.day = f, day
+	}
+	if _, err := j.file.Write(line); err != nil {
+		return fmt.Errorf("writing journal failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the current journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *Journal) path(day string) string {
+	return filepath.Join(j.dir, day+fileExt)
+}
+
+// days returns the days that have a journal file, oldest first.
+func (j *Journal) days() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), fileExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	slices.Sort(days)
+	return days, nil
+}
+
+// Query selects events. Empty fields match every event.
+type Query struct {
+	DoorIDs []string
+	Kinds   []string
+	Sources []string
+	// Actor matches the actor ignoring case.
+	Actor string
+	// Since and Until limit the events to [Since, Until).
+	Since time.Time
+	Until time.Time
+}
+
+// Matches reports whether the event is selected by the query.
+func (q *Query) Matches(e events.Event) bool {
+	return (len(q.DoorIDs) == 0 || slices.Contains(q.DoorIDs, e.DoorID)) &&
+		(len(q.Kinds) == 0 || slices.Contains(q.Kinds, e.Type)) &&
+		(len(q.Sources) == 0 || slices.Contains(q.Sources, e.Source)) &&
+		(q.Actor == "" || strings.EqualFold(q.Actor, e.Actor)) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// errStop ends a query early.
+var errStop = errors.New("stop")
+
+// Query calls fn with the events matching q, oldest first, until fn returns false.
+// Lines that can't be decoded, e.g. one cut short by a crash, are skipped.
+func (j *Journal) Query(q Query, fn func(events.Event) bool) error {
+	j.mu.Lock()
+	days, err := j.days()
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		start, _ := time.Parse(dayLayout, day)
+		if !q.Since.IsZero() && !start.AddDate(0, 0, 1).After(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !start.Before(q.Until) {
+			break
+		}
+		err := j.scan(day, func(e events.Event) error {
+			if q.Matches(e) && !fn(e) {
+				return errStop
+			}
+			return nil
+		})
+		if errors.Is(err, errStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastID returns the highest event ID in the file of the latest day with events, or 0 when the
+// journal is empty, so the IDs of new events can continue after it.
+func (j *Journal) LastID() (uint64, error) {
+	j.mu.Lock()
+	days, err := j.days()
+	j.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		var last uint64
+		err := j.scan(days[i], func(e events.Event) error {
+			last = max(last, e.ID)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		if last > 0 {
+			return last, nil
+		}
+	}
+	return 0, nil
+}
+
+func (j *Journal) scan(day string, fn func(events.Event) error) error {
+	f, err := os.Open(j.path(day))
+	if errors.Is(err, os.ErrNotExist) {
+		// removed by the retention in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Prune removes the files of the days before maxAge, and the oldest files while the journal
+// is larger than maxSize. A zero limit is not applied. The file of the current day is kept.
+func (j *Journal) Prune(maxAge time.Duration, maxSize int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	days, err := j.days()
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Format(dayLayout)
+
+	sizes := make(map[string]int64, len(days))
+	var total int64
+	for _, day := range days {
+		info, err := os.Stat(j.path(day))
+		if err != nil {
+			continue
+		}
+		sizes[day] = info.Size()
+		total += info.Size()
+	}
+
+	var cutoff string
+	if maxAge > 0 {
+		// a day is only removed once all of its events are too old
+		cutoff = time.Now().Add(-maxAge).UTC().AddDate(0, 0, -1).Format(dayLayout)
+	}
+	var errs []error
+	for _, day := range days {
+		if day == today || day == j.day {
+			break
+		}
+		tooOld := cutoff != "" && day <= cutoff
+		tooLarge := maxSize > 0 && total > maxSize
+		if !tooOld && !tooLarge {
+			break
+		}
+		if err := os.Remove(j.path(day)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		total -= sizes[day]
+		log.Printf("Removed journal of %s", day)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package journal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+)
+
+func TestLastIDContinuesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := j.LastID(); err != nil || id != 0 {
+		t.Fatalf("LastID() of an empty journal = %d, %v, want 0", id, err)
+	}
+
+	bus := events.NewBus(10)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	for i := range 3 {
+		e := bus.Publish(events.Event{Time: yesterday.Add(time.Duration(i) * time.Second), Type: "unlock"})
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Append(bus.Publish(events.Event{Type: "position"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a file without events, e.g. cut short by a crash, is skipped
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(dayLayout)
+	if err := os.WriteFile(j.path(tomorrow), []byte("{\"id\":"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart starts with a new bus
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	last, err := j.LastID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 4 {
+		t.Fatalf("LastID() = %d, want 4", last)
+	}
+	restarted := events.NewBus(10)
+	restarted.ResumeAfter(last)
+	if e := restarted.Publish(events.Event{Type: "unlock"}); e.ID != 5 {
+		t.Errorf("first ID after the restart = %d, want 5", e.ID)
+	}
+}
@@ -0,0 +1,393 @@
+// Package mqtt is an MQTT 3.1.1 client, as far as publishing retained states and receiving
+// commands at QoS 0 needs it.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	packetConnect     = 1
+	packetConnAck     = 2
+	packetPublish     = 3
+	packetPubAck      = 4
+	packetSubscribe   = 8
+	packetSubAck      = 9
+	packetPingReq     = 12
+	packetPingResp    = 13
+	packetDisconnect  = 14
+	defaultKeepAlive  = 60 * time.Second
+	maxBackoff        = time.Minute
+	maxPacketSize     = 1 << 20
+	writeTimeout      = 10 * time.Second
+	connectTimeout    = 10 * time.Second
+	subscribeQoS      = 0
+	protocolLevel3_11 = 4
+)
+
+// ErrNotConnected is returned when publishing or subscribing while there is no connection.
+var ErrNotConnected = errors.New("not connected to the MQTT broker")
+
+// connectErrors are the reasons a broker refuses a connection, by return code.
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Message is a message published to a topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options are the connection settings of a client.
+type Options struct {
+	// Broker is the URL of the broker, tcp:// or mqtt:// for plain connections and ssl://,
+	// tls:// or mqtts:// for TLS. The port defaults to 1883 and 8883.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TLSConfig controls how the certificate of the broker is verified, nil verifies it against the system CAs.
+	TLSConfig *tls.Config
+	// KeepAlive is how often the connection is checked, default 60s.
+	KeepAlive time.Duration
+	// Will is published by the broker when the connection is lost, and by the client itself on a clean disconnect.
+	Will *Message
+}
+
+type Client struct {
+	opts      Options
+	mu        sync.Mutex // guards conn and writes
+	conn      net.Conn
+	nextID    uint16
+	connected atomic.Bool
+}
+
+// NewClient creates a client, Run connects it.
+func NewClient(opts Options) *Client {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	return &Client{opts: opts}
+}
+
+// Connected reports whether the client is connected to the broker.
+func (c *Client) Connected() bool {
+	return c.connected.Load()
+}
+
+// Run keeps the client connected until ctx is cancelled, reconnecting whenever the connection
+// drops. onConnect is called after every connection, to subscribe and publish the current
+// state, and onMessage for every message received on a subscribed topic.
+func (c *Client) Run(ctx context.Context, onConnect func(), onMessage func(Message)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := c.run(ctx, onConnect, onMessage)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("MQTT connection lost, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// run handles a single connection.
+func (c *Client) run(ctx context.Context, onConnect func(), onMessage func(Message)) error {
+	conn, br, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	c.connected.Store(true)
+	defer func() {
+		c.connected.Store(false)
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	// disconnect cleanly when ctx is cancelled, which also unblocks the reads
+	stop := context.AfterFunc(ctx, func() {
+		if will := c.opts.Will; will != nil {
+			c.Publish(will.Topic, will.Payload, will.Retain)
+		}
+		c.writePacket(packetDisconnect<<4, nil)
+		conn.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(c.opts.KeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.writePacket(packetPingReq<<4, nil)
+			}
+		}
+	}()
+
+	onConnect()
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive + c.opts.KeepAlive/2))
+		header, body, err := readPacket(br)
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case packetPublish:
+			msg, packetID, err := parsePublish(header, body)
+			if err != nil {
+				return err
+			}
+			if packetID != 0 {
+				c.writePacket(packetPubAck<<4, binary.BigEndian.AppendUint16(nil, packetID))
+			}
+			onMessage(msg)
+		case packetSubAck:
+			for _, code := range body[min(2, len(body)):] {
+				if code == 0x80 {
+					log.Printf("MQTT broker refused a subscription")
+				}
+			}
+		case packetPingResp, packetPubAck:
+		default:
+			return fmt.Errorf("unexpected MQTT packet type %d", header>>4)
+		}
+	}
+}
+
+// connect opens the network connection and exchanges CONNECT and CONNACK.
+func (c *Client) connect(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	u, err := url.Parse(c.opts.Broker)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		cfg := &tls.Config{}
+		if c.opts.TLSConfig != nil {
+			cfg = c.opts.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, "tcp", hostPort(u, "8883"))
+	default:
+		return nil, nil, fmt.Errorf("unsupported MQTT broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var flags byte = 0x02 // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel3_11, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.opts.KeepAlive/time.Second))
+	payload := appendString(nil, c.opts.ClientID)
+	if will := c.opts.Will; will != nil {
+		flags |= 0x04
+		if will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, will.Topic)
+		payload = appendBytes(payload, will.Payload)
+	}
+	if c.opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, c.opts.Username)
+		if c.opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, c.opts.Password)
+		}
+	}
+	body[7] = flags
+
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	if _, err := conn.Write(encodePacket(packetConnect<<4, append(body, payload...))); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	header, ack, err := readPacket(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if header>>4 != packetConnAck || len(ack) != 2 {
+		conn.Close()
+		return nil, nil, errors.New("invalid CONNACK")
+	}
+	if code := ack[1]; code != 0 {
+		conn.Close()
+		if reason, ok := connectErrors[code]; ok {
+			return nil, nil, fmt.Errorf("connection refused: %s", reason)
+		}
+		return nil, nil, fmt.Errorf("connection refused with code %d", code)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, br, nil
+}
+
+// Publish sends a message at QoS 0.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	var header byte = packetPublish << 4
+	if retain {
+		header |= 0x01
+	}
+	return c.writePacket(header, append(appendString(nil, topic), payload...))
+}
+
+// Subscribe subscribes to topic filters at QoS 0. A subscription the broker refuses is logged.
+func (c *Client) Subscribe(filters ...string) error {
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	body := binary.BigEndian.AppendUint16(nil, c.nextID)
+	c.mu.Unlock()
+	for _, f := range filters {
+		body = append(appendString(body, f), subscribeQoS)
+	}
+	return c.writePacket(packetSubscribe<<4|0x02, body)
+}
+
+// writePacket sends a packet on the current connection.
+func (c *Client) writePacket(header byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(encodePacket(header, body))
+	return err
+}
+
+// hostPort returns the host and port of a broker URL, with the default port if it has none.
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+// encodePacket prepends the fixed header to a packet body.
+func encodePacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// readPacket reads the next packet, returning the first byte of its fixed header and its body.
+func readPacket(br *bufio.Reader) (byte, []byte, error) {
+	header, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("invalid MQTT packet length")
+		}
+		multiplier *= 128
+	}
+	if length > maxPacketSize {
+		return 0, nil, errors.New("MQTT packet too large")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// parsePublish decodes a PUBLISH packet, the packet ID is 0 at QoS 0.
+func parsePublish(header byte, body []byte) (Message, uint16, error) {
+	if len(body) < 2 {
+		return Message{}, 0, errors.New("invalid PUBLISH")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return Message{}, 0, errors.New("invalid PUBLISH")
+	}
+	msg := Message{Topic: string(body[2 : 2+n]), Retain: header&0x01 != 0}
+	rest := body[2+n:]
+	var packetID uint16
+	if (header>>1)&0x03 > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, errors.New("invalid PUBLISH")
+		}
+		packetID, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	msg.Payload = rest
+	return msg, packetID, nil
+}
+
+// appendString appends a length prefixed UTF-8 string.
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+// appendBytes appends length prefixed binary data.
+func appendBytes(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
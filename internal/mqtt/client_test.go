@@ -0,0 +1,342 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packet is a packet the fake broker received.
+type packet struct {
+	header byte
+	body   []byte
+}
+
+// fakeBroker accepts connections on a local port and hands the packets it receives to a channel.
+type fakeBroker struct {
+	t        *testing.T
+	ln       net.Listener
+	connAck  byte // return code of the CONNACK
+	packets  chan packet
+	connects chan connectPacket
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// connectPacket is a decoded CONNECT packet.
+type connectPacket struct {
+	flags              byte
+	keepAlive          uint16
+	clientID           string
+	willTopic          string
+	willPayload        string
+	username, password string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, ln: ln, packets: make(chan packet, 100), connects: make(chan connectPacket, 10)}
+	go b.accept()
+	t.Cleanup(func() {
+		ln.Close()
+		b.dropAll()
+	})
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+		go b.serve(conn)
+	}
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	header, body, err := readPacket(br)
+	if err != nil || header>>4 != packetConnect {
+		b.t.Errorf("first packet = %x, %v, want CONNECT", header, err)
+		return
+	}
+	b.connects <- parseConnect(b.t, body)
+	conn.Write(encodePacket(packetConnAck<<4, []byte{0, b.connAck}))
+	if b.connAck != 0 {
+		conn.Close()
+		return
+	}
+	for {
+		header, body, err := readPacket(br)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPingReq:
+			conn.Write(encodePacket(packetPingResp<<4, nil))
+			continue
+		case packetSubscribe:
+			conn.Write(encodePacket(packetSubAck<<4, append(body[:2:2], 0)))
+		}
+		b.packets <- packet{header, body}
+	}
+}
+
+// send writes a packet to every open connection.
+func (b *fakeBroker) send(header byte, body []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		conn.Write(encodePacket(header, body))
+	}
+}
+
+// dropAll closes the open connections.
+func (b *fakeBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *fakeBroker) next() packet {
+	b.t.Helper()
+	select {
+	case p := <-b.packets:
+		return p
+	case <-time.After(5 * time.Second):
+		b.t.Fatal("no packet received")
+		return packet{}
+	}
+}
+
+func (b *fakeBroker) nextConnect() connectPacket {
+	b.t.Helper()
+	select {
+	case c := <-b.connects:
+		return c
+	case <-time.After(5 * time.Second):
+		b.t.Fatal("no CONNECT received")
+		return connectPacket{}
+	}
+}
+
+func parseConnect(t *testing.T, body []byte) connectPacket {
+	r := bytes.NewReader(body)
+	str := func() string {
+		var n uint16
+		binary.Read(r, binary.BigEndian, &n)
+		s := make([]byte, n)
+		r.Read(s)
+		return string(s)
+	}
+	if name := str(); name != "MQTT" {
+		t.Errorf("protocol name = %q", name)
+	}
+	if level, _ := r.ReadByte(); level != protocolLevel3_11 {
+		t.Errorf("protocol level = %d", level)
+	}
+	var c connectPacket
+	c.flags, _ = r.ReadByte()
+	binary.Read(r, binary.BigEndian, &c.keepAlive)
+	c.clientID = str()
+	if c.flags&0x04 != 0 {
+		c.willTopic, c.willPayload = str(), str()
+	}
+	if c.flags&0x80 != 0 {
+		c.username = str()
+	}
+	if c.flags&0x40 != 0 {
+		c.password = str()
+	}
+	return c
+}
+
+// parsePublished decodes a PUBLISH packet the broker received.
+func parsePublished(t *testing.T, p packet) Message {
+	t.Helper()
+	if p.header>>4 != packetPublish {
+		t.Fatalf("packet type = %d, want PUBLISH", p.header>>4)
+	}
+	msg, _, err := parsePublish(p.header, p.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// run runs a client of the broker until the test ends, the channel receives its messages.
+func run(t *testing.T, opts Options, onConnect func(c *Client)) (*Client, <-chan Message, context.CancelFunc) {
+	t.Helper()
+	c := NewClient(opts)
+	messages := make(chan Message, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, func() { onConnect(c) }, func(m Message) { messages <- m })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return c, messages, cancel
+}
+
+var will = &Message{Topic: "uahm/status", Payload: []byte("offline"), Retain: true}
+
+func TestConnectSendsWillAndCredentials(t *testing.T) {
+	b := newFakeBroker(t)
+	run(t, Options{Broker: b.url(), ClientID: "uahm", Username: "user", Password: "secret", KeepAlive: 30 * time.Second, Will: will},
+		func(*Client) {})
+
+	got := b.nextConnect()
+	want := connectPacket{
+		// user name, password, will retain, will flag, clean session
+		flags:     0x80 | 0x40 | 0x20 | 0x04 | 0x02,
+		keepAlive: 30,
+		clientID:  "uahm", willTopic: "uahm/status", willPayload: "offline",
+		username: "user", password: "secret",
+	}
+	if got != want {
+		t.Errorf("CONNECT = %+v, want %+v", got, want)
+	}
+}
+
+func TestOnlineAndWill(t *testing.T) {
+	b := newFakeBroker(t)
+	c, _, cancel := run(t, Options{Broker: b.url(), Will: will}, func(c *Client) {
+		c.Publish("uahm/status", []byte("online"), true)
+	})
+
+	b.nextConnect()
+	online := parsePublished(t, b.next())
+	if online.Topic != "uahm/status" || string(online.Payload) != "online" || !online.Retain {
+		t.Errorf("published %+v, want online retained", online)
+	}
+	if !c.Connected() {
+		t.Error("Connected() = false after the CONNACK")
+	}
+
+	// a clean disconnect doesn't make the broker publish the will, so the client does
+	cancel()
+	offline := parsePublished(t, b.next())
+	if offline.Topic != "uahm/status" || string(offline.Payload) != "offline" || !offline.Retain {
+		t.Errorf("published %+v on disconnect, want the will", offline)
+	}
+	if p := b.next(); p.header>>4 != packetDisconnect {
+		t.Errorf("packet type = %d after the will, want DISCONNECT", p.header>>4)
+	}
+}
+
+func TestReceivesMessages(t *testing.T) {
+	b := newFakeBroker(t)
+	_, messages, _ := run(t, Options{Broker: b.url()}, func(c *Client) {
+		c.Subscribe("uahm/doors/+/set")
+	})
+
+	b.nextConnect()
+	sub := b.next()
+	if sub.header != packetSubscribe<<4|0x02 {
+		t.Errorf("SUBSCRIBE header = %x", sub.header)
+	}
+	if filter := string(sub.body[4 : len(sub.body)-1]); filter != "uahm/doors/+/set" {
+		t.Errorf("subscribed to %q", filter)
+	}
+
+	// a retained message at QoS 0, as the broker sends it on subscribing
+	b.send(packetPublish<<4|0x01, append(appendString(nil, "uahm/doors/d1/set"), "unlock"...))
+	// a message at QoS 1, which is acknowledged
+	b.send(packetPublish<<4|0x02, append(binary.BigEndian.AppendUint16(appendString(nil, "uahm/doors/d2/set"), 0x1234), "lock"...))
+
+	for _, want := range []Message{
+		{Topic: "uahm/doors/d1/set", Payload: []byte("unlock"), Retain: true},
+		{Topic: "uahm/doors/d2/set", Payload: []byte("lock")},
+	} {
+		select {
+		case got := <-messages:
+			if got.Topic != want.Topic || string(got.Payload) != string(want.Payload) || got.Retain != want.Retain {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+	if ack := b.next(); ack.header != packetPubAck<<4 || !bytes.Equal(ack.body, []byte{0x12, 0x34}) {
+		t.Errorf("acknowledgement = %x %x, want PUBACK of packet 0x1234", ack.header, ack.body)
+	}
+}
+
+func TestReconnects(t *testing.T) {
+	b := newFakeBroker(t)
+	connects := make(chan struct{}, 10)
+	c, _, _ := run(t, Options{Broker: b.url()}, func(*Client) { connects <- struct{}{} })
+
+	for i := range 2 {
+		select {
+		case <-connects:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection %d not made", i+1)
+		}
+		b.nextConnect()
+		if i == 0 {
+			b.dropAll()
+		}
+	}
+	if !c.Connected() {
+		t.Error("Connected() = false after reconnecting")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newFakeBroker(t)
+	b.connAck = 5
+	c := NewClient(Options{Broker: b.url()})
+	_, _, err := c.connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("connect() = %v, want it refused as not authorized", err)
+	}
+	if err := c.Publish("topic", nil, false); err != ErrNotConnected {
+		t.Errorf("Publish() without a connection = %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestPacketLength(t *testing.T) {
+	// the remaining length takes one to four bytes
+	for _, n := range []int{0, 127, 128, 16383, 16384, 1 << 20} {
+		packet := encodePacket(packetPublish<<4, make([]byte, n))
+		header, body, err := readPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil || header != packetPublish<<4 || len(body) != n {
+			t.Errorf("length %d read back as %d, %v", n, len(body), err)
+		}
+	}
+
+	tooLarge := encodePacket(packetPublish<<4, make([]byte, maxPacketSize+1))
+	if _, _, err := readPacket(bufio.NewReader(bytes.NewReader(tooLarge))); err == nil {
+		t.Error("readPacket() accepted a packet over the maximum size")
+	}
+	if _, _, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{packetPublish << 4, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}))); err == nil {
+		t.Error("readPacket() accepted a remaining length of five bytes")
+	}
+}
@@ -0,0 +1,66 @@
+// Package notify sends alerts to people through email, HTTP push services such as ntfy, or
+// any other channel, and decides when they are quiet.
+package notify
+
+import (
+	"context"
+	"text/template"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/schedule"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+// Message is a notification.
+type Message struct {
+	Title string
+	Body  string
+	// Priority is a hint for channels that support it, "high" for urgent messages and "" otherwise.
+	Priority string
+}
+
+// Channel delivers messages.
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ChannelFunc adapts a function to a Channel.
+type ChannelFunc func(ctx context.Context, msg Message) error
+
+// Send calls f.
+func (f ChannelFunc) Send(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// ParseTemplate parses a title or body template.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return utils.ParseTemplate(name, text)
+}
+
+// QuietHours is a daily period, possibly crossing midnight, in which notifications are not sent.
+type QuietHours struct {
+	start, end time.Duration // since midnight
+}
+
+// ParseQuietHours parses the start and end of quiet hours, e.g. "22:00" and "07:00".
+func ParseQuietHours(start, end string) (QuietHours, error) {
+	s, err := schedule.ParseClock(start)
+	if err != nil {
+		return QuietHours{}, err
+	}
+	e, err := schedule.ParseClock(end)
+	if err != nil {
+		return QuietHours{}, err
+	}
+	return QuietHours{start: s, end: e}, nil
+}
+
+// Contains reports whether t, in its own location, is within the quiet hours. The time of day
+// is read from the clock, as the time since midnight is an hour off on daylight saving days.
+func (q QuietHours) Contains(t time.Time) bool {
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if q.start <= q.end {
+		return now >= q.start && now < q.end
+	}
+	return now >= q.start || now < q.end
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuietHoursContains(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available:", err)
+	}
+	tests := []struct {
+		start, end string
+		t          time.Time
+		want       bool
+	}{
+		{"22:00", "07:00", time.Date(2026, 6, 1, 21, 59, 0, 0, time.UTC), false},
+		{"22:00", "07:00", time.Date(2026, 6, 1, 22, 0, 0, 0, time.UTC), true},
+		{"22:00", "07:00", time.Date(2026, 6, 2, 3, 0, 0, 0, time.UTC), true},
+		{"22:00", "07:00", time.Date(2026, 6, 2, 6, 59, 59, 0, time.UTC), true},
+		{"22:00", "07:00", time.Date(2026, 6, 2, 7, 0, 0, 0, time.UTC), false},
+		{"09:00", "17:00", time.Date(2026, 6, 1, 8, 59, 0, 0, time.UTC), false},
+		{"09:00", "17:00", time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC), true},
+		{"09:00", "17:00", time.Date(2026, 6, 1, 17, 0, 0, 0, time.UTC), false},
+		// on daylight saving days the wall clock is not the time since midnight
+		{"10:00", "11:00", time.Date(2026, 3, 8, 10, 30, 0, 0, newYork), true},
+		{"10:00", "11:00", time.Date(2026, 11, 1, 10, 30, 0, 0, newYork), true},
+		{"10:00", "11:00", time.Date(2026, 3, 8, 9, 30, 0, 0, newYork), false},
+		{"10:00", "11:00", time.Date(2026, 11, 1, 11, 30, 0, 0, newYork), false},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuietHours(tt.start, tt.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Contains(tt.t); got != tt.want {
+			t.Errorf("%s-%s Contains(%s) = %t, want %t", tt.start, tt.end, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	if _, err := ParseQuietHours("22:00", "7"); err == nil || !strings.Contains(err.Error(), "HH:MM") {
+		t.Errorf("ParseQuietHours() = %v, want the invalid end rejected", err)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("test", `{{upper .Door}} {{json .Actor}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]string{"Door": "Front", "Actor": `Jo "J" Doe`}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `FRONT "Jo \"J\" Doe"`; b.String() != want {
+		t.Errorf("rendered %q, want %q", b.String(), want)
+	}
+	if err := tmpl.Execute(&b, map[string]string{"Door": "Front"}); err == nil {
+		t.Error("a missing key rendered without an error")
+	}
+	if _, err := ParseTemplate("test", "{{.Door"); err == nil {
+		t.Error("ParseTemplate() accepted an unclosed action")
+	}
+}
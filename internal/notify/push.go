@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PushOptions configure an ntfy-style push channel.
+type PushOptions struct {
+	// URL is the topic, e.g. https://ntfy.sh/my-doors.
+	URL string
+	// Token is sent as a bearer token when set.
+	Token string
+	// Priority is the ntfy priority, 1 to 5 or min, low, default, high, urgent. High priority
+	// messages are sent as urgent when it is empty.
+	Priority  string
+	Tags      []string
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+}
+
+// Push posts messages to an ntfy-style HTTP endpoint: the body is the message text and the
+// title, priority and tags are headers.
+type Push struct {
+	opts       PushOptions
+	httpClient *http.Client
+}
+
+// NewPush creates a push channel.
+func NewPush(opts PushOptions) *Push {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLSConfig
+	return &Push{
+		opts:       opts,
+		httpClient: &http.Client{Timeout: opts.Timeout, Transport: transport},
+	}
+}
+
+// Send posts a message.
+func (p *Push) Send(ctx context.Context, msg Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.URL, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if msg.Title != "" {
+		req.Header.Set("Title", mime.QEncoding.Encode("utf-8", msg.Title))
+	}
+	priority := p.opts.Priority
+	if priority == "" && msg.Priority == "high" {
+		priority = "urgent"
+	}
+	if priority != "" {
+		req.Header.Set("Priority", priority)
+	}
+	if len(p.opts.Tags) > 0 {
+		req.Header.Set("Tags", strings.Join(p.opts.Tags, ","))
+	}
+	if p.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.opts.Token)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pushRequest is what the push receiver got.
+type pushRequest struct {
+	header http.Header
+	body   string
+}
+
+func newPushReceiver(t *testing.T, status int) (*httptest.Server, <-chan pushRequest) {
+	t.Helper()
+	requests := make(chan pushRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushRequest{r.Header, string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestPushSend(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         PushOptions
+		msg          Message
+		wantPriority string
+	}{
+		{"default priority", PushOptions{}, Message{Title: "Access denied", Body: "Denied at Front"}, ""},
+		{"high priority is urgent", PushOptions{}, Message{Title: "Forced open", Body: "b", Priority: "high"}, "urgent"},
+		{"configured priority", PushOptions{Priority: "low"}, Message{Title: "Forced open", Body: "b", Priority: "high"}, "low"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newPushReceiver(t, http.StatusOK)
+			tt.opts.URL = srv.URL + "/doors"
+			if err := NewPush(tt.opts).Send(context.Background(), tt.msg); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+			r := <-requests
+			if r.body != tt.msg.Body || r.header.Get("Title") != tt.msg.Title {
+				t.Errorf("sent %q titled %q", r.body, r.header.Get("Title"))
+			}
+			if got := r.header.Get("Priority"); got != tt.wantPriority {
+				t.Errorf("Priority = %q, want %q", got, tt.wantPriority)
+			}
+		})
+	}
+}
+
+func TestPushHeaders(t *testing.T) {
+	srv, requests := newPushReceiver(t, http.StatusOK)
+	push := NewPush(PushOptions{URL: srv.URL, Token: "tk_secret", Tags: []string{"door", "warning"}})
+	if err := push.Send(context.Background(), Message{Title: "Tür offen", Body: "Haustür"}); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	for name, want := range map[string]string{
+		"Authorization": "Bearer tk_secret",
+		"Tags":          "door,warning",
+		// headers are ASCII, so the title is encoded
+		"Title":        "=?utf-8?q?T=C3=BCr_offen?=",
+		"Content-Type": "text/plain; charset=utf-8",
+	} {
+		if got := r.header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPushFailure(t *testing.T) {
+	srv, _ := newPushReceiver(t, http.StatusForbidden)
+	err := NewPush(PushOptions{URL: srv.URL}).Send(context.Background(), Message{Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Send() = %v, want the status reported", err)
+	}
+}
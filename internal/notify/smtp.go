@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP security modes.
+const (
+	// SecurityStartTLS upgrades the connection with STARTTLS, required unless the server is on localhost.
+	SecurityStartTLS = "starttls"
+	// SecurityTLS connects with TLS right away, usually on port 465.
+	SecurityTLS = "tls"
+	// SecurityNone sends in plain text, for local relays and test servers.
+	SecurityNone = "none"
+)
+
+// SMTPOptions configure an SMTP channel.
+type SMTPOptions struct {
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN auth when the username is set.
+	Username string
+	Password string
+	From     string
+	To       []string
+	// Security is one of SecurityStartTLS, SecurityTLS or SecurityNone.
+	Security  string
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+}
+
+// SMTP sends messages as plain text emails.
+type SMTP struct {
+	opts SMTPOptions
+}
+
+// NewSMTP creates an SMTP channel.
+func NewSMTP(opts SMTPOptions) *SMTP {
+	if opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{}
+	} else {
+		opts.TLSConfig = opts.TLSConfig.Clone()
+	}
+	if opts.TLSConfig.ServerName == "" {
+		opts.TLSConfig.ServerName = opts.Host
+	}
+	return &SMTP{opts: opts}
+}
+
+// Send delivers a message to all recipients.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(s.opts.Host, strconv.Itoa(s.opts.Port))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if s.opts.Security == SecurityTLS {
+		conn = tls.Client(conn, s.opts.TLSConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if s.opts.Security == SecurityStartTLS {
+		if err := c.StartTLS(s.opts.TLSConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if s.opts.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := c.Mail(s.opts.From); err != nil {
+		return err
+	}
+	for _, to := range s.opts.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.compose(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose formats the message as an email.
+func (s *SMTP) compose(msg Message) []byte {
+	var b bytes.Buffer
+	header := func(name, value string) {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", s.opts.From)
+	header("To", strings.Join(s.opts.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Title))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	if msg.Priority == "high" {
+		header("X-Priority", "1")
+		header("Importance", "high")
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the SMTP stand-in received in a session.
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is an SMTP stand-in on a local port. It rejects the recipients in reject.
+type smtpServer struct {
+	ln       net.Listener
+	reject   string
+	sessions chan smtpSession
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, sessions: make(chan smtpSession, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	var session smtpSession
+	tp.PrintfLine("220 localhost ESMTP test")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250-8BITMIME")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			plain, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			session.auth = string(plain)
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			session.from = arg
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			if s.reject != "" && strings.Contains(arg, s.reject) {
+				tp.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+			session.to = append(session.to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			session.data = string(data)
+			tp.PrintfLine("250 OK")
+			s.sessions <- session
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) options() SMTPOptions {
+	return SMTPOptions{
+		Host:     "127.0.0.1",
+		Port:     s.port(),
+		From:     "doors@example.com",
+		To:       []string{"alice@example.com", "bob@example.com"},
+		Security: SecurityNone,
+		Timeout:  5 * time.Second,
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	srv := newSMTPServer(t)
+	opts := srv.options()
+	opts.Username, opts.Password = "doors", "secret"
+	err := NewSMTP(opts).Send(context.Background(), Message{
+		Title:    "Front door forced open – now",
+		Body:     "Front door opened\nwhile it was locked",
+		Priority: "high",
+	})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	session := <-srv.sessions
+	if session.auth != "\x00doors\x00secret" {
+		t.Errorf("authenticated with %q", session.auth)
+	}
+	if !strings.HasPrefix(session.from, "FROM:<doors@example.com>") || strings.Join(session.to, ",") != "TO:<alice@example.com>,TO:<bob@example.com>" {
+		t.Errorf("envelope = %s %q", session.from, session.to)
+	}
+	// the dot reader turns CRLF into LF
+	header, body, _ := strings.Cut(session.data, "\n\n")
+	for _, want := range []string{
+		"From: doors@example.com",
+		"To: alice@example.com, bob@example.com",
+		"Subject: =?utf-8?q?Front_door_forced_open_=E2=80=93_now?=",
+		"X-Priority: 1",
+		`Content-Type: text/plain; charset="utf-8"`,
+	} {
+		if !strings.Contains(header, want+"\n") {
+			t.Errorf("header lacks %q:\n%s", want, header)
+		}
+	}
+	if body != "Front door opened\nwhile it was locked\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestSMTPRecipientRejected(t *testing.T) {
+	srv := newSMTPServer(t)
+	srv.reject = "bob@"
+	err := NewSMTP(srv.options()).Send(context.Background(), Message{Title: "t", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "recipient bob@example.com rejected") {
+		t.Errorf("Send() = %v, want the recipient rejected", err)
+	}
+}
+
+func TestSMTPStartTLSRequired(t *testing.T) {
+	srv := newSMTPServer(t)
+	opts := srv.options()
+	opts.Security = SecurityStartTLS
+	err := NewSMTP(opts).Send(context.Background(), Message{Title: "t", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS failed") {
+		t.Errorf("Send() to a server without STARTTLS = %v, want it to fail", err)
+	}
+	select {
+	case session := <-srv.sessions:
+		t.Errorf("message sent in plain text: %+v", session)
+	default:
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// a server that accepts the connection but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			bufio.NewReader(conn).ReadByte()
+			conn.Close()
+		}
+	}()
+	port, _ := strconv.Atoi(strings.TrimPrefix(ln.Addr().String(), "127.0.0.1:"))
+	s := NewSMTP(SMTPOptions{Host: "127.0.0.1", Port: port, Security: SecurityNone, Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	if err := s.Send(context.Background(), Message{}); err == nil {
+		t.Error("Send() to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send() took %s, want it bounded by the timeout", elapsed)
+	}
+}
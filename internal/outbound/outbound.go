@@ -0,0 +1,193 @@
+// Package outbound delivers door events to HTTP endpoints such as Slack, Teams, ntfy or
+// Node-RED, with a templated body, HMAC signing and retries.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+	"github.com/K-MTG/unifi-access-hubitat-middleware/pkg/utils"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of a delivery, formatted like the
+// signature of UniFi Access webhooks: t=<unix time>,v1=<hex HMAC of "<unix time>.<body>">.
+const SignatureHeader = "X-UAHM-Signature"
+
+// Filter selects the events delivered to a target. Empty lists match every event, values
+// are compared case-insensitively.
+type Filter struct {
+	// Doors are UAC door IDs or names.
+	Doors   []string
+	Types   []string
+	Actors  []string
+	Sources []string
+	Results []string
+}
+
+// Match reports whether an event passes the filter.
+func (f Filter) Match(e events.Event) bool {
+	return matches(f.Doors, e.DoorID, e.Door) &&
+		matches(f.Types, e.Type) &&
+		matches(f.Actors, e.Actor) &&
+		matches(f.Sources, e.Source) &&
+		matches(f.Results, e.Result)
+}
+
+func matches(allowed []string, values ...string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(allowed, func(a string) bool {
+		return slices.ContainsFunc(values, func(v string) bool { return v != "" && strings.EqualFold(a, v) })
+	})
+}
+
+// ParseBody parses a body template. The event is the data of the template.
+func ParseBody(name, text string) (*template.Template, error) {
+	return utils.ParseTemplate(name, text)
+}
+
+// Options configure a target.
+type Options struct {
+	Name    string
+	URL     string
+	Method  string
+	Headers map[string]string
+	// Body renders the request body, the event as JSON when nil.
+	Body *template.Template
+	// Secret signs the deliveries when set.
+	Secret string
+	Filter Filter
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// Attempts is how many times a delivery is tried before it is given up.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for every further one.
+	Backoff   time.Duration
+	TLSConfig *tls.Config
+}
+
+// Target is an endpoint events are delivered to.
+type Target struct {
+	opts       Options
+	httpClient *http.Client
+}
+
+// NewTarget creates a target.
+func NewTarget(opts Options) *Target {
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+	if opts.Attempts < 1 {
+		opts.Attempts = 1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLSConfig
+	return &Target{
+		opts:       opts,
+		httpClient: &http.Client{Timeout: opts.Timeout, Transport: transport},
+	}
+}
+
+// Name returns the name of the target.
+func (t *Target) Name() string {
+	return t.opts.Name
+}
+
+// Wants reports whether the event passes the filter of the target.
+func (t *Target) Wants(e events.Event) bool {
+	return t.opts.Filter.Match(e)
+}
+
+// Render returns the body delivered for an event.
+func (t *Target) Render(e events.Event) ([]byte, error) {
+	if t.opts.Body == nil {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := t.opts.Body.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// permanentError is a delivery failure that retrying won't fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Deliver renders and sends an event, retrying with exponential backoff on network errors,
+// 429 and 5xx responses until the attempts are used up or ctx is cancelled.
+func (t *Target) Deliver(ctx context.Context, e events.Event) error {
+	body, err := t.Render(e)
+	if err != nil {
+		return fmt.Errorf("failed to render body: %w", err)
+	}
+	backoff := t.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err = t.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= t.opts.Attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single attempt.
+func (t *Target) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, t.opts.Method, t.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	if t.opts.Body == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range t.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if t.opts.Secret != "" {
+		now := time.Now().Unix()
+		mac := hmac.New(sha256.New, []byte(t.opts.Secret))
+		mac.Write([]byte(strconv.FormatInt(now, 10) + "."))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", now, hex.EncodeToString(mac.Sum(nil))))
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return permanentError{err}
+}
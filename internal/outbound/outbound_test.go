@@ -0,0 +1,168 @@
+package outbound
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/K-MTG/unifi-access-hubitat-middleware/internal/events"
+)
+
+// receivedRequest is what the receiver got.
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver answers the requests with statuses in turn, the last one once they are used up.
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, <-chan receivedRequest, *atomic.Int32) {
+	t.Helper()
+	requests := make(chan receivedRequest, 10)
+	var count atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- receivedRequest{r.Header, body}
+		n := int(count.Add(1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, &count
+}
+
+func TestSignature(t *testing.T) {
+	srv, requests, _ := newReceiver(t, http.StatusOK)
+	target := NewTarget(Options{URL: srv.URL, Secret: "s3cret"})
+	e := events.Event{Type: "unlock", DoorID: "d1", Door: "Front"}
+	if err := target.Deliver(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+
+	sig := r.header.Get(SignatureHeader)
+	ts, v1, ok := strings.Cut(sig, ",v1=")
+	if !ok || !strings.HasPrefix(ts, "t=") {
+		t.Fatalf("%s = %q, want t=<unix time>,v1=<hex>", SignatureHeader, sig)
+	}
+	ts = strings.TrimPrefix(ts, "t=")
+	if sec, err := strconv.ParseInt(ts, 10, 64); err != nil || time.Since(time.Unix(sec, 0)).Abs() > time.Minute {
+		t.Errorf("signature time %q is not the current unix time", ts)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(r.body)
+	if want := hex.EncodeToString(mac.Sum(nil)); v1 != want {
+		t.Errorf("v1 = %s, want %s", v1, want)
+	}
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	srv, requests, _ := newReceiver(t, http.StatusOK)
+	if err := NewTarget(Options{URL: srv.URL}).Deliver(context.Background(), events.Event{Type: "unlock"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.header.Get(SignatureHeader) != "" {
+		t.Errorf("%s = %q without a secret, want none", SignatureHeader, r.header.Get(SignatureHeader))
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		ok       bool
+		tries    int32
+	}{
+		{"success", []int{http.StatusNoContent}, 3, true, 1},
+		{"too many requests", []int{http.StatusTooManyRequests, http.StatusOK}, 3, true, 2},
+		{"server errors", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 3, true, 3},
+		{"attempts used up", []int{http.StatusInternalServerError}, 3, false, 3},
+		// a rejected request is not tried again
+		{"bad request", []int{http.StatusBadRequest, http.StatusOK}, 3, false, 1},
+		{"not found", []int{http.StatusNotFound, http.StatusOK}, 3, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, count := newReceiver(t, tt.statuses...)
+			target := NewTarget(Options{URL: srv.URL, Attempts: tt.attempts, Backoff: time.Millisecond})
+			err := target.Deliver(context.Background(), events.Event{Type: "unlock"})
+			if (err == nil) != tt.ok {
+				t.Errorf("Deliver() = %v, want success %t", err, tt.ok)
+			}
+			if got := count.Load(); got != tt.tries {
+				t.Errorf("tried %d times, want %d", got, tt.tries)
+			}
+		})
+	}
+}
+
+func TestDeliverBackoff(t *testing.T) {
+	srv, _, count := newReceiver(t, http.StatusServiceUnavailable)
+	target := NewTarget(Options{URL: srv.URL, Attempts: 3, Backoff: 20 * time.Millisecond})
+	start := time.Now()
+	if err := target.Deliver(context.Background(), events.Event{Type: "unlock"}); err == nil {
+		t.Fatal("Deliver() succeeded, want the attempts used up")
+	}
+	// 20ms before the second attempt and 40ms before the third
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("three attempts took %s, want the backoff doubled between them", elapsed)
+	}
+	if count.Load() != 3 {
+		t.Errorf("tried %d times, want 3", count.Load())
+	}
+}
+
+func TestDeliverCancelled(t *testing.T) {
+	srv, _, count := newReceiver(t, http.StatusServiceUnavailable)
+	target := NewTarget(Options{URL: srv.URL, Attempts: 5, Backoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := target.Deliver(ctx, events.Event{Type: "unlock"}); err == nil {
+		t.Fatal("Deliver() succeeded, want the last error")
+	}
+	if count.Load() != 1 {
+		t.Errorf("tried %d times, want no retry once cancelled", count.Load())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	e := events.Event{Type: "access_denied", DoorID: "d1", Door: "Front Door", Actor: "Alice", Source: "uac", Result: "Access Denied"}
+	tests := []struct {
+		name   string
+		filter Filter
+		match  bool
+	}{
+		{"empty", Filter{}, true},
+		{"door ID", Filter{Doors: []string{"d2", "d1"}}, true},
+		{"door name in any case", Filter{Doors: []string{"front door"}}, true},
+		{"other door", Filter{Doors: []string{"Back Door"}}, false},
+		{"type", Filter{Types: []string{"unlock", "ACCESS_DENIED"}}, true},
+		{"other type", Filter{Types: []string{"unlock"}}, false},
+		{"actor", Filter{Actors: []string{"alice"}}, true},
+		{"source", Filter{Sources: []string{"hubitat"}}, false},
+		{"result", Filter{Results: []string{"access denied"}}, true},
+		{"all fields must match", Filter{Doors: []string{"d1"}, Types: []string{"unlock"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(e); got != tt.match {
+			t.Errorf("%s: Match() = %t, want %t", tt.name, got, tt.match)
+		}
+	}
+
+	// an empty value of the event doesn't match
+	if (Filter{Actors: []string{""}}).Match(events.Event{Type: "unlock"}) {
+		t.Error("an empty actor matched the empty actor of the event")
+	}
+}
@@ -0,0 +1,150 @@
+// Package schedule computes when daily transitions happen on selected weekdays in a time zone,
+// with holidays that are skipped or that are the only days a transition happens on.
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What to do on holidays.
+const (
+	// HolidaysSkip skips the holidays, the default.
+	HolidaysSkip = "skip"
+	// HolidaysRun treats holidays like any other day.
+	HolidaysRun = "run"
+	// HolidaysOnly runs on the holidays only, e.g. to keep the doors locked.
+	HolidaysOnly = "only"
+)
+
+// maxDaysAhead bounds the search for the next day on which a transition happens.
+const maxDaysAhead = 2 * 366
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWeekday parses a weekday given by its first three letters, e.g. "mon", or its full name.
+func ParseWeekday(value string) (time.Weekday, error) {
+	v := strings.ToLower(value)
+	if len(v) >= 3 {
+		if d, ok := weekdays[v[:3]]; ok && strings.HasPrefix(strings.ToLower(d.String()), v) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q, must be mon, tue, wed, thu, fri, sat or sun", value)
+}
+
+// ParseClock parses a time of day formatted as HH:MM and returns it as the time since midnight.
+func ParseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be formatted as HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Holidays are dates of a single year or recurring every year.
+type Holidays struct {
+	dates  map[string]bool // 2006-01-02
+	yearly map[string]bool // 01-02
+}
+
+// ParseHolidays parses dates formatted as YYYY-MM-DD, or as MM-DD for a date of every year.
+func ParseHolidays(values []string) (Holidays, error) {
+	h := Holidays{dates: make(map[string]bool), yearly: make(map[string]bool)}
+	for _, v := range values {
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			h.dates[v] = true
+			continue
+		}
+		// a leap year, so that 02-29 is accepted
+		if _, err := time.Parse("2006-01-02", "2024-"+v); err == nil && len(v) == len("01-02") {
+			h.yearly[v] = true
+			continue
+		}
+		return Holidays{}, fmt.Errorf("invalid holiday %q, must be formatted as YYYY-MM-DD or MM-DD", v)
+	}
+	return h, nil
+}
+
+// Contains reports whether the date of t, in its own location, is a holiday.
+func (h Holidays) Contains(t time.Time) bool {
+	return h.dates[t.Format("2006-01-02")] || h.yearly[t.Format("01-02")]
+}
+
+// Days selects the days on which transitions happen.
+type Days struct {
+	// Weekdays are the weekdays on which transitions happen, every day when none is set.
+	Weekdays [7]bool
+	Holidays Holidays
+	// OnHolidays is HolidaysSkip, HolidaysRun or HolidaysOnly.
+	OnHolidays string
+}
+
+// Includes reports whether transitions happen on the date of t, in its own location.
+func (d Days) Includes(t time.Time) bool {
+	if d.Weekdays != [7]bool{} && !d.Weekdays[t.Weekday()] {
+		return false
+	}
+	switch d.OnHolidays {
+	case HolidaysRun:
+		return true
+	case HolidaysOnly:
+		return d.Holidays.Contains(t)
+	default:
+		return !d.Holidays.Contains(t)
+	}
+}
+
+// Next returns the first time after t at which the time of day at, since midnight, occurs on
+// one of the days in loc, and false when there is none in the next two years. A time of day
+// skipped by a daylight saving time change is moved forward by the change.
+func Next(t time.Time, at time.Duration, days Days, loc *time.Location) (time.Time, bool) {
+	local := t.In(loc)
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	for i := 0; i <= maxDaysAhead; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, loc)
+		if !days.Includes(day) {
+			continue
+		}
+		next := occurrence(day, hour, minute, loc)
+		if next.After(t) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Previous returns the last time up to and including t at which the time of day at occurs on one
+// of the days in loc, and false when there is none in the last two years. It is the counterpart
+// of Next, so a transition is in effect from Previous until Next.
+func Previous(t time.Time, at time.Duration, days Days, loc *time.Location) (time.Time, bool) {
+	local := t.In(loc)
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	for i := 0; i <= maxDaysAhead; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()-i, 0, 0, 0, 0, loc)
+		if !days.Includes(day) {
+			continue
+		}
+		prev := occurrence(day, hour, minute, loc)
+		if !prev.After(t) {
+			return prev, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// occurrence returns the time of day hour:minute on the date of day in loc. A time of day skipped
+// by a daylight saving time change is moved forward by the change.
+func occurrence(day time.Time, hour, minute int, loc *time.Location) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	if t.Hour() != hour || t.Minute() != minute {
+		// time.Date may use the offset of either side of the change, the one before it moves it forward
+		_, offset := t.Add(-12 * time.Hour).Zone()
+		t = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.FixedZone("", offset)).In(loc)
+	}
+	return t
+}
@@ -0,0 +1,95 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	for _, value := range []string{"07:30", "7:30"} {
+		if d, err := ParseClock(value); err != nil || d != 7*time.Hour+30*time.Minute {
+			t.Errorf("ParseClock(%q) = %s, %v", value, d, err)
+		}
+	}
+	for _, value := range []string{"24:00", "07:60", "0730", ""} {
+		if _, err := ParseClock(value); err == nil || !strings.Contains(err.Error(), "HH:MM") {
+			t.Errorf("ParseClock(%q) = %v, want an error", value, err)
+		}
+	}
+}
+
+func TestPreviousAndNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available:", err)
+	}
+	weekdays := Days{Weekdays: [7]bool{time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Friday: true}}
+	holidays, err := ParseHolidays([]string{"12-25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		t          time.Time
+		at         time.Duration
+		days       Days
+		prev, next time.Time
+	}{
+		{
+			name: "same day",
+			t:    time.Date(2026, 10, 14, 12, 0, 0, 0, newYork), // a Wednesday
+			at:   8 * time.Hour, days: weekdays,
+			prev: time.Date(2026, 10, 14, 8, 0, 0, 0, newYork),
+			next: time.Date(2026, 10, 15, 8, 0, 0, 0, newYork),
+		},
+		{
+			name: "at the transition",
+			t:    time.Date(2026, 10, 14, 8, 0, 0, 0, newYork),
+			at:   8 * time.Hour, days: weekdays,
+			prev: time.Date(2026, 10, 14, 8, 0, 0, 0, newYork),
+			next: time.Date(2026, 10, 15, 8, 0, 0, 0, newYork),
+		},
+		{
+			name: "over the weekend",
+			t:    time.Date(2026, 10, 18, 12, 0, 0, 0, newYork), // a Sunday
+			at:   8 * time.Hour, days: weekdays,
+			prev: time.Date(2026, 10, 16, 8, 0, 0, 0, newYork),
+			next: time.Date(2026, 10, 19, 8, 0, 0, 0, newYork),
+		},
+		{
+			name: "holiday skipped",
+			t:    time.Date(2026, 12, 25, 12, 0, 0, 0, newYork),
+			at:   8 * time.Hour, days: Days{Holidays: holidays},
+			prev: time.Date(2026, 12, 24, 8, 0, 0, 0, newYork),
+			next: time.Date(2026, 12, 26, 8, 0, 0, 0, newYork),
+		},
+		{
+			name: "time skipped by daylight saving",
+			t:    time.Date(2026, 3, 8, 12, 0, 0, 0, newYork),
+			at:   2*time.Hour + 30*time.Minute, days: Days{},
+			prev: time.Date(2026, 3, 8, 3, 30, 0, 0, newYork),
+			next: time.Date(2026, 3, 9, 2, 30, 0, 0, newYork),
+		},
+		{
+			name: "before a time skipped by daylight saving",
+			t:    time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			at:   2*time.Hour + 30*time.Minute, days: Days{},
+			prev: time.Date(2026, 3, 7, 2, 30, 0, 0, newYork),
+			next: time.Date(2026, 3, 8, 3, 30, 0, 0, newYork),
+		},
+	}
+	for _, tt := range tests {
+		if prev, ok := Previous(tt.t, tt.at, tt.days, newYork); !ok || !prev.Equal(tt.prev) {
+			t.Errorf("%s: Previous() = %s, %t, want %s", tt.name, prev, ok, tt.prev)
+		}
+		if next, ok := Next(tt.t, tt.at, tt.days, newYork); !ok || !next.Equal(tt.next) {
+			t.Errorf("%s: Next() = %s, %t, want %s", tt.name, next, ok, tt.next)
+		}
+	}
+
+	onlyHolidays := Days{Holidays: Holidays{}, OnHolidays: HolidaysOnly}
+	if prev, ok := Previous(time.Now(), 0, onlyHolidays, newYork); ok {
+		t.Errorf("Previous() without holidays = %s, want none", prev)
+	}
+}
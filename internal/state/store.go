@@ -0,0 +1,113 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists small pieces of state, such as trusted certificate fingerprints,
+// as a single JSON document. Every change is written to disk right away.
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	data     map[string]json.RawMessage
+	readOnly bool
+}
+
+// Open loads the store at path, creating an empty one when the file does not exist yet.
+func Open(path string) (*Store, error) {
+	return open(path, false)
+}
+
+// OpenReadOnly loads the store at path like Open, but keeps changes in memory and never
+// writes the file.
+func OpenReadOnly(path string) (*Store, error) {
+	return open(path, true)
+}
+
+func open(path string, readOnly bool) (*Store, error) {
+	s := &Store{path: path, data: make(map[string]json.RawMessage), readOnly: readOnly}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("decoding state file %s failed: %w", path, err)
+	}
+	return s, nil
+}
+
+// ReadOnly reports whether changes to the store are not saved.
+func (s *Store) ReadOnly() bool {
+	return s.readOnly
+}
+
+// Get decodes the value stored under key into v. It reports whether the key exists.
+func (s *Store) Get(key string, v any) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("decoding state %s failed: %w", key, err)
+	}
+	return true, nil
+}
+
+// Set stores v under key and saves the store.
+func (s *Store) Set(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding state %s failed: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = raw
+	return s.save()
+}
+
+// Delete removes key and saves the store.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return s.save()
+}
+
+// save writes the store to a temporary file and renames it over the previous one,
+// so a crash never leaves a partially written file behind.
+func (s *Store) save() error {
+	if s.readOnly {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -35,6 +36,32 @@ type DoorLockRule struct {
 	EndedTime float64 `json:"ended_time"`
 }
 
+// DoorGroup represents a door group from UniFi Access
+type DoorGroup struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	GroupType string              `json:"group_type"`
+	Resources []DoorGroupResource `json:"resources"`
+}
+
+// DoorGroupResource is a member of a door group, a door when its type is "door"
+type DoorGroupResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// DoorIDs returns the IDs of the doors of the group
+func (g *DoorGroup) DoorIDs() []string {
+	var ids []string
+	for _, r := range g.Resources {
+		if r.Type == "door" {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
 // Webhook represents a single webhook endpoint from UniFi Access
 type Webhook struct {
 	ID       *string           `json:"id,omitempty"`
@@ -49,16 +76,39 @@ type Client struct {
 	baseURL string
 	apiKey  string
 	client  *http.Client
+	observe RequestObserver
+}
+
+// RequestObserver is called after every API request with the endpoint path, where IDs are
+// replaced by ":id", the response status (0 when no response was received) and the duration.
+type RequestObserver func(method, endpoint string, status int, duration time.Duration)
+
+// SetRequestObserver sets the function called after every API request.
+func (c *Client) SetRequestObserver(observe RequestObserver) {
+	c.observe = observe
+}
+
+// endpointOf returns path with the door and webhook IDs replaced by ":id".
+func endpointOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "doors" || segments[i-1] == "endpoints" {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
 }
 
-func NewClient(baseUrl string, apiKey string) *Client {
+// NewClient creates a UAC API client. tlsConfig controls how the certificate of the
+// controller is verified, nil verifies it against the system CAs.
+func NewClient(baseUrl string, apiKey string, tlsConfig *tls.Config) *Client {
 	return &Client{
 		baseURL: baseUrl,
 		apiKey:  apiKey,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: tlsConfig,
 			},
 		},
 	}
@@ -75,7 +125,15 @@ func (c *Client) doRequest(method, path string, body io.Reader) (*http.Response,
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
 
+	start := time.Now()
 	resp, err := c.client.Do(req)
+	if c.observe != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		c.observe(method, endpointOf(path), status, time.Since(start))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("%s request to %s failed: %w", method, url, err)
 	}
@@ -126,6 +184,27 @@ func (c *Client) FetchAllDoors() ([]Door, error) {
 	return apiResp.Data, nil
 }
 
+// FetchDoorGroups retrieves all door groups
+func (c *Client) FetchDoorGroups() ([]DoorGroup, error) {
+	// permission key - view:space
+	resp, err := c.getRequest("/api/v1/developer/door_groups")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp Response[[]DoorGroup]
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("decoding response failed: %w", err)
+	}
+
+	if apiResp.Code != "SUCCESS" {
+		return nil, fmt.Errorf("API error: %s", apiResp.Msg)
+	}
+
+	return apiResp.Data, nil
+}
+
 // FetchDoor retrieves a specific door by ID
 func (c *Client) FetchDoor(doorID string) (*Door, error) {
 	// permission key - view:space
@@ -179,11 +258,23 @@ func (c *Client) AssertToggleDoorUnlock(doorID string) error {
 	return nil
 }
 
+// lockRuleRequest is the body of a lock rule update. Interval is the number of minutes a
+// custom rule keeps the door unlocked.
+type lockRuleRequest struct {
+	Type     string `json:"type"`
+	Interval int    `json:"interval,omitempty"`
+}
+
 // setDoorLockRule updates the lock rule of a door
 func (c *Client) setDoorLockRule(doorID, ruleType string) error {
+	return c.putDoorLockRule(doorID, lockRuleRequest{Type: ruleType})
+}
+
+// putDoorLockRule sends a lock rule update for a door
+func (c *Client) putDoorLockRule(doorID string, rule lockRuleRequest) error {
 	url := fmt.Sprintf("/api/v1/developer/doors/%s/lock_rule", doorID)
 
-	body, err := json.Marshal(map[string]string{"type": ruleType})
+	body, err := json.Marshal(rule)
 	if err != nil {
 		return fmt.Errorf("marshaling request body failed: %w", err)
 	}
@@ -254,6 +345,25 @@ func (c *Client) AssertLockDoor(doorID string) error {
 	return c.setDoorLockRule(doorID, "reset")
 }
 
+// UnlockDoorFor keeps a door unlocked for the given number of minutes, after which UAC locks it again
+func (c *Client) UnlockDoorFor(doorID string, minutes int) error {
+	if minutes <= 0 {
+		return fmt.Errorf("invalid unlock interval %d, must be at least one minute", minutes)
+	}
+	// permission key - edit:space
+	return c.putDoorLockRule(doorID, lockRuleRequest{Type: "custom", Interval: minutes})
+}
+
+// SetDoorLockRule sets the lock rule of a door: keep_unlock, keep_lock, custom, reset or lock_early.
+// Minutes is how long a custom rule keeps the door unlocked and ignored by the others
+func (c *Client) SetDoorLockRule(doorID, ruleType string, minutes int) error {
+	if ruleType == "custom" {
+		return c.UnlockDoorFor(doorID, minutes)
+	}
+	// permission key - edit:space
+	return c.setDoorLockRule(doorID, ruleType)
+}
+
 // FetchWebhookEndpoints retrieves webhook endpoints
 func (c *Client) FetchWebhookEndpoints() ([]Webhook, error) {
 	// permission key - view:webhook
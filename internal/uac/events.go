@@ -19,21 +19,21 @@ import (
 // WebhookHandler handles incoming UniFi Access webhook requests
 type WebhookHandler struct {
 	secret    string
-	authToken string
+	authorize func(token string) bool
 	onEvent   func(WebhookEvent)
 	wg        *sync.WaitGroup
 }
 
-// NewWebhookHandler creates a new handler
-func NewWebhookHandler(secret string, authToken string, onEvent func(WebhookEvent), wg *sync.WaitGroup) *WebhookHandler {
-	return &WebhookHandler{secret: secret, authToken: authToken, onEvent: onEvent, wg: wg}
+// NewWebhookHandler creates a new handler. authorize checks the token sent in the Authorization header.
+func NewWebhookHandler(secret string, authorize func(token string) bool, onEvent func(WebhookEvent), wg *sync.WaitGroup) *WebhookHandler {
+	return &WebhookHandler{secret: secret, authorize: authorize, onEvent: onEvent, wg: wg}
 }
 
 // ServeHTTP implements http.Handler for WebhookHandler
 func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	signature := r.Header.Get("Signature")
 	authHeader := r.Header.Get("Authorization")
-	if authHeader != h.authToken {
+	if !h.authorize(authHeader) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		log.Printf("Invalid auth token")
 		return
@@ -46,7 +46,7 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	rawEvent, err := constructEvent(body, signature, h.secret)
+	rawEvent, signedAt, err := constructEvent(body, signature, h.secret)
 	if err != nil {
 		log.Printf("Signature validation failed: %v", err)
 		http.Error(w, fmt.Sprintf("Signature validation failed: %s", err), http.StatusUnauthorized)
@@ -59,6 +59,7 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid event JSON", http.StatusBadRequest)
 		return
 	}
+	event.SignedAt = signedAt
 
 	// Send to callback asynchronously
 	h.wg.Add(1)
@@ -76,6 +77,8 @@ type WebhookEvent struct {
 	Event         string          `json:"event"`
 	EventObjectID string          `json:"event_object_id"`
 	Data          json.RawMessage `json:"data"`
+	// SignedAt is the time UniFi Access signed the webhook delivery.
+	SignedAt time.Time `json:"-"`
 }
 
 // --- Internal signature verification logic ---
@@ -135,25 +138,26 @@ func computeSignature(t time.Time, payload []byte, secret string) []byte {
 	return mac.Sum(nil)
 }
 
-func validatePayload(payload []byte, sigHeader string, secret string) error {
+func validatePayload(payload []byte, sigHeader string, secret string) (*signedHeader, error) {
 	header, err := parseSignatureHeader(sigHeader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	expected := computeSignature(header.timestamp, payload, secret)
 	if hmac.Equal(expected, header.signature) {
-		return nil
+		return header, nil
 	}
-	return ErrNoValidSignature
+	return nil, ErrNoValidSignature
 }
 
-func constructEvent(payload []byte, sigHeader string, secret string) (json.RawMessage, error) {
-	if err := validatePayload(payload, sigHeader, secret); err != nil {
-		return nil, err
+func constructEvent(payload []byte, sigHeader string, secret string) (json.RawMessage, time.Time, error) {
+	header, err := validatePayload(payload, sigHeader, secret)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 	var e json.RawMessage
 	if err := json.Unmarshal(payload, &e); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to parse JSON: %w", err)
 	}
-	return e, nil
+	return e, header.timestamp, nil
 }
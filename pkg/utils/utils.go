@@ -1,5 +1,15 @@
 package utils
 
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
 func StringSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -34,3 +44,43 @@ func StringMapsEqual(a, b map[string]string) bool {
 
 	return true
 }
+
+// RandomToken returns a hex encoded random token built from n random bytes.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// TokenMatches reports whether candidate equals one of tokens. The comparison takes the
+// same time whichever token matches, and leaks neither the position nor the token lengths.
+func TokenMatches(candidate string, tokens []string) bool {
+	if candidate == "" {
+		return false
+	}
+	c := sha256.Sum256([]byte(candidate))
+	match := 0
+	for _, token := range tokens {
+		t := sha256.Sum256([]byte(token))
+		match |= subtle.ConstantTimeCompare(c[:], t[:])
+	}
+	return match == 1
+}
+
+// templateFuncs are available in the templates of notifications and outbound webhooks, e.g.
+// {{json .Door}} to quote a value in a JSON body.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParseTemplate parses a text template with the template functions. Missing map keys are errors.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}